@@ -16,6 +16,9 @@ var (
 	flagBigEndian = flag.Bool(
 		"big-endian", false,
 		"Specifies input is big-endian (little endian is the default).")
+	flagVersion = flag.String(
+		"version", "1.7",
+		"DCPU-16 specification version of the input (1.1 or 1.7).")
 )
 
 type ReaderWordLoader struct {
@@ -42,6 +45,11 @@ func main() {
 		flag.PrintDefaults()
 	}
 
+	instructionSet, err := core.NewInstructionSet(*flagVersion)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	infile, err := os.Open(flag.Arg(0))
 	if err != nil {
 		log.Fatal(err)
@@ -60,10 +68,8 @@ func main() {
 	}
 	defer outfile.Close()
 
-	var instructionSet core.D16InstructionSet
-
 	for {
-		instruction, err := core.InstructionLoad(wordLoader, &instructionSet)
+		instruction, err := instructionSet.Decode(wordLoader)
 		if err != nil {
 			if err == io.EOF {
 				break
@@ -20,6 +20,8 @@ type CPU interface {
 	DecReadSP() Word
 	EX() Word
 	WriteEX(value Word)
+	IA() Word
+	WriteIA(value Word)
 }
 
 func CPUEquals(a, b CPU) bool {
@@ -28,7 +30,7 @@ func CPUEquals(a, b CPU) bool {
 			return false
 		}
 	}
-	return a.PC() == b.PC() && a.SP() == b.SP() && a.EX() == b.EX()
+	return a.PC() == b.PC() && a.SP() == b.SP() && a.EX() == b.EX() && a.IA() == b.IA()
 }
 
 type Memory interface {
@@ -41,23 +43,294 @@ type MachineState interface {
 	InstructionSet
 	CPU
 	Memory
+	Interrupts
+	Hardware
+	FaultHandler
+	InstructionObserver
+}
+
+// InstructionObserver is notified by Step of the start and end of each
+// instruction.
+type InstructionObserver interface {
+	// BeginInstruction is called before delivering interrupts and loading the
+	// instruction, and again if an interrupt is delivered.
+	BeginInstruction()
+	// EndInstruction is called after each instruction, including those that
+	// fault, with the number of ticks taken. A non-nil error is returned by
+	// Step, unless Step already has an error to return and this error is not a
+	// *Fault.
+	EndInstruction(ticks int) error
 }
 
 type D16MachineState struct {
+	// ISA is the instruction set to use. If nil, the embedded
+	// D16InstructionSet (DCPU-16 1.7) is used.
+	ISA InstructionSet
+	// Memory is the memory to use. If nil, the embedded D16MemoryState is
+	// used, including any regions mapped by its MapMemory.
+	Memory Memory
+	// Undo records each step so that it can be undone. If nil, steps are not
+	// recorded.
+	Undo *UndoLog
+	// Trace writes a record of each step. If nil, steps are not traced.
+	Trace *Tracer
+	// Profile counts the cycles of each step. If nil, steps are not profiled.
+	Profile *Profiler
+	// Coverage counts the instructions executed. If nil, coverage is not
+	// recorded.
+	Coverage *Coverage
+
 	D16InstructionSet
 	D16CPU
 	D16MemoryState
+	D16Interrupts
+	D16Hardware
+	FaultPolicy
+	D16Watchpoints
+	D16Protection
+
+	// Address of the instruction being executed by Step.
+	instructionPC Word
+	// Error to be returned by EndInstruction.
+	pendingErr error
+	// Set when a protection fault halts the instruction being executed, whose
+	// remaining writes and interrupts are then discarded, and whose changes
+	// to the CPU are undone by EndInstruction.
+	halting bool
+	// The CPU before the instruction, if memory is protected.
+	instructionCPU D16CPU
+	// Number of cycles executed by Step.
+	cycles uint64
 }
 
 func (state *D16MachineState) Init() {
 	state.D16CPU.Init()
+	state.D16Interrupts = D16Interrupts{}
+}
+
+func (state *D16MachineState) instructionSet() InstructionSet {
+	if state.ISA != nil {
+		return state.ISA
+	}
+	return &state.D16InstructionSet
+}
+
+func (state *D16MachineState) Instruction(word Word) (Instruction, error) {
+	return state.instructionSet().Instruction(word)
+}
+
+func (state *D16MachineState) InstructionByName(name string) (Instruction, bool) {
+	return state.instructionSet().InstructionByName(name)
+}
+
+func (state *D16MachineState) InstructionInfo(name string) (InstructionInfo, bool) {
+	return state.instructionSet().InstructionInfo(name)
+}
+
+func (state *D16MachineState) InstructionInfos() []InstructionInfo {
+	return state.instructionSet().InstructionInfos()
+}
+
+func (state *D16MachineState) Decode(wordLoader WordLoader) (DecodedInstruction, error) {
+	return state.instructionSet().Decode(wordLoader)
+}
+
+func (state *D16MachineState) Encode(name string, b, a Value) ([]Word, error) {
+	return state.instructionSet().Encode(name, b, a)
+}
+
+func (state *D16MachineState) NumExtraWords(word Word) (Word, error) {
+	return state.instructionSet().NumExtraWords(word)
+}
+
+func (state *D16MachineState) Conditional(word Word) bool {
+	return state.instructionSet().Conditional(word)
 }
 
 func (state *D16MachineState) WordLoad() (Word, error) {
-	return state.D16MemoryState.ReadMemory(state.ReadIncPC()), nil
+	address := state.ReadIncPC()
+	if state.D16Watchpoints.watched(address) || state.D16Protection.protected(address) {
+		value, allowed := state.readMemory(WatchExecute, address)
+		if !allowed {
+			if state.halting {
+				return 0, state.pendingErr
+			}
+			return 0, fetchDeniedError
+		}
+		return value, nil
+	}
+	return state.PeekMemory(address), nil
 }
 
 func (state *D16MachineState) SkipWords(count Word) error {
+	if state.Coverage != nil {
+		state.Coverage.skip()
+	}
 	state.WritePC(state.PC() + count)
 	return nil
 }
+
+func (state *D16MachineState) ReadMemory(address Word) Word {
+	if state.D16Watchpoints.watched(address) || state.D16Protection.protected(address) {
+		value, _ := state.readMemory(WatchRead, address)
+		return value
+	}
+	return state.PeekMemory(address)
+}
+
+func (state *D16MachineState) WriteMemory(address Word, value Word) {
+	if state.halting {
+		return
+	}
+	if state.D16Watchpoints.watched(address) || state.D16Protection.protected(address) {
+		state.writeMemory(address, value)
+		return
+	}
+	state.PokeMemory(address, value)
+}
+
+// PeekMemory reads memory without triggering watchpoints or protection.
+func (state *D16MachineState) PeekMemory(address Word) Word {
+	if state.Memory != nil {
+		return state.Memory.ReadMemory(address)
+	}
+	return state.D16MemoryState.ReadMemory(address)
+}
+
+// PokeMemory writes memory without triggering watchpoints or protection.
+func (state *D16MachineState) PokeMemory(address Word, value Word) {
+	if state.Undo != nil && state.Undo.recording {
+		state.Undo.recordWrite(address, state.PeekMemory(address))
+	}
+	if state.Trace != nil {
+		state.Trace.recordWrite(address, value)
+	}
+	state.pokeMemory(address, value)
+}
+
+// pokeMemory writes memory without recording the write in the undo log.
+func (state *D16MachineState) pokeMemory(address Word, value Word) {
+	if state.Memory != nil {
+		state.Memory.WriteMemory(address, value)
+		return
+	}
+	state.D16MemoryState.WriteMemory(address, value)
+}
+
+// readMemory reads or fetches memory, subject to watchpoints and protection.
+// Returns false if the access is denied.
+func (state *D16MachineState) readMemory(access WatchAccess, address Word) (Word, bool) {
+	if !state.allowAccess(access, address) {
+		return 0, false
+	}
+	value := state.PeekMemory(address)
+	if state.D16Watchpoints.watched(address) {
+		state.watch(access, address, value, value)
+	}
+	return value, true
+}
+
+// writeMemory writes memory, subject to watchpoints and protection.
+func (state *D16MachineState) writeMemory(address Word, value Word) {
+	if !state.allowAccess(WatchWrite, address) {
+		return
+	}
+	if !state.D16Watchpoints.watched(address) {
+		state.PokeMemory(address, value)
+		return
+	}
+	old := state.PeekMemory(address)
+	state.PokeMemory(address, value)
+	state.watch(WatchWrite, address, old, value)
+}
+
+// allowAccess returns true if memory protection allows the access, otherwise
+// handles the resulting fault.
+func (state *D16MachineState) allowAccess(access WatchAccess, address Word) bool {
+	protection, denied := state.D16Protection.check(access, address)
+	if !denied {
+		return true
+	}
+	err := &ProtectionError{Access: access, Address: address, Protection: protection}
+	if err := state.HandleFault(state, NewFault(state, state.instructionPC, err)); err != nil {
+		state.halting = true
+		// Faults take precedence over pausing.
+		if _, paused := state.pendingErr.(*WatchpointError); state.pendingErr == nil || paused {
+			state.pendingErr = err
+		}
+	}
+	return false
+}
+
+func (state *D16MachineState) watch(access WatchAccess, address, old, value Word) {
+	if state.halting {
+		return
+	}
+	event := WatchEvent{Access: access, Address: address, PC: state.instructionPC, Old: old, New: value}
+	if state.D16Watchpoints.trigger(state, event) && state.pendingErr == nil {
+		state.pendingErr = &WatchpointError{event}
+	}
+}
+
+// Cycles returns the number of cycles executed by Step.
+func (state *D16MachineState) Cycles() uint64 {
+	return state.cycles
+}
+
+// TriggerInterrupt adds the message to the interrupt queue, unless the
+// instruction being executed has been halted by a protection fault.
+func (state *D16MachineState) TriggerInterrupt(message Word) error {
+	if state.halting {
+		return nil
+	}
+	return state.D16Interrupts.TriggerInterrupt(message)
+}
+
+func (state *D16MachineState) BeginInstruction() {
+	if state.halting {
+		// Halted while delivering an interrupt, so the CPU is restored to
+		// before the interrupt.
+		return
+	}
+	state.instructionPC = state.PC()
+	if len(state.D16Protection.regions) != 0 {
+		state.instructionCPU = state.D16CPU
+	}
+	if state.Undo != nil {
+		state.Undo.begin(state)
+	}
+	if state.Trace != nil {
+		state.Trace.begin(state)
+	}
+	if state.Profile != nil {
+		state.Profile.begin(state)
+	}
+	if state.Coverage != nil {
+		state.Coverage.begin(state)
+	}
+}
+
+func (state *D16MachineState) EndInstruction(ticks int) error {
+	if state.halting {
+		state.D16CPU = state.instructionCPU
+		state.halting = false
+	}
+	state.cycles += uint64(ticks)
+	if state.Undo != nil {
+		state.Undo.end(state, ticks)
+	}
+	if state.Profile != nil {
+		state.Profile.end(state, ticks)
+	}
+	if state.Coverage != nil {
+		state.Coverage.end(state)
+	}
+	err := state.pendingErr
+	state.pendingErr = nil
+	if state.Trace != nil {
+		if traceErr := state.Trace.end(state); traceErr != nil && err == nil {
+			err = traceErr
+		}
+	}
+	return err
+}
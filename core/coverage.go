@@ -0,0 +1,236 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SourceLine is a line of a source file.
+type SourceLine struct {
+	File string
+	Line int
+}
+
+// LineTable maps the addresses of instructions to the source lines that they
+// were assembled from.
+type LineTable map[Word]SourceLine
+
+// LineTableSyntaxError is returned when reading a malformed line of a line
+// table.
+type LineTableSyntaxError struct {
+	Line int
+	Text string
+}
+
+func (err *LineTableSyntaxError) Error() string {
+	return fmt.Sprintf("line table line %d: expected address, file and line, got %q", err.Line, err.Text)
+}
+
+// ReadLineTable reads a line table with an instruction per line, as an address
+// (in decimal, or hex with a 0x prefix), followed by the file name and line
+// number. Blank lines and lines starting with ';' or '#' are ignored.
+func ReadLineTable(r io.Reader) (LineTable, error) {
+	table := make(LineTable)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || text[0] == ';' || text[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 3 {
+			return nil, &LineTableSyntaxError{line, text}
+		}
+		address, err := strconv.ParseUint(fields[0], 0, 16)
+		if err != nil {
+			return nil, &LineTableSyntaxError{line, text}
+		}
+		sourceLine, err := strconv.Atoi(fields[2])
+		if err != nil || sourceLine < 1 {
+			return nil, &LineTableSyntaxError{line, text}
+		}
+		table[Word(address)] = SourceLine{fields[1], sourceLine}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return table, nil
+}
+
+// coverageBranch counts the outcomes of a conditional instruction.
+type coverageBranch struct {
+	taken    uint64
+	notTaken uint64
+}
+
+// Coverage counts the instructions executed at each address by the Step of a
+// D16MachineState that it is set as the Coverage of, and the outcomes of
+// conditional (IFx) instructions. Addresses are those seen by the CPU, before
+// any mapping by PagedMemory.
+type Coverage struct {
+	// Lines maps addresses to source lines for WriteLCOV.
+	Lines LineTable
+	// TestName is the name of the test in the LCOV output.
+	TestName string
+
+	hits     []uint64
+	branches map[Word]*coverageBranch
+
+	stepping    bool
+	pc          Word
+	conditional bool
+	skipped     bool
+}
+
+// NewCoverage creates an empty Coverage.
+func NewCoverage() *Coverage {
+	return &Coverage{
+		hits:     make([]uint64, MemorySize),
+		branches: make(map[Word]*coverageBranch),
+	}
+}
+
+// Hits returns the number of times the instruction at the address was
+// executed.
+func (c *Coverage) Hits(address Word) uint64 {
+	return c.hits[address]
+}
+
+// Branch returns the number of times the conditional instruction at the
+// address was taken (its condition was true, and the next instruction was
+// executed), and not taken (the next instruction was skipped).
+func (c *Coverage) Branch(address Word) (taken, notTaken uint64) {
+	if branch, ok := c.branches[address]; ok {
+		return branch.taken, branch.notTaken
+	}
+	return 0, 0
+}
+
+func (c *Coverage) begin(state *D16MachineState) {
+	// Called again after delivering an interrupt, for the instruction of the
+	// interrupt handler.
+	c.stepping = true
+	c.pc = state.PC()
+	c.conditional = state.Conditional(state.PeekMemory(c.pc))
+	c.skipped = false
+}
+
+// skip records that InstructionSkip skipped instructions.
+func (c *Coverage) skip() {
+	c.skipped = true
+}
+
+func (c *Coverage) end(state *D16MachineState) {
+	if !c.stepping {
+		return
+	}
+	c.stepping = false
+	c.hits[c.pc]++
+	if !c.conditional || state.PC() == c.pc {
+		return
+	}
+	branch := c.branches[c.pc]
+	if branch == nil {
+		branch = &coverageBranch{}
+		c.branches[c.pc] = branch
+	}
+	if c.skipped {
+		branch.notTaken++
+	} else {
+		branch.taken++
+	}
+}
+
+// lcovLine is the coverage of a source line.
+type lcovLine struct {
+	line     int
+	hits     uint64
+	branches []lcovBranch
+}
+
+type lcovBranch struct {
+	executed bool
+	taken    uint64
+	notTaken uint64
+}
+
+// WriteLCOV writes the coverage of the source lines in Lines as an LCOV
+// tracefile. The hits of a line are those of its most executed instruction.
+// Conditional instructions are found by reading the program from the memory of
+// state, and each has two branches, taken and not taken.
+func (c *Coverage) WriteLCOV(w io.Writer, state MachineState) error {
+	memory := rawMemoryOf(state)
+	files := make(map[string]map[int]*lcovLine)
+	addresses := make([]Word, 0, len(c.Lines))
+	for address := range c.Lines {
+		addresses = append(addresses, address)
+	}
+	sort.Slice(addresses, func(i, j int) bool { return addresses[i] < addresses[j] })
+	for _, address := range addresses {
+		source := c.Lines[address]
+		lines := files[source.File]
+		if lines == nil {
+			lines = make(map[int]*lcovLine)
+			files[source.File] = lines
+		}
+		line := lines[source.Line]
+		if line == nil {
+			line = &lcovLine{line: source.Line}
+			lines[source.Line] = line
+		}
+		hits := c.hits[address]
+		if hits > line.hits {
+			line.hits = hits
+		}
+		if state.Conditional(memory.ReadMemory(address)) {
+			taken, notTaken := c.Branch(address)
+			line.branches = append(line.branches, lcovBranch{hits > 0, taken, notTaken})
+		}
+	}
+
+	fileNames := make([]string, 0, len(files))
+	for file := range files {
+		fileNames = append(fileNames, file)
+	}
+	sort.Strings(fileNames)
+
+	bw := bufio.NewWriter(w)
+	for _, file := range fileNames {
+		lines := make([]*lcovLine, 0, len(files[file]))
+		for _, line := range files[file] {
+			lines = append(lines, line)
+		}
+		sort.Slice(lines, func(i, j int) bool { return lines[i].line < lines[j].line })
+
+		fmt.Fprintf(bw, "TN:%s\nSF:%s\n", c.TestName, file)
+		var linesHit, branchesFound, branchesHit int
+		for _, line := range lines {
+			for block, branch := range line.branches {
+				for i, count := range []uint64{branch.taken, branch.notTaken} {
+					branchesFound++
+					if count > 0 {
+						branchesHit++
+					}
+					if branch.executed {
+						fmt.Fprintf(bw, "BRDA:%d,%d,%d,%d\n", line.line, block, i, count)
+					} else {
+						fmt.Fprintf(bw, "BRDA:%d,%d,%d,-\n", line.line, block, i)
+					}
+				}
+			}
+		}
+		fmt.Fprintf(bw, "BRF:%d\nBRH:%d\n", branchesFound, branchesHit)
+		for _, line := range lines {
+			if line.hits > 0 {
+				linesHit++
+			}
+			fmt.Fprintf(bw, "DA:%d,%d\n", line.line, line.hits)
+		}
+		fmt.Fprintf(bw, "LF:%d\nLH:%d\nend_of_record\n", len(lines), linesHit)
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,120 @@
+package core
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCoverage(t *testing.T) {
+	state := &D16MachineState{Coverage: NewCoverage()}
+	state.Init()
+	copy(state.D16MemoryState.Data[:], []Word{
+		0x8401, // 0x0000: SET A, 0
+		0x8412, // 0x0001: IFE A, 0
+		0x8821, // 0x0002: SET B, 1
+		0x8812, // 0x0003: IFE A, 1
+		0x8841, // 0x0004: SET C, 1
+		0x8413, // 0x0005: IFN A, 0
+		0x8861, // 0x0006: SET X, 1
+		0xa381, // 0x0007: SET PC, 7
+		0x8412, // 0x0008: IFE A, 0
+	})
+	for i := 0; i < 7; i++ {
+		if _, err := Step(state); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	expHits := []uint64{1, 1, 1, 1, 0, 1, 0, 2, 0}
+	for address, exp := range expHits {
+		if hits := state.Coverage.Hits(Word(address)); hits != exp {
+			t.Errorf("got %d hits at 0x%04x, expected %d", hits, address, exp)
+		}
+	}
+	expBranches := []struct {
+		Address         Word
+		Taken, NotTaken uint64
+	}{
+		{0x0001, 1, 0},
+		{0x0003, 0, 1},
+		{0x0005, 0, 1},
+		{0x0008, 0, 0},
+	}
+	for _, exp := range expBranches {
+		if taken, notTaken := state.Coverage.Branch(exp.Address); taken != exp.Taken || notTaken != exp.NotTaken {
+			t.Errorf("got branch %d/%d at 0x%04x, expected %d/%d", taken, notTaken, exp.Address, exp.Taken, exp.NotTaken)
+		}
+	}
+
+	lines, err := ReadLineTable(strings.NewReader(`
+; prog.dasm lines 1 to 7, lib.dasm lines 1 and 2
+0x0000 prog.dasm 1
+0x0001 prog.dasm 2
+0x0002 prog.dasm 3
+0x0003 prog.dasm 4
+0x0004 prog.dasm 5
+0x0005 prog.dasm 6
+0x0006 prog.dasm 7
+0x0007 lib.dasm 1
+0x0008 lib.dasm 2
+`))
+	if err != nil {
+		t.Fatalf("ReadLineTable returned error %v", err)
+	}
+	state.Coverage.Lines = lines
+	state.Coverage.TestName = "unit"
+	var buf bytes.Buffer
+	if err := state.Coverage.WriteLCOV(&buf, state); err != nil {
+		t.Fatalf("WriteLCOV returned error %v", err)
+	}
+
+	expected := `TN:unit
+SF:lib.dasm
+BRDA:2,0,0,-
+BRDA:2,0,1,-
+BRF:2
+BRH:0
+DA:1,2
+DA:2,0
+LF:2
+LH:1
+end_of_record
+TN:unit
+SF:prog.dasm
+BRDA:2,0,0,1
+BRDA:2,0,1,0
+BRDA:4,0,0,0
+BRDA:4,0,1,1
+BRDA:6,0,0,0
+BRDA:6,0,1,1
+BRF:6
+BRH:3
+DA:1,1
+DA:2,1
+DA:3,1
+DA:4,1
+DA:5,0
+DA:6,1
+DA:7,0
+LF:7
+LH:5
+end_of_record
+`
+	if buf.String() != expected {
+		t.Errorf("got LCOV:\n%s\nexpected:\n%s", buf.String(), expected)
+	}
+}
+
+func TestReadLineTableErrors(t *testing.T) {
+	for _, text := range []string{
+		"0x0000 prog.dasm\n",
+		"0x10000 prog.dasm 1\n",
+		"0x0000 prog.dasm zero\n",
+		"0x0000 prog.dasm 0\n",
+	} {
+		if _, err := ReadLineTable(strings.NewReader(text)); err == nil {
+			t.Errorf("expected error for %q", text)
+		}
+	}
+}
@@ -5,6 +5,7 @@ type D16CPU struct {
 	pc        Word    // Program counter.
 	sp        Word    // Stack pointer.
 	ex        Word    // Extra/excess.
+	ia        Word    // Interrupt address.
 }
 
 func (cpu *D16CPU) Init() {
@@ -15,6 +16,7 @@ func (cpu *D16CPU) Init() {
 	cpu.pc = 0x0000
 	cpu.sp = 0xffff
 	cpu.ex = 0x0000
+	cpu.ia = 0x0000
 }
 
 func (cpu *D16CPU) Register(id RegisterId) Word {
@@ -68,3 +70,11 @@ func (cpu *D16CPU) EX() Word {
 func (cpu *D16CPU) WriteEX(value Word) {
 	cpu.ex = value
 }
+
+func (cpu *D16CPU) IA() Word {
+	return cpu.ia
+}
+
+func (cpu *D16CPU) WriteIA(value Word) {
+	cpu.ia = value
+}
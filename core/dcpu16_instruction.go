@@ -4,6 +4,52 @@ import (
 	"fmt"
 )
 
+var d16InstructionInfos = []InstructionInfo{
+	{"SET", 0x01, BinaryKind, OperandA | OperandB, OperandB, 1, false},
+	{"ADD", 0x02, BinaryKind, OperandA | OperandB, OperandB, 2, false},
+	{"SUB", 0x03, BinaryKind, OperandA | OperandB, OperandB, 2, false},
+	{"MUL", 0x04, BinaryKind, OperandA | OperandB, OperandB, 2, false},
+	{"MLI", 0x05, BinaryKind, OperandA | OperandB, OperandB, 2, false},
+	{"DIV", 0x06, BinaryKind, OperandA | OperandB, OperandB, 3, false},
+	{"DVI", 0x07, BinaryKind, OperandA | OperandB, OperandB, 3, false},
+	{"MOD", 0x08, BinaryKind, OperandA | OperandB, OperandB, 3, false},
+	{"MDI", 0x09, BinaryKind, OperandA | OperandB, OperandB, 3, false},
+	{"AND", 0x0a, BinaryKind, OperandA | OperandB, OperandB, 1, false},
+	{"BOR", 0x0b, BinaryKind, OperandA | OperandB, OperandB, 1, false},
+	{"XOR", 0x0c, BinaryKind, OperandA | OperandB, OperandB, 1, false},
+	{"SHR", 0x0d, BinaryKind, OperandA | OperandB, OperandB, 1, false},
+	{"ASR", 0x0e, BinaryKind, OperandA | OperandB, OperandB, 1, false},
+	{"SHL", 0x0f, BinaryKind, OperandA | OperandB, OperandB, 1, false},
+	{"IFB", 0x10, BinaryKind, OperandA | OperandB, 0, 2, true},
+	{"IFC", 0x11, BinaryKind, OperandA | OperandB, 0, 2, true},
+	{"IFE", 0x12, BinaryKind, OperandA | OperandB, 0, 2, true},
+	{"IFN", 0x13, BinaryKind, OperandA | OperandB, 0, 2, true},
+	{"IFG", 0x14, BinaryKind, OperandA | OperandB, 0, 2, true},
+	{"IFA", 0x15, BinaryKind, OperandA | OperandB, 0, 2, true},
+	{"IFL", 0x16, BinaryKind, OperandA | OperandB, 0, 2, true},
+	{"IFU", 0x17, BinaryKind, OperandA | OperandB, 0, 2, true},
+	{"ADX", 0x1a, BinaryKind, OperandA | OperandB, OperandB, 3, false},
+	{"SBX", 0x1b, BinaryKind, OperandA | OperandB, OperandB, 3, false},
+	{"STI", 0x1e, BinaryKind, OperandA | OperandB, OperandB, 2, false},
+	{"STD", 0x1f, BinaryKind, OperandA | OperandB, OperandB, 2, false},
+
+	{"JSR", 0x01, UnaryKind, OperandA, 0, 3, false},
+	{"INT", 0x08, UnaryKind, OperandA, 0, 4, false},
+	{"IAG", 0x09, UnaryKind, OperandA, OperandA, 1, false},
+	{"IAS", 0x0a, UnaryKind, OperandA, 0, 1, false},
+	{"RFI", 0x0b, UnaryKind, OperandA, 0, 3, false},
+	{"IAQ", 0x0c, UnaryKind, OperandA, 0, 2, false},
+	{"HWN", 0x10, UnaryKind, OperandA, OperandA, 2, false},
+	{"HWQ", 0x11, UnaryKind, OperandA, 0, 4, false},
+	{"HWI", 0x12, UnaryKind, OperandA, 0, 4, false},
+}
+
+var d16InstructionInfoIndex = newInstructionInfoIndex(d16InstructionInfos)
+
+var d16DecodeTable = lazyDecodeTable{
+	decodeWord: new(D16InstructionSet).decodeWord,
+}
+
 func splitOpWord(w Word) (upper6, middle5, lower5 Word) {
 	upper6 = (w >> 10) & 0x003f
 	middle5 = (w >> 5) & 0x001f
@@ -12,11 +58,24 @@ func splitOpWord(w Word) (upper6, middle5, lower5 Word) {
 }
 
 // D16InstructionSet is the table of basic instructions supported by the
-// DCPU-16.
+// DCPU-16. Instruction words are decoded using a table that is computed once
+// for all instances.
 type D16InstructionSet struct {
 	initialized bool
-	jsrInst     JsrInst
-	// TODO 1.7 unary instructions.
+
+	unarySet [0x20]UnaryInstruction
+
+	jsrInst JsrInst
+
+	intInst IntInst
+	iagInst IagInst
+	iasInst IasInst
+	rfiInst RfiInst
+	iaqInst IaqInst
+
+	hwnInst HwnInst
+	hwqInst HwqInst
+	hwiInst HwiInst
 
 	binarySet [0x20]BinaryInstruction
 
@@ -47,11 +106,14 @@ type D16InstructionSet struct {
 	iflInst IflInst
 	ifuInst IfuInst
 
-	//adxInst AdxInst  // TODO
-	//sbxInst SbxInst  // TODO
+	adxInst AdxInst
+	sbxInst SbxInst
 
-	//stiInst StiInst  // TODO
-	//stdInst StdInst  // TODO
+	stiInst StiInst
+	stdInst StdInst
+
+	// Values of the instruction most recently returned by Instruction.
+	aOperand, bOperand Operand
 
 	// Use separate pools of values so that two values in play at the same time
 	// don't interfere (see doc for D16ValueSet).
@@ -60,6 +122,29 @@ type D16InstructionSet struct {
 }
 
 func (is *D16InstructionSet) init() {
+	is.unarySet = [0x20]UnaryInstruction{
+		// 0x00
+		nil,
+
+		// 0x01
+		&is.jsrInst,
+
+		// 0x02+
+		nil, nil, nil, nil, nil, nil,
+
+		// 0x08+
+		&is.intInst, &is.iagInst, &is.iasInst, &is.rfiInst, &is.iaqInst,
+
+		// 0x0d+
+		nil, nil, nil,
+
+		// 0x10+
+		&is.hwnInst, &is.hwqInst, &is.hwiInst,
+
+		// 0x13+
+		nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil,
+	}
+
 	is.binarySet = [0x20]BinaryInstruction{
 		// 0x00
 		nil,
@@ -87,22 +172,29 @@ func (is *D16InstructionSet) init() {
 		nil, nil,
 
 		// 0x1a+
-		nil /*TODO adxInst*/, nil, /*TODO sbxInst*/
+		&is.adxInst, &is.sbxInst,
 
 		// 0x1c+
 		nil, nil,
 
 		// 0x1e+
-		nil /*TODO stiInst*/, nil, /*TODO stdInst*/
+		&is.stiInst, &is.stdInst,
 	}
+
+	is.initialized = true
 }
 
 func (is *D16InstructionSet) unaryInstruction(upper6, middle5 Word) (instruction UnaryInstruction, a Value, err error) {
-	if middle5 != 0x001 {
-		err = InvalidUnaryOpCodeError(middle5)
+	opCode := middle5
+	if int(opCode) >= len(is.unarySet) {
+		err = InvalidUnaryOpCodeError(opCode)
+		return
+	}
+	instruction = is.unarySet[opCode]
+	if instruction == nil {
+		err = InvalidUnaryOpCodeError(opCode)
 		return
 	}
-	instruction = &is.jsrInst
 	a, err = is.aValueSet.Value(upper6, false)
 	return
 }
@@ -130,57 +222,116 @@ func (is *D16InstructionSet) binaryInstruction(upper6, middle5, lower5 Word) (in
 }
 
 func (is *D16InstructionSet) NumExtraWords(w Word) (Word, error) {
+	entry := &d16DecodeTable.get()[w]
+	if entry.info == nil {
+		_, err := is.decodeWord(w)
+		return 0, err
+	}
+	return entry.numExtraWords(), nil
+}
+
+func (is *D16InstructionSet) Conditional(w Word) bool {
+	info := d16DecodeTable.get()[w].info
+	return info != nil && info.Conditional
+}
+
+func (is *D16InstructionSet) Instruction(w Word) (Instruction, error) {
 	if !is.initialized {
 		is.init()
 	}
 
-	upper6, middle5, lower5 := splitOpWord(w)
+	entry := &d16DecodeTable.get()[w]
+	if entry.info == nil {
+		_, err := is.decodeWord(w)
+		return nil, err
+	}
+	return entry.instruction(is.unarySet[:], is.binarySet[:], &is.aOperand, &is.bOperand), nil
+}
+
+func (is *D16InstructionSet) InstructionByName(name string) (Instruction, bool) {
+	if !is.initialized {
+		is.init()
+	}
 
-	if lower5 != 0 {
-		// Binary instruction.
-		_, a, b, err := is.binaryInstruction(upper6, middle5, lower5)
-		if err != nil {
-			return 0, err
-		}
-		return a.NumExtraWords() + b.NumExtraWords(), nil
+	info, ok := d16InstructionInfoIndex.lookup(name)
+	if !ok {
+		return nil, false
 	}
+	if info.Kind == UnaryKind {
+		return is.unarySet[info.OpCode], true
+	}
+	return is.binarySet[info.OpCode], true
+}
+
+func (is *D16InstructionSet) InstructionInfo(name string) (InstructionInfo, bool) {
+	return d16InstructionInfoIndex.lookup(name)
+}
+
+func (is *D16InstructionSet) InstructionInfos() []InstructionInfo {
+	return d16InstructionInfos
+}
 
-	// Unary instruction.
-	_, a, err := is.unaryInstruction(upper6, middle5)
+func (is *D16InstructionSet) Decode(wordLoader WordLoader) (DecodedInstruction, error) {
+	word, err := wordLoader.WordLoad()
 	if err != nil {
-		return 0, err
+		return DecodedInstruction{}, err
+	}
+	entry := &d16DecodeTable.get()[word]
+	if entry.info == nil {
+		return is.decodeWord(word)
 	}
-	return a.NumExtraWords(), nil
+	d := entry.decoded()
+	return d, d.loadNextWords(wordLoader, false)
 }
 
-func (is *D16InstructionSet) Instruction(w Word) (Instruction, error) {
+// decodeWord decodes the instruction word without the use of the decode table,
+// with zero for any next words.
+func (is *D16InstructionSet) decodeWord(word Word) (DecodedInstruction, error) {
 	if !is.initialized {
 		is.init()
 	}
 
-	upper6, middle5, lower5 := splitOpWord(w)
+	var d DecodedInstruction
+	upper6, middle5, lower5 := splitOpWord(word)
 
 	if lower5 != 0 {
 		// Binary instruction.
-		instruction, a, b, err := is.binaryInstruction(upper6, middle5, lower5)
+		_, a, b, err := is.binaryInstruction(upper6, middle5, lower5)
+		d.Info = d16InstructionInfoIndex.binary[lower5]
+		d.A, _ = OperandOf(a)
+		d.B, _ = OperandOf(b)
+		if err != nil {
+			return d, err
+		}
+	} else {
+		// Unary instruction.
+		_, a, err := is.unaryInstruction(upper6, middle5)
+		d.Info = d16InstructionInfoIndex.unary[middle5]
+		d.A, _ = OperandOf(a)
 		if err != nil {
-			return nil, err
+			return d, err
 		}
-		instruction.SetBinaryValue(a, b)
-		return instruction, nil
 	}
 
-	// Unary instruction.
-	instruction, a, err := is.unaryInstruction(upper6, middle5)
-	if err != nil {
-		return nil, err
-	}
-	instruction.SetUnaryValue(a)
-	return instruction, nil
+	return d, d.loadNextWords(zeroWordLoader{}, false)
 }
 
-func (is *D16InstructionSet) InstructionByName(name string) (Instruction, bool) {
-	panic("unimplemented")
+// Encode returns ValueContextError for a literal value b (LiteralValue or
+// WordValue) of an instruction that writes b, such as SET 1, A, as the write
+// would be silently ignored. Conditional instructions accept literals in b,
+// encoded in the next word form.
+func (is *D16InstructionSet) Encode(name string, b, a Value) ([]Word, error) {
+	info, ok := d16InstructionInfoIndex.lookup(name)
+	if !ok {
+		return nil, UnknownInstructionError(name)
+	}
+	if o, ok := OperandOf(b); ok && info.Writes&OperandB != 0 && (o.Kind == LiteralOperand || o.Kind == WordOperand) {
+		return nil, ValueContextError
+	}
+	if info.Kind == UnaryKind {
+		return encodeInstruction(info, 5, 0, false, &is.aValueSet, b, a)
+	}
+	return encodeInstruction(info, 0, 5, false, &is.aValueSet, b, a)
 }
 
 // unaryInst forms common data and code for instructions that take one value.
@@ -192,10 +343,20 @@ func (o *unaryInst) SetUnaryValue(a Value) {
 	o.A = a
 }
 
+func (o *unaryInst) UnaryValue() Value {
+	return o.A
+}
+
 func (o *unaryInst) LoadNextWords(wordLoader WordLoader) error {
 	return o.A.LoadExtraWords(wordLoader)
 }
 
+// ticks returns the given base cost of the instruction, plus one for the value
+// if it reads the next word.
+func (o *unaryInst) ticks(base int) int {
+	return base + int(o.A.NumExtraWords())
+}
+
 func (o *unaryInst) clone() unaryInst {
 	return unaryInst{A: o.A.Clone()}
 }
@@ -209,10 +370,11 @@ type JsrInst struct {
 	unaryInst
 }
 
-func (o *JsrInst) Execute(state MachineState) error {
+func (o *JsrInst) Execute(state MachineState) (int, error) {
+	target := o.A.Resolve(state).Read(state)
 	state.WriteMemory(state.DecReadSP(), state.PC())
-	state.WritePC(o.A.Read(state))
-	return nil
+	state.WritePC(target)
+	return o.ticks(3), nil
 }
 
 func (o *JsrInst) Clone() Instruction {
@@ -223,6 +385,177 @@ func (o *JsrInst) String() string {
 	return o.unaryInst.format("JSR")
 }
 
+// 0x08: INT a - triggers a software interrupt with message a
+type IntInst struct {
+	unaryInst
+}
+
+func (o *IntInst) Execute(state MachineState) (int, error) {
+	message := o.A.Resolve(state).Read(state)
+	if state.IA() == 0 {
+		// Interrupts are disabled.
+		return o.ticks(4), nil
+	}
+	return o.ticks(4), state.TriggerInterrupt(message)
+}
+
+func (o *IntInst) Clone() Instruction {
+	return &IntInst{o.unaryInst.clone()}
+}
+
+func (o *IntInst) String() string {
+	return o.unaryInst.format("INT")
+}
+
+// 0x09: IAG a - sets a to IA
+type IagInst struct {
+	unaryInst
+}
+
+func (o *IagInst) Execute(state MachineState) (int, error) {
+	o.A.Resolve(state).Write(state, state.IA())
+	return o.ticks(1), nil
+}
+
+func (o *IagInst) Clone() Instruction {
+	return &IagInst{o.unaryInst.clone()}
+}
+
+func (o *IagInst) String() string {
+	return o.unaryInst.format("IAG")
+}
+
+// 0x0a: IAS a - sets IA to a
+type IasInst struct {
+	unaryInst
+}
+
+func (o *IasInst) Execute(state MachineState) (int, error) {
+	state.WriteIA(o.A.Resolve(state).Read(state))
+	return o.ticks(1), nil
+}
+
+func (o *IasInst) Clone() Instruction {
+	return &IasInst{o.unaryInst.clone()}
+}
+
+func (o *IasInst) String() string {
+	return o.unaryInst.format("IAS")
+}
+
+// 0x0b: RFI a - disables interrupt queueing, pops A from the stack, then pops
+// PC from the stack
+type RfiInst struct {
+	unaryInst
+}
+
+func (o *RfiInst) Execute(state MachineState) (int, error) {
+	o.A.Resolve(state)
+	state.WriteQueueInterrupts(false)
+	state.WriteRegister(RegA, state.ReadMemory(state.ReadIncSP()))
+	state.WritePC(state.ReadMemory(state.ReadIncSP()))
+	return o.ticks(3), nil
+}
+
+func (o *RfiInst) Clone() Instruction {
+	return &RfiInst{o.unaryInst.clone()}
+}
+
+func (o *RfiInst) String() string {
+	return o.unaryInst.format("RFI")
+}
+
+// 0x0c: IAQ a - if a is nonzero, interrupts will be added to the queue instead
+// of triggered. if a is zero, interrupts will be triggered as normal again
+type IaqInst struct {
+	unaryInst
+}
+
+func (o *IaqInst) Execute(state MachineState) (int, error) {
+	state.WriteQueueInterrupts(o.A.Resolve(state).Read(state) != 0)
+	return o.ticks(2), nil
+}
+
+func (o *IaqInst) Clone() Instruction {
+	return &IaqInst{o.unaryInst.clone()}
+}
+
+func (o *IaqInst) String() string {
+	return o.unaryInst.format("IAQ")
+}
+
+// 0x10: HWN a - sets a to number of connected hardware devices
+type HwnInst struct {
+	unaryInst
+}
+
+func (o *HwnInst) Execute(state MachineState) (int, error) {
+	o.A.Resolve(state).Write(state, state.NumDevices())
+	return o.ticks(2), nil
+}
+
+func (o *HwnInst) Clone() Instruction {
+	return &HwnInst{o.unaryInst.clone()}
+}
+
+func (o *HwnInst) String() string {
+	return o.unaryInst.format("HWN")
+}
+
+// 0x11: HWQ a - sets A, B, C, X, Y registers to information about hardware a.
+// A+(B<<16) is a 32 bit word identifying the hardware id, C is the hardware
+// version, X+(Y<<16) is a 32 bit word identifying the manufacturer
+type HwqInst struct {
+	unaryInst
+}
+
+func (o *HwqInst) Execute(state MachineState) (int, error) {
+	index := o.A.Resolve(state).Read(state)
+	device, ok := state.Device(index)
+	if !ok {
+		return o.ticks(4), NoSuchDeviceError(index)
+	}
+	idHigh, idLow := device.ID().Split()
+	manHigh, manLow := device.Manufacturer().Split()
+	state.WriteRegister(RegA, idLow)
+	state.WriteRegister(RegB, idHigh)
+	state.WriteRegister(RegC, device.Version())
+	state.WriteRegister(RegX, manLow)
+	state.WriteRegister(RegY, manHigh)
+	return o.ticks(4), nil
+}
+
+func (o *HwqInst) Clone() Instruction {
+	return &HwqInst{o.unaryInst.clone()}
+}
+
+func (o *HwqInst) String() string {
+	return o.unaryInst.format("HWQ")
+}
+
+// 0x12: HWI a - sends an interrupt to hardware a
+type HwiInst struct {
+	unaryInst
+}
+
+func (o *HwiInst) Execute(state MachineState) (int, error) {
+	index := o.A.Resolve(state).Read(state)
+	device, ok := state.Device(index)
+	if !ok {
+		return o.ticks(4), NoSuchDeviceError(index)
+	}
+	deviceTicks, err := device.Interrupt(state)
+	return o.ticks(4) + deviceTicks, err
+}
+
+func (o *HwiInst) Clone() Instruction {
+	return &HwiInst{o.unaryInst.clone()}
+}
+
+func (o *HwiInst) String() string {
+	return o.unaryInst.format("HWI")
+}
+
 // binaryInst forms common data and code for instructions that take two values (A
 // and B).
 type binaryInst struct {
@@ -234,6 +567,17 @@ func (o *binaryInst) SetBinaryValue(a, b Value) {
 	o.B = b
 }
 
+func (o *binaryInst) BinaryValues() (Value, Value) {
+	return o.A, o.B
+}
+
+// resolve resolves the locations of the values, a before b.
+func (o *binaryInst) resolve(state MachineState) (a, b Location) {
+	a = o.A.Resolve(state)
+	b = o.B.Resolve(state)
+	return a, b
+}
+
 func (o *binaryInst) LoadNextWords(wordLoader WordLoader) error {
 	err := o.A.LoadExtraWords(wordLoader)
 	if err != nil {
@@ -242,6 +586,35 @@ func (o *binaryInst) LoadNextWords(wordLoader WordLoader) error {
 	return o.B.LoadExtraWords(wordLoader)
 }
 
+// ticks returns the given base cost of the instruction, plus one for each value
+// that reads the next word.
+func (o *binaryInst) ticks(base int) int {
+	return base + int(o.A.NumExtraWords()+o.B.NumExtraWords())
+}
+
+// skip skips the next instruction(s) for a failed IF instruction of the given
+// base cost, which takes one tick longer for each instruction skipped.
+func (o *binaryInst) skip(state MachineState, base int) (int, error) {
+	skipped, err := InstructionSkip(&skipWordLoader{state, rawMemoryOf(state)}, state)
+	return o.ticks(base) + skipped, err
+}
+
+// skipWordLoader loads the words of skipped instructions from PC, without the
+// watchpoints and protection of fetching instructions, as they are not
+// executed.
+type skipWordLoader struct {
+	state  MachineState
+	memory Memory
+}
+
+func (l *skipWordLoader) WordLoad() (Word, error) {
+	return l.memory.ReadMemory(l.state.ReadIncPC()), nil
+}
+
+func (l *skipWordLoader) SkipWords(count Word) error {
+	return l.state.SkipWords(count)
+}
+
 func (o *binaryInst) clone() binaryInst {
 	return binaryInst{A: o.A.Clone(), B: o.B.Clone()}
 }
@@ -255,9 +628,10 @@ type SetInst struct {
 	binaryInst
 }
 
-func (o *SetInst) Execute(state MachineState) error {
-	o.B.Write(state, o.A.Read(state))
-	return nil
+func (o *SetInst) Execute(state MachineState) (int, error) {
+	a, b := o.resolve(state)
+	b.Write(state, a.Read(state))
+	return o.ticks(1), nil
 }
 
 func (o *SetInst) Clone() Instruction {
@@ -273,12 +647,13 @@ type AddInst struct {
 	binaryInst
 }
 
-func (o *AddInst) Execute(state MachineState) error {
-	a, b := o.A.Read(state), o.B.Read(state)
+func (o *AddInst) Execute(state MachineState) (int, error) {
+	aLoc, bLoc := o.resolve(state)
+	a, b := aLoc.Read(state), bLoc.Read(state)
 	ex, result := (DWord(b) + DWord(a)).Split()
-	o.B.Write(state, result)
+	bLoc.Write(state, result)
 	state.WriteEX(ex)
-	return nil
+	return o.ticks(2), nil
 }
 
 func (o *AddInst) Clone() Instruction {
@@ -294,12 +669,13 @@ type SubInst struct {
 	binaryInst
 }
 
-func (o *SubInst) Execute(state MachineState) error {
-	a, b := o.A.Read(state), o.B.Read(state)
+func (o *SubInst) Execute(state MachineState) (int, error) {
+	aLoc, bLoc := o.resolve(state)
+	a, b := aLoc.Read(state), bLoc.Read(state)
 	ex, result := (DWord(b) - DWord(a)).Split()
-	o.B.Write(state, result)
+	bLoc.Write(state, result)
 	state.WriteEX(ex)
-	return nil
+	return o.ticks(2), nil
 }
 
 func (o *SubInst) Clone() Instruction {
@@ -316,12 +692,13 @@ type MulInst struct {
 	binaryInst
 }
 
-func (o *MulInst) Execute(state MachineState) error {
-	a, b := o.A.Read(state), o.B.Read(state)
+func (o *MulInst) Execute(state MachineState) (int, error) {
+	aLoc, bLoc := o.resolve(state)
+	a, b := aLoc.Read(state), bLoc.Read(state)
 	ex, result := (DWord(b) * DWord(a)).Split()
-	o.B.Write(state, result)
+	bLoc.Write(state, result)
 	state.WriteEX(ex)
-	return nil
+	return o.ticks(2), nil
 }
 
 func (o *MulInst) Clone() Instruction {
@@ -337,12 +714,13 @@ type MliInst struct {
 	binaryInst
 }
 
-func (o *MliInst) Execute(state MachineState) error {
-	a, b := o.A.Read(state), o.B.Read(state)
+func (o *MliInst) Execute(state MachineState) (int, error) {
+	aLoc, bLoc := o.resolve(state)
+	a, b := aLoc.Read(state), bLoc.Read(state)
 	ex, result := (b.AsDSigned() * a.AsDSigned()).Split()
-	o.B.Write(state, result)
+	bLoc.Write(state, result)
 	state.WriteEX(ex)
-	return nil
+	return o.ticks(2), nil
 }
 
 func (o *MliInst) Clone() Instruction {
@@ -359,17 +737,18 @@ type DivInst struct {
 	binaryInst
 }
 
-func (o *DivInst) Execute(state MachineState) error {
-	a, b := o.A.Read(state), o.B.Read(state)
+func (o *DivInst) Execute(state MachineState) (int, error) {
+	aLoc, bLoc := o.resolve(state)
+	a, b := aLoc.Read(state), bLoc.Read(state)
 	if a == 0 {
-		o.B.Write(state, 0)
+		bLoc.Write(state, 0)
 		state.WriteEX(0)
 	} else {
 		result, ex := ((DWord(b) << 16) / DWord(a)).Split()
-		o.B.Write(state, result)
+		bLoc.Write(state, result)
 		state.WriteEX(ex)
 	}
-	return nil
+	return o.ticks(3), nil
 }
 
 func (o *DivInst) Clone() Instruction {
@@ -385,17 +764,18 @@ type DviInst struct {
 	binaryInst
 }
 
-func (o *DviInst) Execute(state MachineState) error {
-	a, b := o.A.Read(state), o.B.Read(state)
+func (o *DviInst) Execute(state MachineState) (int, error) {
+	aLoc, bLoc := o.resolve(state)
+	a, b := aLoc.Read(state), bLoc.Read(state)
 	if a == 0 {
-		o.B.Write(state, 0)
+		bLoc.Write(state, 0)
 		state.WriteEX(0)
 	} else {
 		result, ex := ((b.AsDSigned() << 16) / a.AsDSigned()).Split()
-		o.B.Write(state, result)
+		bLoc.Write(state, result)
 		state.WriteEX(ex)
 	}
-	return nil
+	return o.ticks(3), nil
 }
 
 func (o *DviInst) Clone() Instruction {
@@ -411,14 +791,15 @@ type ModInst struct {
 	binaryInst
 }
 
-func (o *ModInst) Execute(state MachineState) error {
-	a, b := o.A.Read(state), o.B.Read(state)
+func (o *ModInst) Execute(state MachineState) (int, error) {
+	aLoc, bLoc := o.resolve(state)
+	a, b := aLoc.Read(state), bLoc.Read(state)
 	if a == 0 {
-		o.B.Write(state, 0)
+		bLoc.Write(state, 0)
 	} else {
-		o.B.Write(state, b%a)
+		bLoc.Write(state, b%a)
 	}
-	return nil
+	return o.ticks(3), nil
 }
 
 func (o *ModInst) Clone() Instruction {
@@ -434,14 +815,15 @@ type MdiInst struct {
 	binaryInst
 }
 
-func (o *MdiInst) Execute(state MachineState) error {
-	a, b := o.A.Read(state), o.B.Read(state)
+func (o *MdiInst) Execute(state MachineState) (int, error) {
+	aLoc, bLoc := o.resolve(state)
+	a, b := aLoc.Read(state), bLoc.Read(state)
 	if a == 0 {
-		o.B.Write(state, 0)
+		bLoc.Write(state, 0)
 	} else {
-		o.B.Write(state, Word(SWord(b)%SWord(a)))
+		bLoc.Write(state, Word(SWord(b)%SWord(a)))
 	}
-	return nil
+	return o.ticks(3), nil
 }
 
 func (o *MdiInst) Clone() Instruction {
@@ -457,10 +839,11 @@ type AndInst struct {
 	binaryInst
 }
 
-func (o *AndInst) Execute(state MachineState) error {
-	a, b := o.A.Read(state), o.B.Read(state)
-	o.B.Write(state, b&a)
-	return nil
+func (o *AndInst) Execute(state MachineState) (int, error) {
+	aLoc, bLoc := o.resolve(state)
+	a, b := aLoc.Read(state), bLoc.Read(state)
+	bLoc.Write(state, b&a)
+	return o.ticks(1), nil
 }
 
 func (o *AndInst) Clone() Instruction {
@@ -476,10 +859,11 @@ type BorInst struct {
 	binaryInst
 }
 
-func (o *BorInst) Execute(state MachineState) error {
-	a, b := o.A.Read(state), o.B.Read(state)
-	o.B.Write(state, b|a)
-	return nil
+func (o *BorInst) Execute(state MachineState) (int, error) {
+	aLoc, bLoc := o.resolve(state)
+	a, b := aLoc.Read(state), bLoc.Read(state)
+	bLoc.Write(state, b|a)
+	return o.ticks(1), nil
 }
 
 func (o *BorInst) Clone() Instruction {
@@ -495,10 +879,11 @@ type XorInst struct {
 	binaryInst
 }
 
-func (o *XorInst) Execute(state MachineState) error {
-	a, b := o.A.Read(state), o.B.Read(state)
-	o.B.Write(state, b^a)
-	return nil
+func (o *XorInst) Execute(state MachineState) (int, error) {
+	aLoc, bLoc := o.resolve(state)
+	a, b := aLoc.Read(state), bLoc.Read(state)
+	bLoc.Write(state, b^a)
+	return o.ticks(1), nil
 }
 
 func (o *XorInst) Clone() Instruction {
@@ -514,12 +899,13 @@ type ShrInst struct {
 	binaryInst
 }
 
-func (o *ShrInst) Execute(state MachineState) error {
-	a, b := o.A.Read(state), o.B.Read(state)
+func (o *ShrInst) Execute(state MachineState) (int, error) {
+	aLoc, bLoc := o.resolve(state)
+	a, b := aLoc.Read(state), bLoc.Read(state)
 	result := (DWord(b) << 16) >> DWord(a)
-	o.B.Write(state, Word(result>>16))
+	bLoc.Write(state, Word(result>>16))
 	state.WriteEX(Word(result & 0xffff))
-	return nil
+	return o.ticks(1), nil
 }
 
 func (o *ShrInst) Clone() Instruction {
@@ -536,12 +922,13 @@ type AsrInst struct {
 	binaryInst
 }
 
-func (o *AsrInst) Execute(state MachineState) error {
-	a, b := o.A.Read(state), o.B.Read(state)
+func (o *AsrInst) Execute(state MachineState) (int, error) {
+	aLoc, bLoc := o.resolve(state)
+	a, b := aLoc.Read(state), bLoc.Read(state)
 	result, ex := ((b.AsDSigned() << 16) >> a).Split()
-	o.B.Write(state, result)
+	bLoc.Write(state, result)
 	state.WriteEX(ex)
-	return nil
+	return o.ticks(1), nil
 }
 
 func (o *AsrInst) Clone() Instruction {
@@ -557,12 +944,13 @@ type ShlInst struct {
 	binaryInst
 }
 
-func (o *ShlInst) Execute(state MachineState) error {
-	a, b := o.A.Read(state), o.B.Read(state)
+func (o *ShlInst) Execute(state MachineState) (int, error) {
+	aLoc, bLoc := o.resolve(state)
+	a, b := aLoc.Read(state), bLoc.Read(state)
 	result := DWord(b) << DWord(a)
-	o.B.Write(state, Word(result))
+	bLoc.Write(state, Word(result))
 	state.WriteEX(Word(result >> 16))
-	return nil
+	return o.ticks(1), nil
 }
 
 func (o *ShlInst) Clone() Instruction {
@@ -578,12 +966,13 @@ type IfbInst struct {
 	binaryInst
 }
 
-func (o *IfbInst) Execute(state MachineState) error {
-	a, b := o.A.Read(state), o.B.Read(state)
+func (o *IfbInst) Execute(state MachineState) (int, error) {
+	aLoc, bLoc := o.resolve(state)
+	a, b := aLoc.Read(state), bLoc.Read(state)
 	if (b & a) != 0 {
-		return nil
+		return o.ticks(2), nil
 	}
-	return InstructionSkip(state, state)
+	return o.skip(state, 2)
 }
 
 func (o *IfbInst) Clone() Instruction {
@@ -599,12 +988,13 @@ type IfcInst struct {
 	binaryInst
 }
 
-func (o *IfcInst) Execute(state MachineState) error {
-	a, b := o.A.Read(state), o.B.Read(state)
+func (o *IfcInst) Execute(state MachineState) (int, error) {
+	aLoc, bLoc := o.resolve(state)
+	a, b := aLoc.Read(state), bLoc.Read(state)
 	if (b & a) == 0 {
-		return nil
+		return o.ticks(2), nil
 	}
-	return InstructionSkip(state, state)
+	return o.skip(state, 2)
 }
 
 func (o *IfcInst) Clone() Instruction {
@@ -620,12 +1010,13 @@ type IfeInst struct {
 	binaryInst
 }
 
-func (o *IfeInst) Execute(state MachineState) error {
-	a, b := o.A.Read(state), o.B.Read(state)
+func (o *IfeInst) Execute(state MachineState) (int, error) {
+	aLoc, bLoc := o.resolve(state)
+	a, b := aLoc.Read(state), bLoc.Read(state)
 	if b == a {
-		return nil
+		return o.ticks(2), nil
 	}
-	return InstructionSkip(state, state)
+	return o.skip(state, 2)
 }
 
 func (o *IfeInst) Clone() Instruction {
@@ -641,12 +1032,13 @@ type IfnInst struct {
 	binaryInst
 }
 
-func (o *IfnInst) Execute(state MachineState) error {
-	a, b := o.A.Read(state), o.B.Read(state)
+func (o *IfnInst) Execute(state MachineState) (int, error) {
+	aLoc, bLoc := o.resolve(state)
+	a, b := aLoc.Read(state), bLoc.Read(state)
 	if b != a {
-		return nil
+		return o.ticks(2), nil
 	}
-	return InstructionSkip(state, state)
+	return o.skip(state, 2)
 }
 
 func (o *IfnInst) Clone() Instruction {
@@ -662,12 +1054,13 @@ type IfgInst struct {
 	binaryInst
 }
 
-func (o *IfgInst) Execute(state MachineState) error {
-	a, b := o.A.Read(state), o.B.Read(state)
+func (o *IfgInst) Execute(state MachineState) (int, error) {
+	aLoc, bLoc := o.resolve(state)
+	a, b := aLoc.Read(state), bLoc.Read(state)
 	if b > a {
-		return nil
+		return o.ticks(2), nil
 	}
-	return InstructionSkip(state, state)
+	return o.skip(state, 2)
 }
 
 func (o *IfgInst) Clone() Instruction {
@@ -683,12 +1076,13 @@ type IfaInst struct {
 	binaryInst
 }
 
-func (o *IfaInst) Execute(state MachineState) error {
-	a, b := o.A.Read(state), o.B.Read(state)
+func (o *IfaInst) Execute(state MachineState) (int, error) {
+	aLoc, bLoc := o.resolve(state)
+	a, b := aLoc.Read(state), bLoc.Read(state)
 	if SWord(b) > SWord(a) {
-		return nil
+		return o.ticks(2), nil
 	}
-	return InstructionSkip(state, state)
+	return o.skip(state, 2)
 }
 
 func (o *IfaInst) Clone() Instruction {
@@ -704,12 +1098,13 @@ type IflInst struct {
 	binaryInst
 }
 
-func (o *IflInst) Execute(state MachineState) error {
-	a, b := o.A.Read(state), o.B.Read(state)
+func (o *IflInst) Execute(state MachineState) (int, error) {
+	aLoc, bLoc := o.resolve(state)
+	a, b := aLoc.Read(state), bLoc.Read(state)
 	if b < a {
-		return nil
+		return o.ticks(2), nil
 	}
-	return InstructionSkip(state, state)
+	return o.skip(state, 2)
 }
 
 func (o *IflInst) Clone() Instruction {
@@ -725,12 +1120,13 @@ type IfuInst struct {
 	binaryInst
 }
 
-func (o *IfuInst) Execute(state MachineState) error {
-	a, b := o.A.Read(state), o.B.Read(state)
+func (o *IfuInst) Execute(state MachineState) (int, error) {
+	aLoc, bLoc := o.resolve(state)
+	a, b := aLoc.Read(state), bLoc.Read(state)
 	if b < a {
-		return nil
+		return o.ticks(2), nil
 	}
-	return InstructionSkip(state, state)
+	return o.skip(state, 2)
 }
 
 func (o *IfuInst) Clone() Instruction {
@@ -747,12 +1143,16 @@ type AdxInst struct {
 	binaryInst
 }
 
-func (o *AdxInst) Execute(state MachineState) error {
-	a, b, ex := o.A.Read(state), o.B.Read(state), state.EX()
+func (o *AdxInst) Execute(state MachineState) (int, error) {
+	aLoc, bLoc := o.resolve(state)
+	a, b, ex := aLoc.Read(state), bLoc.Read(state), state.EX()
 	ex, result := (DWord(b) + DWord(a) + DWord(ex)).Split()
-	o.B.Write(state, result)
+	if ex != 0 {
+		ex = 0x0001
+	}
+	bLoc.Write(state, result)
 	state.WriteEX(ex)
-	return nil
+	return o.ticks(3), nil
 }
 
 func (o *AdxInst) Clone() Instruction {
@@ -763,24 +1163,28 @@ func (o *AdxInst) String() string {
 	return o.binaryInst.format("ADX")
 }
 
-// 0x1a: SBX b, a - sets b to b-a+EX, sets EX to 0xFFFF if there is an
-// under-flow, 0x0 otherwise
+// 0x1b: SBX b, a - sets b to b-a+EX, sets EX to 0xFFFF if there is an
+// under-flow, 0x0001 if there's an over-flow, 0x0 otherwise (treats EX as
+// signed, so that it carries the borrow of a previous SUB)
 type SbxInst struct {
 	binaryInst
 }
 
-func (o *SbxInst) Execute(state MachineState) error {
-	a, b, ex := o.A.Read(state), o.B.Read(state), state.EX()
-	fmt.Printf("a=%04x b=%04x ex=%04x\n", a, b, ex)
-	wideResult := (DWord(b) - DWord(a)) + DWord(ex)
-	result := Word(wideResult & 0xffff)
-	ex = 0
-	if result < 0 {
-		ex = 1
+func (o *SbxInst) Execute(state MachineState) (int, error) {
+	aLoc, bLoc := o.resolve(state)
+	a, b, ex := aLoc.Read(state), bLoc.Read(state), state.EX()
+	wideResult := DSWord(b) - DSWord(a) + ex.AsDSigned()
+	switch {
+	case wideResult < 0:
+		ex = 0xffff
+	case wideResult > 0xffff:
+		ex = 0x0001
+	default:
+		ex = 0x0000
 	}
-	o.B.Write(state, result)
+	bLoc.Write(state, Word(wideResult))
 	state.WriteEX(ex)
-	return nil
+	return o.ticks(3), nil
 }
 
 func (o *SbxInst) Clone() Instruction {
@@ -790,3 +1194,45 @@ func (o *SbxInst) Clone() Instruction {
 func (o *SbxInst) String() string {
 	return o.binaryInst.format("SBX")
 }
+
+// 0x1e: STI b, a - sets b to a, then increases I and J by 1
+type StiInst struct {
+	binaryInst
+}
+
+func (o *StiInst) Execute(state MachineState) (int, error) {
+	a, b := o.resolve(state)
+	b.Write(state, a.Read(state))
+	state.WriteRegister(RegI, state.Register(RegI)+1)
+	state.WriteRegister(RegJ, state.Register(RegJ)+1)
+	return o.ticks(2), nil
+}
+
+func (o *StiInst) Clone() Instruction {
+	return &StiInst{o.binaryInst.clone()}
+}
+
+func (o *StiInst) String() string {
+	return o.binaryInst.format("STI")
+}
+
+// 0x1f: STD b, a - sets b to a, then decreases I and J by 1
+type StdInst struct {
+	binaryInst
+}
+
+func (o *StdInst) Execute(state MachineState) (int, error) {
+	a, b := o.resolve(state)
+	b.Write(state, a.Read(state))
+	state.WriteRegister(RegI, state.Register(RegI)-1)
+	state.WriteRegister(RegJ, state.Register(RegJ)-1)
+	return o.ticks(2), nil
+}
+
+func (o *StdInst) Clone() Instruction {
+	return &StdInst{o.binaryInst.clone()}
+}
+
+func (o *StdInst) String() string {
+	return o.binaryInst.format("STD")
+}
@@ -1,6 +1,8 @@
 package core
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 )
 
@@ -280,12 +282,49 @@ func TestInstructionExecute(t *testing.T) {
 			0x7fff, 0x8000, &AdxInst{binInstValue(1)},
 			0, 0x0001,
 		},
+		{
+			"ADX 0xffff, 0xffff (EX=0xffff) = 0xfffd, with EX = 0x0001",
+			0xffff, 0xffff, &AdxInst{binInstValue(0xffff)},
+			0xfffd, 0x0001,
+		},
 		// SbxInst
 		{
 			"SBX 0xffff, 0 (EX=0xffff) = 0xfffe, with EX = 0",
 			0xffff, 0xffff, &SbxInst{binInstValue(0)},
 			0xfffe, 0,
 		},
+		{
+			"SBX 5, 3 (EX=0) = 2, with EX = 0",
+			5, 0, &SbxInst{binInstValue(3)},
+			2, 0,
+		},
+		{
+			"SBX 0, 1 (EX=0) = 0xffff, with EX = 0xffff",
+			0, 0, &SbxInst{binInstValue(1)},
+			0xffff, 0xffff,
+		},
+		{
+			"SBX 1, 1 (EX=0xffff) = 0xffff, with EX = 0xffff",
+			1, 0xffff, &SbxInst{binInstValue(1)},
+			0xffff, 0xffff,
+		},
+		{
+			"SBX 0xffff, 0 (EX=0x0001) = 0, with EX = 0x0001",
+			0xffff, 0x0001, &SbxInst{binInstValue(0)},
+			0, 0x0001,
+		},
+		// StiInst
+		{
+			"STI A, 0x1234",
+			0, 0, &StiInst{binInstValue(0x1234)},
+			0x1234, 0,
+		},
+		// StdInst
+		{
+			"STD A, 0x1234",
+			0, 0, &StdInst{binInstValue(0x1234)},
+			0x1234, 0,
+		},
 	}
 
 	for _, test := range tests {
@@ -301,3 +340,387 @@ func TestInstructionExecute(t *testing.T) {
 		}
 	}
 }
+
+func TestUnaryInstructionLoad(t *testing.T) {
+	var instructionSet D16InstructionSet
+
+	tests := []TestInstruction{
+		{"JSR A", []Word{0x0020}},
+		{"INT 5", []Word{0x9900}},
+		{"IAG A", []Word{0x0120}},
+		{"IAS 0x1234", []Word{0x7d40, 0x1234}},
+		{"RFI 0", []Word{0x8560}},
+		{"IAQ 1", []Word{0x8980}},
+		{"HWN A", []Word{0x0200}},
+		{"HWQ B", []Word{0x0620}},
+		{"HWI 0", []Word{0x8640}},
+	}
+
+	for _, test := range tests {
+		wordLoader := &FakeWordLoader{t, test.Words, 0}
+		instruction, err := InstructionLoad(wordLoader, &instructionSet)
+		if err != nil {
+			t.Errorf("Instruction %#v returned error %v", test.Words, err)
+			continue
+		}
+		if !wordLoader.exhausted() {
+			t.Errorf("Instruction %v (%#v) did not exhaust words to load", instruction, test.Words)
+			continue
+		}
+		str := instruction.String()
+		if test.Str != str {
+			t.Errorf("Instruction %v (%#v) disagrees on string repr (expected %q, got %q)",
+				instruction, test.Words, test.Str, str)
+		}
+	}
+
+	for _, opCode := range []Word{0x00, 0x02, 0x07, 0x0d, 0x0f, 0x13, 0x1f} {
+		_, err := instructionSet.Instruction(opCode << 5)
+		if err != InvalidUnaryOpCodeError(opCode) {
+			t.Errorf("Unary opcode 0x%02x: expected InvalidUnaryOpCodeError, got %v", opCode, err)
+		}
+	}
+}
+
+type FakeDevice struct {
+	id, manufacturer DWord
+	version          Word
+	ticks            int
+	interrupts       int
+}
+
+func (d *FakeDevice) ID() DWord           { return d.id }
+func (d *FakeDevice) Version() Word       { return d.version }
+func (d *FakeDevice) Manufacturer() DWord { return d.manufacturer }
+
+func (d *FakeDevice) Interrupt(state MachineState) (int, error) {
+	d.interrupts++
+	return d.ticks, nil
+}
+
+func TestUnaryInstructionExecute(t *testing.T) {
+	type Test struct {
+		Name    string
+		InitCPU D16CPU
+		InitMem []ExpMem
+		Inst    Instruction
+		ExpCPU  D16CPU
+		ExpMem  []ExpMem
+	}
+
+	regA := &RegisterValue{Reg: RegA}
+	lit := func(literal Word) Value {
+		return &LiteralValue{Literal: literal}
+	}
+
+	tests := []Test{
+		{
+			Name:    "IAS 0x1234",
+			InitCPU: D16CPU{sp: 0xffff},
+			Inst:    &IasInst{unaryInst{lit(0x1234)}},
+			ExpCPU:  D16CPU{sp: 0xffff, ia: 0x1234},
+		},
+		{
+			Name:    "IAG A",
+			InitCPU: D16CPU{sp: 0xffff, ia: 0x4321},
+			Inst:    &IagInst{unaryInst{regA}},
+			ExpCPU:  D16CPU{registers: [8]Word{0x4321}, sp: 0xffff, ia: 0x4321},
+		},
+		{
+			Name:    "RFI 0",
+			InitCPU: D16CPU{sp: 0xfffd},
+			InitMem: []ExpMem{{0xfffd, []Word{0x1111, 0x2222}}},
+			Inst:    &RfiInst{unaryInst{lit(0)}},
+			ExpCPU:  D16CPU{registers: [8]Word{0x1111}, pc: 0x2222, sp: 0xffff},
+		},
+		{
+			Name:    "HWN A",
+			InitCPU: D16CPU{sp: 0xffff},
+			Inst:    &HwnInst{unaryInst{regA}},
+			ExpCPU:  D16CPU{registers: [8]Word{0x0001}, sp: 0xffff},
+		},
+		{
+			Name:    "HWQ 0",
+			InitCPU: D16CPU{sp: 0xffff},
+			Inst:    &HwqInst{unaryInst{lit(0)}},
+			ExpCPU:  D16CPU{registers: [8]Word{0xf615, 0x7349, 0x1802, 0x8b36, 0x1c6c}, sp: 0xffff},
+		},
+	}
+
+	for _, test := range tests {
+		var state D16MachineState
+		state.Init()
+		state.D16CPU = test.InitCPU
+		state.AttachDevice(&FakeDevice{id: 0x7349f615, version: 0x1802, manufacturer: 0x1c6c8b36})
+		for _, mem := range test.InitMem {
+			copy(state.D16MemoryState.Data[mem.Offset:], mem.Expected)
+		}
+		if _, err := test.Inst.Execute(&state); err != nil {
+			t.Errorf("%s: unexpected error: %v", test.Name, err)
+			continue
+		}
+		if !CPUEquals(&test.ExpCPU, &state.D16CPU) {
+			t.Errorf("%s\ngot CPU state %#v\n     expected %#v", test.Name, state.D16CPU, test.ExpCPU)
+		}
+		for _, expMem := range test.ExpMem {
+			expMem.StateCheck(t, test.Name, &state)
+		}
+	}
+}
+
+func TestIaqInstruction(t *testing.T) {
+	var state D16MachineState
+	state.Init()
+
+	(&IaqInst{unaryInst{&LiteralValue{Literal: 1}}}).Execute(&state)
+	if !state.QueueInterrupts() {
+		t.Errorf("IAQ 1 did not enable interrupt queueing")
+	}
+	(&IaqInst{unaryInst{&LiteralValue{Literal: 0}}}).Execute(&state)
+	if state.QueueInterrupts() {
+		t.Errorf("IAQ 0 did not disable interrupt queueing")
+	}
+}
+
+func TestHwiInstruction(t *testing.T) {
+	var state D16MachineState
+	state.Init()
+	device := &FakeDevice{ticks: 5}
+	state.AttachDevice(device)
+
+	ticks, err := (&HwiInst{unaryInst{&LiteralValue{Literal: 0}}}).Execute(&state)
+	if err != nil {
+		t.Fatalf("HWI 0: unexpected error: %v", err)
+	}
+	if device.interrupts != 1 {
+		t.Errorf("HWI 0: expected 1 device interrupt, got %d", device.interrupts)
+	}
+	if ticks != 4+5 {
+		t.Errorf("HWI 0: expected %d ticks, got %d", 4+5, ticks)
+	}
+
+	_, err = (&HwiInst{unaryInst{&LiteralValue{Literal: 1}}}).Execute(&state)
+	if err != NoSuchDeviceError(1) {
+		t.Errorf("HWI 1: expected NoSuchDeviceError, got %v", err)
+	}
+}
+
+func TestIntInstruction(t *testing.T) {
+	var state D16MachineState
+	state.Init()
+
+	inst := &IntInst{unaryInst{&LiteralValue{Literal: 5}}}
+	inst.Execute(&state)
+	if queue := state.QueuedInterrupts(); len(queue) != 0 {
+		t.Errorf("INT 5 with IA=0 queued %#v", queue)
+	}
+
+	state.WriteIA(0x1000)
+	inst.Execute(&state)
+	if queue := state.QueuedInterrupts(); len(queue) != 1 || queue[0] != 5 {
+		t.Errorf("INT 5 with IA=0x1000 queued %#v, expected [5]", queue)
+	}
+}
+
+// checkInstructionInfos checks that the instruction information of an
+// instruction set agrees with its instructions. encode returns the instruction
+// word for the given instruction, with register B as value a, and register A
+// as value b.
+func checkInstructionInfos(t *testing.T, newSet func() InstructionSet, encode func(InstructionInfo) Word) {
+	set := newSet()
+	for _, info := range set.InstructionInfos() {
+		byName, ok := set.InstructionByName(strings.ToLower(info.Name))
+		if !ok {
+			t.Errorf("%s: InstructionByName failed", info.Name)
+			continue
+		}
+		if byInfo, ok := set.InstructionInfo(strings.ToLower(info.Name)); !ok || byInfo != info {
+			t.Errorf("%s: InstructionInfo returned %#v, %t", info.Name, byInfo, ok)
+		}
+		_, isUnary := byName.(UnaryInstruction)
+		if isUnary != (info.Kind == UnaryKind) {
+			t.Errorf("%s: instruction %T does not match kind %v", info.Name, byName, info.Kind)
+		}
+
+		word := encode(info)
+		byWord, err := set.Instruction(word)
+		if err != nil {
+			t.Errorf("%s: Instruction(0x%04x) returned error %v", info.Name, word, err)
+			continue
+		}
+		if byWord != byName {
+			t.Errorf("%s: InstructionByName returned %T, but Instruction(0x%04x) returned %T",
+				info.Name, byName, word, byWord)
+		}
+		if !strings.HasPrefix(byWord.String(), info.Name+" ") {
+			t.Errorf("%s: Instruction(0x%04x) is %v", info.Name, word, byWord)
+		}
+		if set.Conditional(word) && !info.Conditional {
+			t.Errorf("%s: instruction set considers 0x%04x to be conditional", info.Name, word)
+		}
+
+		state := D16MachineState{ISA: newSet()}
+		state.Init()
+		state.AttachDevice(&FakeDevice{})
+		copy(state.D16MemoryState.Data[0:], []Word{word, 0x0401, 0x0401})
+		ticks, err := Step(&state)
+		if err != nil {
+			t.Errorf("%s: unexpected emulation error: %v", info.Name, err)
+			continue
+		}
+		expTicks := info.Ticks
+		if info.Conditional && state.PC() == 0x0002 {
+			expTicks++
+		}
+		if ticks != expTicks {
+			t.Errorf("%s: expected %d ticks, got %d", info.Name, expTicks, ticks)
+		}
+	}
+
+	if _, ok := set.InstructionByName("NOP"); ok {
+		t.Errorf("InstructionByName(\"NOP\") unexpectedly succeeded")
+	}
+}
+
+func TestInstructionInfos(t *testing.T) {
+	checkInstructionInfos(t,
+		func() InstructionSet { return &D16InstructionSet{} },
+		func(info InstructionInfo) Word {
+			if info.Kind == UnaryKind {
+				return info.OpCode<<5 | 0x01<<10
+			}
+			return info.OpCode | 0x00<<5 | 0x01<<10
+		})
+}
+
+// encodeTestInstruction encodes a decoded instruction with the given set.
+func encodeTestInstruction(set InstructionSet, instruction Instruction) ([]Word, error) {
+	name := strings.Fields(instruction.String())[0]
+	switch instruction := instruction.(type) {
+	case UnaryInstruction:
+		return set.Encode(name, nil, instruction.UnaryValue())
+	case BinaryInstruction:
+		a, b := instruction.BinaryValues()
+		return set.Encode(name, b, a)
+	}
+	panic(instruction)
+}
+
+// checkEncodeRoundTrip checks that decoding and then encoding the test
+// instructions results in the original words.
+func checkEncodeRoundTrip(t *testing.T, set InstructionSet, tests []TestInstruction) {
+	for _, test := range tests {
+		wordLoader := &FakeWordLoader{t, test.Words, 0}
+		instruction, err := InstructionLoad(wordLoader, set)
+		if err != nil {
+			t.Errorf("Instruction %#v returned error %v", test.Words, err)
+			continue
+		}
+		words, err := encodeTestInstruction(set, instruction)
+		if err != nil {
+			t.Errorf("Instruction %v (%#v) encode returned error %v", instruction, test.Words, err)
+			continue
+		}
+		if fmt.Sprint(words) != fmt.Sprint(test.Words) {
+			t.Errorf("Instruction %v encoded as %#v, expected %#v", instruction, words, test.Words)
+		}
+	}
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	checkEncodeRoundTrip(t, &D16InstructionSet{}, notchExample)
+	checkEncodeRoundTrip(t, &D16InstructionSet{}, []TestInstruction{
+		{"", []Word{0x0020}},                 // JSR A
+		{"", []Word{0x7d40, 0x1234}},         // IAS 0x1234
+		{"", []Word{0x8560}},                 // RFI 0
+		{"", []Word{0x0301}},                 // SET PUSH, A
+		{"", []Word{0x6021}},                 // SET B, POP
+		{"", []Word{0x6841, 0x0003}},         // SET C, PICK 3
+		{"", []Word{0x43de, 0x0001, 0x0002}}, // STI [0x0002], [A+0x0001]
+		{"", []Word{0x03f2, 0x0005}},         // IFE 0x0005, A
+	})
+}
+
+func TestEncodeLiteralB(t *testing.T) {
+	var set D16InstructionSet
+	b, a := &LiteralValue{Literal: 5}, &RegisterValue{Reg: RegA}
+
+	// The short literal form is not valid for b, so the next word form is used.
+	expected := []Word{0x03f2, 0x0005}
+	words, err := set.Encode("IFE", b, a)
+	if err != nil || fmt.Sprint(words) != fmt.Sprint(expected) {
+		t.Fatalf("IFE 5, A: encoded as %#v, %v, expected %#v", words, err, expected)
+	}
+	d, err := set.Decode(&FakeWordLoader{t, words, 0})
+	if err != nil {
+		t.Fatalf("IFE 5, A: Decode returned error %v", err)
+	}
+	if words, err := d.Encode(&set); err != nil || fmt.Sprint(words) != fmt.Sprint(expected) {
+		t.Errorf("IFE 5, A: re-encoded as %#v, %v, expected %#v", words, err, expected)
+	}
+
+	// SET writes b, so a literal b is rejected in either form.
+	if _, err := set.Encode("SET", b, a); err != ValueContextError {
+		t.Errorf("SET 5, A: expected error %v, got %v", ValueContextError, err)
+	}
+	d, err = set.Decode(&FakeWordLoader{t, []Word{0x03e1, 0x0005}, 0})
+	if err != nil {
+		t.Fatalf("SET 5, A: Decode returned error %v", err)
+	}
+	if _, err := d.Encode(&set); err != ValueContextError {
+		t.Errorf("SET 5, A: re-encoding expected error %v, got %v", ValueContextError, err)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	type Test struct {
+		Name     string
+		B, A     Value
+		Expected []Word
+		Err      error
+	}
+
+	tests := []Test{
+		{"SET", &RegisterValue{Reg: RegA}, &LiteralValue{Literal: Signed(-1)}, []Word{0x8001}, nil},
+		{"set", &RegisterValue{Reg: RegA}, &LiteralValue{Literal: 30}, []Word{0xfc01}, nil},
+		{"Set", &RegisterValue{Reg: RegA}, &LiteralValue{Literal: 31}, []Word{0x7c01, 0x001f}, nil},
+		{"SET", &RegisterValue{Reg: RegA}, &WordValue{extraWord{5}}, []Word{0x7c01, 0x0005}, nil},
+		{"IFE", &WordValue{extraWord{5}}, &RegisterValue{Reg: RegA}, []Word{0x03f2, 0x0005}, nil},
+		{"IFE", &LiteralValue{Literal: 5}, &RegisterValue{Reg: RegA}, []Word{0x03f2, 0x0005}, nil},
+		{"SET", &WordValue{extraWord{5}}, &RegisterValue{Reg: RegA}, nil, ValueContextError},
+		{"JSR", nil, &LiteralValue{Literal: 0x0010}, []Word{0xc420}, nil},
+		{"SET", &RegisterValue{Reg: RegA}, PushValue{}, nil, ValueContextError},
+		{"SET", PopValue{}, &RegisterValue{Reg: RegA}, nil, ValueContextError},
+		{"SET", &LiteralValue{Literal: 1}, &RegisterValue{Reg: RegA}, nil, ValueContextError},
+		{"SET", nil, &RegisterValue{Reg: RegA}, nil, ValueCountError},
+		{"JSR", &RegisterValue{Reg: RegA}, &RegisterValue{Reg: RegA}, nil, ValueCountError},
+		{"NOP", nil, &RegisterValue{Reg: RegA}, nil, UnknownInstructionError("NOP")},
+	}
+
+	var set D16InstructionSet
+	for _, test := range tests {
+		words, err := set.Encode(test.Name, test.B, test.A)
+		if err != test.Err {
+			t.Errorf("%s %v, %v: expected error %v, got %v", test.Name, test.B, test.A, test.Err, err)
+			continue
+		}
+		if fmt.Sprint(words) != fmt.Sprint(test.Expected) {
+			t.Errorf("%s %v, %v: encoded as %#v, expected %#v", test.Name, test.B, test.A, words, test.Expected)
+		}
+	}
+}
+
+func TestInstructionKindString(t *testing.T) {
+	for _, test := range []struct {
+		Kind InstructionKind
+		Exp  string
+	}{
+		{BinaryKind, "binary"},
+		{UnaryKind, "unary"},
+		{InstructionKind(7), "InstructionKind(7)"},
+	} {
+		if got := test.Kind.String(); got != test.Exp {
+			t.Errorf("got %q, expected %q", got, test.Exp)
+		}
+	}
+}
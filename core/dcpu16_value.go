@@ -62,6 +62,59 @@ func (vs *D16ValueSet) Value(w Word, asValueB bool) (Value, error) {
 	return value, nil
 }
 
+// Encode chooses the short literal form (0x20-0x3f) for literal values -1..30
+// used as value a. WordValue is always encoded in the next word form, as are
+// literal values used as value b, where the short literal form is not valid.
+// A literal value b is only useful to instructions that do not write b, so
+// D16InstructionSet.Encode rejects it for the others.
+func (vs *D16ValueSet) Encode(v Value, asValueB bool) (code, next Word, hasNext bool, err error) {
+	o, ok := OperandOf(v)
+	if !ok {
+		return 0, 0, false, ValueContextError
+	}
+	switch o.Kind {
+	case RegisterOperand:
+		return Word(o.Reg), 0, false, nil
+	case RegisterAddressOperand:
+		return 0x08 + Word(o.Reg), 0, false, nil
+	case RegisterRelAddressOperand:
+		return 0x10 + Word(o.Reg), o.Next, true, nil
+	case PopOperand:
+		if asValueB {
+			return 0, 0, false, ValueContextError
+		}
+		return 0x18, 0, false, nil
+	case PushOperand:
+		if !asValueB {
+			return 0, 0, false, ValueContextError
+		}
+		return 0x18, 0, false, nil
+	case PeekOperand:
+		return 0x19, 0, false, nil
+	case PickOperand:
+		return 0x1a, o.Next, true, nil
+	case SPOperand:
+		return 0x1b, 0, false, nil
+	case PCOperand:
+		return 0x1c, 0, false, nil
+	case EXOperand, OOperand:
+		return 0x1d, 0, false, nil
+	case AddressOperand:
+		return 0x1e, o.Next, true, nil
+	case WordOperand:
+		return 0x1f, o.Next, true, nil
+	case LiteralOperand:
+		if asValueB {
+			return 0x1f, o.Next, true, nil
+		}
+		if literal := o.Next + 1; literal <= 0x1f {
+			return 0x20 + literal, 0, false, nil
+		}
+		return 0x1f, o.Next, true, nil
+	}
+	return 0, 0, false, ValueContextError
+}
+
 type noExtraWord struct{}
 
 func (v noExtraWord) LoadExtraWords(WordLoader) error {
@@ -92,12 +145,8 @@ type RegisterValue struct {
 	Reg RegisterId
 }
 
-func (v *RegisterValue) Write(state MachineState, word Word) {
-	state.WriteRegister(v.Reg, word)
-}
-
-func (v *RegisterValue) Read(state MachineState) Word {
-	return state.Register(v.Reg)
+func (v *RegisterValue) Resolve(state MachineState) Location {
+	return Location{Kind: RegisterLocation, Reg: v.Reg}
 }
 
 func (v *RegisterValue) Clone() Value {
@@ -115,12 +164,8 @@ type RegisterAddressValue struct {
 	Reg RegisterId
 }
 
-func (v *RegisterAddressValue) Write(state MachineState, word Word) {
-	state.WriteMemory(state.Register(v.Reg), word)
-}
-
-func (v *RegisterAddressValue) Read(state MachineState) Word {
-	return state.ReadMemory(state.Register(v.Reg))
+func (v *RegisterAddressValue) Resolve(state MachineState) Location {
+	return Location{Kind: MemoryLocation, Word: state.Register(v.Reg)}
 }
 
 func (v *RegisterAddressValue) Clone() Value {
@@ -137,12 +182,8 @@ type RegisterRelAddressValue struct {
 	Reg RegisterId
 }
 
-func (v *RegisterRelAddressValue) Write(state MachineState, word Word) {
-	state.WriteMemory(state.Register(v.Reg)+v.Value, word)
-}
-
-func (v *RegisterRelAddressValue) Read(state MachineState) Word {
-	return state.ReadMemory(state.Register(v.Reg) + v.Value)
+func (v *RegisterRelAddressValue) Resolve(state MachineState) Location {
+	return Location{Kind: MemoryLocation, Word: state.Register(v.Reg) + v.Value}
 }
 
 func (v *RegisterRelAddressValue) Clone() Value {
@@ -159,12 +200,8 @@ type PopValue struct {
 	noExtraWord
 }
 
-func (v PopValue) Write(state MachineState, word Word) {
-	state.WriteMemory(state.ReadIncSP(), word)
-}
-
-func (v PopValue) Read(state MachineState) Word {
-	return state.ReadMemory(state.ReadIncSP())
+func (v PopValue) Resolve(state MachineState) Location {
+	return Location{Kind: MemoryLocation, Word: state.ReadIncSP()}
 }
 
 func (v PopValue) Clone() Value {
@@ -180,12 +217,8 @@ type PeekValue struct {
 	noExtraWord
 }
 
-func (v PeekValue) Write(state MachineState, word Word) {
-	state.WriteMemory(state.SP(), word)
-}
-
-func (v PeekValue) Read(state MachineState) Word {
-	return state.ReadMemory(state.SP())
+func (v PeekValue) Resolve(state MachineState) Location {
+	return Location{Kind: MemoryLocation, Word: state.SP()}
 }
 
 func (v PeekValue) Clone() Value {
@@ -201,12 +234,8 @@ type PushValue struct {
 	noExtraWord
 }
 
-func (v PushValue) Write(state MachineState, word Word) {
-	state.WriteMemory(state.DecReadSP(), word)
-}
-
-func (v PushValue) Read(state MachineState) Word {
-	return state.ReadMemory(state.DecReadSP())
+func (v PushValue) Resolve(state MachineState) Location {
+	return Location{Kind: MemoryLocation, Word: state.DecReadSP()}
 }
 
 func (v PushValue) Clone() Value {
@@ -222,12 +251,8 @@ type PickValue struct {
 	extraWord
 }
 
-func (v *PickValue) Write(state MachineState, word Word) {
-	state.WriteMemory(state.SP()+v.extraWord.Value, word)
-}
-
-func (v *PickValue) Read(state MachineState) Word {
-	return state.ReadMemory(state.SP() + v.extraWord.Value)
+func (v *PickValue) Resolve(state MachineState) Location {
+	return Location{Kind: MemoryLocation, Word: state.SP() + v.extraWord.Value}
 }
 
 func (v *PickValue) Clone() Value {
@@ -244,12 +269,8 @@ type SpValue struct {
 	noExtraWord
 }
 
-func (v SpValue) Write(state MachineState, word Word) {
-	state.WriteSP(word)
-}
-
-func (v SpValue) Read(state MachineState) Word {
-	return state.SP()
+func (v SpValue) Resolve(state MachineState) Location {
+	return Location{Kind: SPLocation}
 }
 
 func (v SpValue) Clone() Value {
@@ -265,12 +286,8 @@ type PcValue struct {
 	noExtraWord
 }
 
-func (v PcValue) Write(state MachineState, word Word) {
-	state.WritePC(word)
-}
-
-func (v PcValue) Read(state MachineState) Word {
-	return state.PC()
+func (v PcValue) Resolve(state MachineState) Location {
+	return Location{Kind: PCLocation}
 }
 
 func (v PcValue) Clone() Value {
@@ -286,12 +303,8 @@ type EXValue struct {
 	noExtraWord
 }
 
-func (v EXValue) Write(state MachineState, word Word) {
-	state.WriteEX(word)
-}
-
-func (v EXValue) Read(state MachineState) Word {
-	return state.EX()
+func (v EXValue) Resolve(state MachineState) Location {
+	return Location{Kind: EXLocation}
 }
 
 func (v EXValue) Clone() Value {
@@ -307,12 +320,8 @@ type AddressValue struct {
 	extraWord
 }
 
-func (v *AddressValue) Write(state MachineState, word Word) {
-	state.WriteMemory(v.extraWord.Value, word)
-}
-
-func (v *AddressValue) Read(state MachineState) Word {
-	return state.ReadMemory(v.extraWord.Value)
+func (v *AddressValue) Resolve(state MachineState) Location {
+	return Location{Kind: MemoryLocation, Word: v.extraWord.Value}
 }
 
 func (v *AddressValue) Clone() Value {
@@ -329,12 +338,8 @@ type WordValue struct {
 	extraWord
 }
 
-func (v *WordValue) Write(state MachineState, word Word) {
-	// No-op.
-}
-
-func (v *WordValue) Read(state MachineState) Word {
-	return v.extraWord.Value
+func (v *WordValue) Resolve(state MachineState) Location {
+	return Location{Kind: LiteralLocation, Word: v.extraWord.Value}
 }
 
 func (v *WordValue) Clone() Value {
@@ -352,12 +357,8 @@ type LiteralValue struct {
 	Literal Word
 }
 
-func (v *LiteralValue) Write(state MachineState, word Word) {
-	// No-op.
-}
-
-func (v *LiteralValue) Read(state MachineState) Word {
-	return v.Literal
+func (v *LiteralValue) Resolve(state MachineState) Location {
+	return Location{Kind: LiteralLocation, Word: v.Literal}
 }
 
 func (v *LiteralValue) Clone() Value {
@@ -133,7 +133,7 @@ func TestValueWrite(t *testing.T) {
 		var state D16MachineState
 		state.Init()
 		state.D16CPU = test.InitCPU
-		test.Value.Write(&state, test.WriteWord)
+		test.Value.Resolve(&state).Write(&state, test.WriteWord)
 		name := fmt.Sprintf("%v write 0x%04x", test.Value, test.WriteWord)
 		for _, expState := range test.ExpStates {
 			expState.StateCheck(t, name, &state)
@@ -215,7 +215,7 @@ func TestValueRead(t *testing.T) {
 		state.Init()
 		copy(state.D16MemoryState.Data[test.InitMemOffset:], test.InitMem)
 		state.D16CPU = test.InitCPU
-		result := test.Value.Read(&state)
+		result := test.Value.Resolve(&state).Read(&state)
 		if test.ExpRead != result {
 			t.Errorf("%v returned 0x%04x, expected 0x%04x", test.Value, result, test.ExpRead)
 		}
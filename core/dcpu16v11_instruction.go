@@ -0,0 +1,323 @@
+package core
+
+var d16V11InstructionInfos = []InstructionInfo{
+	{"SET", 0x1, BinaryKind, OperandA | OperandB, OperandB, 1, false},
+	{"ADD", 0x2, BinaryKind, OperandA | OperandB, OperandB, 2, false},
+	{"SUB", 0x3, BinaryKind, OperandA | OperandB, OperandB, 2, false},
+	{"MUL", 0x4, BinaryKind, OperandA | OperandB, OperandB, 2, false},
+	{"DIV", 0x5, BinaryKind, OperandA | OperandB, OperandB, 3, false},
+	{"MOD", 0x6, BinaryKind, OperandA | OperandB, OperandB, 3, false},
+	{"SHL", 0x7, BinaryKind, OperandA | OperandB, OperandB, 2, false},
+	{"SHR", 0x8, BinaryKind, OperandA | OperandB, OperandB, 2, false},
+	{"AND", 0x9, BinaryKind, OperandA | OperandB, OperandB, 1, false},
+	{"BOR", 0xa, BinaryKind, OperandA | OperandB, OperandB, 1, false},
+	{"XOR", 0xb, BinaryKind, OperandA | OperandB, OperandB, 1, false},
+	{"IFE", 0xc, BinaryKind, OperandA | OperandB, 0, 2, true},
+	{"IFN", 0xd, BinaryKind, OperandA | OperandB, 0, 2, true},
+	{"IFG", 0xe, BinaryKind, OperandA | OperandB, 0, 2, true},
+	{"IFB", 0xf, BinaryKind, OperandA | OperandB, 0, 2, true},
+
+	{"JSR", 0x01, UnaryKind, OperandA, 0, 2, false},
+}
+
+var d16V11InstructionInfoIndex = newInstructionInfoIndex(d16V11InstructionInfos)
+
+var d16V11DecodeTable = lazyDecodeTable{
+	decodeWord: new(D16V11InstructionSet).decodeWord,
+}
+
+// splitV11OpWord splits a version 1.1 instruction word of the form
+// bbbbbbaaaaaaoooo.
+func splitV11OpWord(w Word) (upper6, middle6, lower4 Word) {
+	upper6 = (w >> 10) & 0x003f
+	middle6 = (w >> 4) & 0x003f
+	lower4 = w & 0x000f
+	return
+}
+
+// D16V11InstructionSet is the table of instructions supported by version 1.1
+// of the DCPU-16. It reuses the instructions of the later version where they
+// behave the same, adapting them to the version 1.1 encoding and costs.
+//
+// Note that version 1.1 names the destination value "a" and the source value
+// "b", which is the reverse of the later version. The instructions here keep
+// the later naming, such that a version 1.1 "SET a, b" sets B to A.
+type D16V11InstructionSet struct {
+	initialized bool
+
+	unarySet [0x40]UnaryInstruction
+
+	jsrInst JsrInst
+	jsrV11  v11UnaryInst
+
+	binarySet [0x10]BinaryInstruction
+
+	setInst SetInst
+	addInst AddInst
+	subInst SubInst
+	mulInst MulInst
+	divInst DivInst
+	modInst ModInst
+	shlInst ShlInst
+	shrInst ShrInst
+	andInst AndInst
+	borInst BorInst
+	xorInst XorInst
+	ifeInst IfeInst
+	ifnInst IfnInst
+	ifgInst IfgInst
+	ifbInst IfbInst
+
+	binaryV11 [0x10]v11BinaryInst
+
+	// Values of the instruction most recently returned by Instruction.
+	aOperand, bOperand Operand
+
+	// Use separate pools of values so that two values in play at the same time
+	// don't interfere (see doc for D16V11ValueSet).
+	aValueSet D16V11ValueSet
+	bValueSet D16V11ValueSet
+}
+
+func (is *D16V11InstructionSet) init() {
+	// 0x01: JSR a - 2 ticks, rather than 3.
+	is.jsrV11 = v11UnaryInst{UnaryInstruction: &is.jsrInst, extraTicks: -1}
+	is.unarySet[0x01] = &is.jsrV11
+
+	instructions := [0x10]BinaryInstruction{
+		// 0x0
+		nil,
+		// 0x1+
+		&is.setInst, &is.addInst, &is.subInst, &is.mulInst, &is.divInst, &is.modInst,
+		// 0x7+
+		&is.shlInst, &is.shrInst,
+		// 0x9+
+		&is.andInst, &is.borInst, &is.xorInst,
+		// 0xc+
+		&is.ifeInst, &is.ifnInst, &is.ifgInst, &is.ifbInst,
+	}
+	for opCode, instruction := range instructions {
+		if instruction == nil {
+			continue
+		}
+		is.binaryV11[opCode] = v11BinaryInst{BinaryInstruction: instruction}
+		is.binarySet[opCode] = &is.binaryV11[opCode]
+	}
+	// SHL and SHR take 2 ticks, rather than 1.
+	is.binaryV11[0x7].extraTicks = 1
+	is.binaryV11[0x8].extraTicks = 1
+
+	is.initialized = true
+}
+
+func (is *D16V11InstructionSet) unaryInstruction(upper6, middle6 Word) (instruction UnaryInstruction, a Value, err error) {
+	opCode := middle6
+	instruction = is.unarySet[opCode]
+	if instruction == nil {
+		err = InvalidUnaryOpCodeError(opCode)
+		return
+	}
+	a, err = is.aValueSet.Value(upper6, false)
+	return
+}
+
+func (is *D16V11InstructionSet) binaryInstruction(upper6, middle6, lower4 Word) (instruction BinaryInstruction, a, b Value, err error) {
+	opCode := lower4
+	instruction = is.binarySet[opCode]
+	if instruction == nil {
+		err = InvalidBinaryOpCodeError(opCode)
+		return
+	}
+	// The source value is in the upper bits, and the destination value in the
+	// middle bits.
+	a, err = is.aValueSet.Value(upper6, false)
+	if err != nil {
+		return
+	}
+	b, err = is.bValueSet.Value(middle6, true)
+	if err != nil {
+		return
+	}
+	return
+}
+
+func (is *D16V11InstructionSet) NumExtraWords(w Word) (Word, error) {
+	entry := &d16V11DecodeTable.get()[w]
+	if entry.info == nil {
+		_, err := is.decodeWord(w)
+		return 0, err
+	}
+	return entry.numExtraWords(), nil
+}
+
+// Conditional always returns false, as version 1.1 conditional instructions
+// skip only the single instruction following them.
+func (is *D16V11InstructionSet) Conditional(w Word) bool {
+	return false
+}
+
+func (is *D16V11InstructionSet) Instruction(w Word) (Instruction, error) {
+	if !is.initialized {
+		is.init()
+	}
+
+	entry := &d16V11DecodeTable.get()[w]
+	if entry.info == nil {
+		_, err := is.decodeWord(w)
+		return nil, err
+	}
+	return entry.instruction(is.unarySet[:], is.binarySet[:], &is.aOperand, &is.bOperand), nil
+}
+
+func (is *D16V11InstructionSet) InstructionByName(name string) (Instruction, bool) {
+	if !is.initialized {
+		is.init()
+	}
+
+	info, ok := d16V11InstructionInfoIndex.lookup(name)
+	if !ok {
+		return nil, false
+	}
+	if info.Kind == UnaryKind {
+		return is.unarySet[info.OpCode], true
+	}
+	return is.binarySet[info.OpCode], true
+}
+
+func (is *D16V11InstructionSet) InstructionInfo(name string) (InstructionInfo, bool) {
+	return d16V11InstructionInfoIndex.lookup(name)
+}
+
+func (is *D16V11InstructionSet) InstructionInfos() []InstructionInfo {
+	return d16V11InstructionInfos
+}
+
+func (is *D16V11InstructionSet) Decode(wordLoader WordLoader) (DecodedInstruction, error) {
+	word, err := wordLoader.WordLoad()
+	if err != nil {
+		return DecodedInstruction{}, err
+	}
+	entry := &d16V11DecodeTable.get()[word]
+	if entry.info == nil {
+		return is.decodeWord(word)
+	}
+	d := entry.decoded()
+	return d, d.loadNextWords(wordLoader, true)
+}
+
+// decodeWord decodes the instruction word without the use of the decode table,
+// with zero for any next words.
+func (is *D16V11InstructionSet) decodeWord(word Word) (DecodedInstruction, error) {
+	if !is.initialized {
+		is.init()
+	}
+
+	var d DecodedInstruction
+	upper6, middle6, lower4 := splitV11OpWord(word)
+
+	if lower4 != 0 {
+		// Basic instruction.
+		_, a, b, err := is.binaryInstruction(upper6, middle6, lower4)
+		d.Info = d16V11InstructionInfoIndex.binary[lower4]
+		d.A, _ = OperandOf(a)
+		d.B, _ = OperandOf(b)
+		if err != nil {
+			return d, err
+		}
+	} else {
+		// Non-basic instruction.
+		_, a, err := is.unaryInstruction(upper6, middle6)
+		d.Info = d16V11InstructionInfoIndex.unary[middle6]
+		d.A, _ = OperandOf(a)
+		if err != nil {
+			return d, err
+		}
+	}
+
+	return d, d.loadNextWords(zeroWordLoader{}, true)
+}
+
+func (is *D16V11InstructionSet) Encode(name string, b, a Value) ([]Word, error) {
+	info, ok := d16V11InstructionInfoIndex.lookup(name)
+	if !ok {
+		return nil, UnknownInstructionError(name)
+	}
+	if info.Kind == UnaryKind {
+		return encodeInstruction(info, 4, 0, false, &is.aValueSet, b, a)
+	}
+	return encodeInstruction(info, 0, 4, true, &is.aValueSet, b, a)
+}
+
+// v11UnaryInst adapts a unary instruction to the version 1.1 costs.
+type v11UnaryInst struct {
+	UnaryInstruction
+	extraTicks int
+}
+
+func (o *v11UnaryInst) Execute(state MachineState) (int, error) {
+	ticks, err := o.UnaryInstruction.Execute(state)
+	return ticks + o.extraTicks, err
+}
+
+func (o *v11UnaryInst) Clone() Instruction {
+	return &v11UnaryInst{
+		UnaryInstruction: o.UnaryInstruction.Clone().(UnaryInstruction),
+		extraTicks:       o.extraTicks,
+	}
+}
+
+// v11BinaryInst adapts a binary instruction to version 1.1, which loads the
+// next word of the destination value before that of the source value, and
+// resolves them in the same order, and has some differing costs.
+type v11BinaryInst struct {
+	BinaryInstruction
+	a, b       Value
+	extraTicks int
+
+	// Values given to the adapted instruction, resolved by Execute.
+	aResolved, bResolved resolvedValue
+}
+
+func (o *v11BinaryInst) SetBinaryValue(a, b Value) {
+	o.a, o.b = a, b
+	o.aResolved.Value, o.bResolved.Value = a, b
+	o.BinaryInstruction.SetBinaryValue(&o.aResolved, &o.bResolved)
+}
+
+func (o *v11BinaryInst) BinaryValues() (Value, Value) {
+	return o.a, o.b
+}
+
+func (o *v11BinaryInst) LoadNextWords(wordLoader WordLoader) error {
+	err := o.b.LoadExtraWords(wordLoader)
+	if err != nil {
+		return err
+	}
+	return o.a.LoadExtraWords(wordLoader)
+}
+
+func (o *v11BinaryInst) Execute(state MachineState) (int, error) {
+	o.bResolved.location = o.b.Resolve(state)
+	o.aResolved.location = o.a.Resolve(state)
+	ticks, err := o.BinaryInstruction.Execute(state)
+	return ticks + o.extraTicks, err
+}
+
+func (o *v11BinaryInst) Clone() Instruction {
+	c := &v11BinaryInst{
+		BinaryInstruction: o.BinaryInstruction.Clone().(BinaryInstruction),
+		extraTicks:        o.extraTicks,
+	}
+	c.SetBinaryValue(o.a.Clone(), o.b.Clone())
+	return c
+}
+
+// resolvedValue is a value whose location has already been resolved.
+type resolvedValue struct {
+	Value
+	location Location
+}
+
+func (v *resolvedValue) Resolve(MachineState) Location {
+	return v.location
+}
@@ -0,0 +1,112 @@
+package core
+
+import (
+	"testing"
+)
+
+var v11InstructionSetImplTest InstructionSet = &D16V11InstructionSet{}
+
+func TestV11InstructionLoad(t *testing.T) {
+	var instructionSet D16V11InstructionSet
+
+	tests := append([]TestInstruction{
+		{"SET PUSH, O", []Word{0x75a1}},
+		{"SET A, POP", []Word{0x6001}},
+		{"ADD PEEK, 31", []Word{0xfd92}},
+		{"SET [A+0x0001], [B+0x0002]", []Word{0x4501, 0x0001, 0x0002}},
+	}, notchExampleV11...)
+
+	for _, test := range tests {
+		wordLoader := &FakeWordLoader{t, test.Words, 0}
+		instruction, err := InstructionLoad(wordLoader, &instructionSet)
+		if err != nil {
+			t.Errorf("Instruction %#v returned error %v", test.Words, err)
+			continue
+		}
+		if !wordLoader.exhausted() {
+			t.Errorf("Instruction %v (%#v) did not exhaust words to load", instruction, test.Words)
+			continue
+		}
+		str := instruction.String()
+		if test.Str != str {
+			t.Errorf("Instruction %v (%#v) disagrees on string repr (expected %q, got %q)",
+				instruction, test.Words, test.Str, str)
+		}
+		if clone := instruction.Clone(); clone.String() != str {
+			t.Errorf("Instruction %v (%#v) clone disagrees on string repr (expected %q, got %q)",
+				instruction, test.Words, str, clone.String())
+		}
+	}
+}
+
+func TestV11StepTicks(t *testing.T) {
+	type Test struct {
+		Name     string
+		InitMem  []Word
+		ExpTicks int
+	}
+
+	tests := []Test{
+		{"SET A, B", []Word{0x0401}, 1},
+		{"SET [0x1000], 0x0020", []Word{0x7de1, 0x1000, 0x0020}, 3},
+		{"SHL X, 4", []Word{0x9037}, 2},
+		{"SHR X, 4", []Word{0x9038}, 2},
+		{"DIV A, B", []Word{0x0405}, 3},
+		{"IFN A, 0 (failure, no chaining)", []Word{0x800d, 0x800d, 0x0401}, 3},
+		{"JSR 0x0018", []Word{0x7c10, 0x0018}, 3},
+	}
+
+	for _, test := range tests {
+		state := D16MachineState{ISA: &D16V11InstructionSet{}}
+		state.Init()
+		copy(state.D16MemoryState.Data[0:], test.InitMem)
+
+		ticks, err := Step(&state)
+		if err != nil {
+			t.Errorf("%s: unexpected emulation error: %v", test.Name, err)
+			continue
+		}
+		if ticks != test.ExpTicks {
+			t.Errorf("%s: expected %d ticks, got %d", test.Name, test.ExpTicks, ticks)
+		}
+	}
+
+	state := D16MachineState{ISA: &D16V11InstructionSet{}}
+	state.Init()
+	copy(state.D16MemoryState.Data[0:], []Word{0x800d, 0x800d, 0x0401})
+	Step(&state)
+	if state.PC() != 0x0002 {
+		t.Errorf("IFN A, 0 skipped to 0x%04x, expected 0x0002", state.PC())
+	}
+}
+
+func TestNewInstructionSet(t *testing.T) {
+	for _, version := range []string{"1.1", "1.7"} {
+		if _, err := NewInstructionSet(version); err != nil {
+			t.Errorf("NewInstructionSet(%q): unexpected error: %v", version, err)
+		}
+	}
+	if _, err := NewInstructionSet("1.2"); err != UnknownVersionError("1.2") {
+		t.Errorf("NewInstructionSet(\"1.2\"): expected UnknownVersionError, got %v", err)
+	}
+}
+
+func TestV11InstructionInfos(t *testing.T) {
+	checkInstructionInfos(t,
+		func() InstructionSet { return &D16V11InstructionSet{} },
+		func(info InstructionInfo) Word {
+			if info.Kind == UnaryKind {
+				return info.OpCode<<4 | 0x01<<10
+			}
+			return info.OpCode | 0x00<<4 | 0x01<<10
+		})
+}
+
+func TestV11EncodeRoundTrip(t *testing.T) {
+	checkEncodeRoundTrip(t, &D16V11InstructionSet{}, notchExampleV11)
+	checkEncodeRoundTrip(t, &D16V11InstructionSet{}, []TestInstruction{
+		{"", []Word{0x75a1}},                 // SET PUSH, O
+		{"", []Word{0xfd92}},                 // ADD PEEK, 31
+		{"", []Word{0x4501, 0x0001, 0x0002}}, // SET [A+0x0001], [B+0x0002]
+	})
+}
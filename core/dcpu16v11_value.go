@@ -0,0 +1,101 @@
+package core
+
+// D16V11ValueSet is the table of values supported by version 1.1 of the
+// DCPU-16. As with D16ValueSet, the value returned is only good for use until
+// the next call to the Value method.
+type D16V11ValueSet struct {
+	registerValue           RegisterValue
+	registerAddressValue    RegisterAddressValue
+	registerRelAddressValue RegisterRelAddressValue
+	addressValue            AddressValue
+	wordValue               WordValue
+	literalValue            LiteralValue
+}
+
+// Value returns the value for the given code. Version 1.1 values do not depend
+// on their position in the instruction, so asValueB is ignored.
+func (vs *D16V11ValueSet) Value(w Word, asValueB bool) (Value, error) {
+	switch {
+	case 0x00 <= w && w <= 0x07:
+		vs.registerValue.Reg = RegisterId(w)
+		return &vs.registerValue, nil
+	case 0x08 <= w && w <= 0x0f:
+		vs.registerAddressValue.Reg = RegisterId(w - 0x08)
+		return &vs.registerAddressValue, nil
+	case 0x10 <= w && w <= 0x17:
+		vs.registerRelAddressValue.Reg = RegisterId(w - 0x10)
+		return &vs.registerRelAddressValue, nil
+	case 0x18 == w:
+		return PopValue{}, nil
+	case 0x19 == w:
+		return PeekValue{}, nil
+	case 0x1a == w:
+		return PushValue{}, nil
+	case 0x1b == w:
+		return SpValue{}, nil
+	case 0x1c == w:
+		return PcValue{}, nil
+	case 0x1d == w:
+		return OValue{}, nil
+	case 0x1e == w:
+		return &vs.addressValue, nil
+	case 0x1f == w:
+		return &vs.wordValue, nil
+	case 0x20 <= w && w <= 0x3f:
+		vs.literalValue.Literal = w - 0x20
+		return &vs.literalValue, nil
+	}
+	return nil, ValueCodeError(w)
+}
+
+// Encode chooses the short literal form (0x20-0x3f) for literal values 0..31.
+// WordValue is always encoded in the next word form.
+func (vs *D16V11ValueSet) Encode(v Value, asValueB bool) (code, next Word, hasNext bool, err error) {
+	o, ok := OperandOf(v)
+	if !ok {
+		return 0, 0, false, ValueContextError
+	}
+	switch o.Kind {
+	case RegisterOperand:
+		return Word(o.Reg), 0, false, nil
+	case RegisterAddressOperand:
+		return 0x08 + Word(o.Reg), 0, false, nil
+	case RegisterRelAddressOperand:
+		return 0x10 + Word(o.Reg), o.Next, true, nil
+	case PopOperand:
+		return 0x18, 0, false, nil
+	case PeekOperand:
+		return 0x19, 0, false, nil
+	case PushOperand:
+		return 0x1a, 0, false, nil
+	case SPOperand:
+		return 0x1b, 0, false, nil
+	case PCOperand:
+		return 0x1c, 0, false, nil
+	case EXOperand, OOperand:
+		return 0x1d, 0, false, nil
+	case AddressOperand:
+		return 0x1e, o.Next, true, nil
+	case WordOperand:
+		return 0x1f, o.Next, true, nil
+	case LiteralOperand:
+		if o.Next <= 0x1f {
+			return 0x20 + o.Next, 0, false, nil
+		}
+		return 0x1f, o.Next, true, nil
+	}
+	return 0, 0, false, ValueContextError
+}
+
+// 0x1d: O - the version 1.1 name for EX
+type OValue struct {
+	EXValue
+}
+
+func (v OValue) Clone() Value {
+	return v
+}
+
+func (v OValue) String() string {
+	return "O"
+}
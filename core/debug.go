@@ -0,0 +1,148 @@
+package core
+
+import (
+	"context"
+	"sort"
+)
+
+// Debugger runs a machine for a debugger, stopping at breakpoints, or when
+// stepping over or out of subroutines. Its methods must not be called
+// concurrently, but its Emulator may be paused from other goroutines.
+type Debugger struct {
+	Emulator *Emulator
+
+	breakpoints map[Word]bool // Addresses, and true if temporary.
+}
+
+// NewDebugger creates a Debugger for the machine, with no breakpoints.
+func NewDebugger(state MachineState) *Debugger {
+	return &Debugger{Emulator: NewEmulator(state), breakpoints: make(map[Word]bool)}
+}
+
+// AddBreakpoint adds a breakpoint, that stops execution before the
+// instruction at the address. Replaces any temporary breakpoint at the
+// address.
+func (d *Debugger) AddBreakpoint(address Word) {
+	d.breakpoints[address] = false
+}
+
+// AddTemporaryBreakpoint adds a breakpoint that is removed when execution
+// stops at it. Does nothing if there is already a breakpoint at the address.
+func (d *Debugger) AddTemporaryBreakpoint(address Word) {
+	if _, ok := d.breakpoints[address]; !ok {
+		d.breakpoints[address] = true
+	}
+}
+
+// RemoveBreakpoint removes the breakpoint at the address, and returns false if
+// there was none.
+func (d *Debugger) RemoveBreakpoint(address Word) bool {
+	_, ok := d.breakpoints[address]
+	delete(d.breakpoints, address)
+	return ok
+}
+
+// Breakpoints returns the addresses of the breakpoints, in order.
+func (d *Debugger) Breakpoints() []Word {
+	addresses := make([]Word, 0, len(d.breakpoints))
+	for address := range d.breakpoints {
+		addresses = append(addresses, address)
+	}
+	sort.Slice(addresses, func(i, j int) bool { return addresses[i] < addresses[j] })
+	return addresses
+}
+
+// run runs until done returns true after a step, stopping with StopStep, or a
+// breakpoint is reached. The instruction at PC is executed even if it has a
+// breakpoint, so that execution can continue from a breakpoint.
+func (d *Debugger) run(ctx context.Context, done func(state MachineState) bool) RunResult {
+	stepped := false
+	result := d.Emulator.run(ctx, 0, func(state MachineState) bool {
+		if done != nil && done(state) {
+			stepped = true
+			return true
+		}
+		_, ok := d.breakpoints[state.PC()]
+		return ok
+	})
+	if result.Reason != StopBreakpoint || result.Err != nil {
+		return result
+	}
+	if stepped {
+		result.Reason = StopStep
+	}
+	pc := d.Emulator.State.PC()
+	if d.breakpoints[pc] {
+		delete(d.breakpoints, pc)
+	}
+	return result
+}
+
+// Continue runs until a breakpoint is reached, the context is cancelled, or
+// Step returns an error.
+func (d *Debugger) Continue(ctx context.Context) RunResult {
+	return d.run(ctx, nil)
+}
+
+// StepInto executes a single instruction, stopping with StopStep.
+func (d *Debugger) StepInto(ctx context.Context) RunResult {
+	return d.run(ctx, func(MachineState) bool { return true })
+}
+
+// StepOver executes a single instruction, or if it is a JSR, runs until the
+// subroutine returns to the following instruction with SP as it was before
+// the JSR. Stops with StopStep when done, or at a breakpoint in the
+// subroutine.
+func (d *Debugger) StepOver(ctx context.Context) RunResult {
+	state := d.Emulator.State
+	pc := state.PC()
+	decoded, words := decodeAt(state, pc)
+	if decoded.Info == nil || decoded.Info.Name != "JSR" {
+		return d.StepInto(ctx)
+	}
+	returnAddress, sp := pc+Word(len(words)), state.SP()
+	return d.run(ctx, func(state MachineState) bool {
+		return state.PC() == returnAddress && state.SP() == sp
+	})
+}
+
+// StepOut runs until the current subroutine or interrupt handler returns,
+// by a SET PC, POP or RFI that pops its return address. Stops with StopStep
+// when done, or at a breakpoint.
+func (d *Debugger) StepOut(ctx context.Context) RunResult {
+	state := d.Emulator.State
+	// Orders positions in the stack from the top, with SP 0 (an empty stack,
+	// or the last word popped from 0xffff) after all others.
+	position := func(sp Word) Word { return sp - 1 }
+	frame := position(state.SP())
+	var sp Word
+	var returning bool
+	before := func(state MachineState) {
+		sp = state.SP()
+		returning = isReturn(state, state.PC())
+	}
+	before(state)
+	return d.run(ctx, func(state MachineState) bool {
+		// A return that pops from within the frame, rather than from a
+		// subroutine called by it. SP is lower if an interrupt was delivered
+		// instead.
+		done := returning && position(sp) >= frame && position(state.SP()) > position(sp)
+		before(state)
+		return done
+	})
+}
+
+// isReturn returns true if the instruction at the address is SET PC, POP or
+// RFI.
+func isReturn(state MachineState, address Word) bool {
+	decoded, _ := decodeAt(state, address)
+	switch {
+	case decoded.Info == nil:
+		return false
+	case decoded.Info.Name == "RFI":
+		return true
+	case decoded.Info.Name == "SET":
+		return decoded.B.Kind == PCOperand && decoded.A.Kind == PopOperand
+	}
+	return false
+}
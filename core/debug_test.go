@@ -0,0 +1,138 @@
+package core
+
+import (
+	"context"
+	"testing"
+)
+
+// debugTestProgram calls a subroutine that calls another, and then loops.
+var debugTestProgram = []Word{
+	0x9420, // 0x0000: JSR 4
+	0x8821, // 0x0001: SET B, 1
+	0x8f81, // 0x0002: SET PC, 2
+	0,      // 0x0003
+	0xa420, // 0x0004: JSR 8
+	0x8802, // 0x0005: ADD A, 1
+	0x6381, // 0x0006: SET PC, POP
+	0,      // 0x0007
+	0xac02, // 0x0008: ADD A, 10
+	0x6381, // 0x0009: SET PC, POP
+}
+
+func TestDebugger(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	ctx := context.Background()
+
+	type Step struct {
+		Name      string
+		Run       func(d *Debugger) RunResult
+		ExpReason StopReason
+		ExpPC     Word
+		ExpSP     Word
+		ExpA      Word
+	}
+
+	type Test struct {
+		Name  string
+		Steps []Step
+	}
+
+	stepInto := func(d *Debugger) RunResult { return d.StepInto(ctx) }
+	stepOver := func(d *Debugger) RunResult { return d.StepOver(ctx) }
+	stepOut := func(d *Debugger) RunResult { return d.StepOut(ctx) }
+	resume := func(d *Debugger) RunResult { return d.Continue(ctx) }
+
+	tests := []Test{
+		{
+			Name: "step over",
+			Steps: []Step{
+				{"over JSR", stepOver, StopStep, 0x0001, 0xffff, 11},
+				{"over SET", stepOver, StopStep, 0x0002, 0xffff, 11},
+			},
+		},
+		{
+			Name: "step into and out",
+			Steps: []Step{
+				{"into JSR", stepInto, StopStep, 0x0004, 0xfffe, 0},
+				{"over nested JSR", stepOver, StopStep, 0x0005, 0xfffe, 10},
+				{"out", stepOut, StopStep, 0x0001, 0xffff, 11},
+			},
+		},
+		{
+			Name: "step out of nested",
+			Steps: []Step{
+				{"into JSR", stepInto, StopStep, 0x0004, 0xfffe, 0},
+				{"into cancelled", func(d *Debugger) RunResult {
+					return d.StepInto(cancelled)
+				}, StopCancelled, 0x0004, 0xfffe, 0},
+				{"into nested JSR", stepInto, StopStep, 0x0008, 0xfffd, 0},
+				{"out of nested", stepOut, StopStep, 0x0005, 0xfffe, 10},
+				{"out", stepOut, StopStep, 0x0001, 0xffff, 11},
+			},
+		},
+		{
+			Name: "breakpoints",
+			Steps: []Step{
+				{"add", func(d *Debugger) RunResult {
+					d.AddBreakpoint(0x0008)
+					d.AddTemporaryBreakpoint(0x0005)
+					d.AddTemporaryBreakpoint(0x0002)
+					return d.StepOver(ctx)
+				}, StopBreakpoint, 0x0008, 0xfffd, 0},
+				{"continue", resume, StopBreakpoint, 0x0005, 0xfffe, 10},
+				{"continue past temporary", func(d *Debugger) RunResult {
+					d.Emulator.State.WritePC(0x0004)
+					return d.Continue(ctx)
+				}, StopBreakpoint, 0x0008, 0xfffd, 10},
+				{"step out to removed temporary", stepOut, StopStep, 0x0005, 0xfffe, 20},
+				{"continue to temporary", func(d *Debugger) RunResult {
+					d.RemoveBreakpoint(0x0008)
+					return d.Continue(ctx)
+				}, StopBreakpoint, 0x0002, 0xffff, 21},
+				{"cancelled", func(d *Debugger) RunResult {
+					return d.Continue(cancelled)
+				}, StopCancelled, 0x0002, 0xffff, 21},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		state := &D16MachineState{}
+		state.Init()
+		copy(state.D16MemoryState.Data[:], debugTestProgram)
+		d := NewDebugger(state)
+		for _, step := range test.Steps {
+			name := test.Name + "::" + step.Name
+			result := step.Run(d)
+			if result.Reason != step.ExpReason {
+				t.Errorf("%s: stopped for %v (%v), expected %v", name, result.Reason, result.Err, step.ExpReason)
+			}
+			if state.PC() != step.ExpPC || state.SP() != step.ExpSP || state.Register(RegA) != step.ExpA {
+				t.Errorf("%s: got PC=%04x SP=%04x A=%d, expected PC=%04x SP=%04x A=%d", name,
+					state.PC(), state.SP(), state.Register(RegA), step.ExpPC, step.ExpSP, step.ExpA)
+			}
+		}
+	}
+}
+
+func TestDebuggerBreakpoints(t *testing.T) {
+	d := NewDebugger(&D16MachineState{})
+	d.AddBreakpoint(0x0010)
+	d.AddTemporaryBreakpoint(0x0010)
+	d.AddTemporaryBreakpoint(0x0002)
+	if !d.RemoveBreakpoint(0x0002) {
+		t.Errorf("RemoveBreakpoint returned false for a breakpoint")
+	}
+	if d.RemoveBreakpoint(0x0003) {
+		t.Errorf("RemoveBreakpoint returned true without a breakpoint")
+	}
+	d.AddBreakpoint(0x0001)
+	breakpoints := d.Breakpoints()
+	if len(breakpoints) != 2 || breakpoints[0] != 0x0001 || breakpoints[1] != 0x0010 {
+		t.Errorf("got breakpoints %v, expected [1 16]", breakpoints)
+	}
+	if temporary := d.breakpoints[0x0010]; temporary {
+		t.Errorf("temporary breakpoint replaced a breakpoint")
+	}
+}
@@ -0,0 +1,80 @@
+package core
+
+import (
+	"sync"
+)
+
+// decodeEntry is the precomputed decoding of an instruction word.
+type decodeEntry struct {
+	// info is nil if the word does not decode to a valid instruction.
+	info *InstructionInfo
+	// a and b are the operands of the instruction, without their next words.
+	a, b Operand
+}
+
+// numExtraWords returns the number of extra words required by the instruction.
+func (e *decodeEntry) numExtraWords() Word {
+	return e.a.NumExtraWords() + e.b.NumExtraWords()
+}
+
+// decoded returns the decoded instruction, without its next words.
+func (e *decodeEntry) decoded() DecodedInstruction {
+	return DecodedInstruction{Info: e.info, A: e.a, B: e.b}
+}
+
+// instruction returns the instruction from the given sets, indexed by opcode,
+// with its values set to a and b, which are first set from the entry.
+func (e *decodeEntry) instruction(unarySet []UnaryInstruction, binarySet []BinaryInstruction, a, b *Operand) Instruction {
+	*a, *b = e.a, e.b
+	if e.info.Kind == UnaryKind {
+		instruction := unarySet[e.info.OpCode]
+		instruction.SetUnaryValue(a)
+		return instruction
+	}
+	instruction := binarySet[e.info.OpCode]
+	instruction.SetBinaryValue(a, b)
+	return instruction
+}
+
+// decodeTable holds the decoding of every possible instruction word, so that
+// decoding is a table lookup rather than a series of switches.
+type decodeTable [0x10000]decodeEntry
+
+// zeroWordLoader loads zero words.
+type zeroWordLoader struct{}
+
+func (l zeroWordLoader) WordLoad() (Word, error) {
+	return 0, nil
+}
+
+func (l zeroWordLoader) SkipWords(Word) error {
+	return nil
+}
+
+// newDecodeTable creates a decodeTable, using the given function to decode
+// each word.
+func newDecodeTable(decodeWord func(word Word) (DecodedInstruction, error)) *decodeTable {
+	table := new(decodeTable)
+	for i := range table {
+		d, err := decodeWord(Word(i))
+		if err != nil {
+			continue
+		}
+		table[i] = decodeEntry{info: d.Info, a: d.A, b: d.B}
+	}
+	return table
+}
+
+// lazyDecodeTable creates a decodeTable on first use.
+type lazyDecodeTable struct {
+	once       sync.Once
+	table      *decodeTable
+	decodeWord func(word Word) (DecodedInstruction, error)
+}
+
+func (l *lazyDecodeTable) get() *decodeTable {
+	l.once.Do(func() {
+		l.table = newDecodeTable(l.decodeWord)
+	})
+	return l.table
+}
@@ -0,0 +1,95 @@
+package core
+
+import (
+	"testing"
+)
+
+func checkDecodeTable(t *testing.T, name string, table *decodeTable, decodeWord func(Word) (DecodedInstruction, error)) {
+	for i := range table {
+		entry := &table[i]
+		d, err := decodeWord(Word(i))
+		if err != nil {
+			if entry.info != nil {
+				t.Errorf("%s: word %#04x has entry %v, expected error %v", name, i, entry.decoded(), err)
+			}
+			continue
+		}
+		if entry.decoded() != d {
+			t.Errorf("%s: word %#04x has entry %v, expected %v", name, i, entry.decoded(), d)
+		}
+	}
+}
+
+func TestDecodeTable(t *testing.T) {
+	checkDecodeTable(t, "1.7", d16DecodeTable.get(), new(D16InstructionSet).decodeWord)
+	checkDecodeTable(t, "1.1", d16V11DecodeTable.get(), new(D16V11InstructionSet).decodeWord)
+}
+
+func TestDecodeTableInstruction(t *testing.T) {
+	var set D16InstructionSet
+	for _, test := range notchExample {
+		instruction, err := set.Instruction(test.Words[0])
+		if err != nil {
+			t.Errorf("Instruction %#04x returned error %v", test.Words[0], err)
+			continue
+		}
+		if err := instruction.LoadNextWords(&FakeWordLoader{t, test.Words[1:], 0}); err != nil {
+			t.Errorf("Instruction %#04x returned error %v loading next words", test.Words[0], err)
+			continue
+		}
+		if str := instruction.String(); str != test.Str {
+			t.Errorf("Instruction %#v: expected %q, got %q", test.Words, test.Str, str)
+		}
+	}
+}
+
+func benchmarkDecode(b *testing.B, decode func(WordLoader) (DecodedInstruction, error)) {
+	words := concatTestInstructions(notchExample)
+	wordLoader := &sliceWordLoader{words: words}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if wordLoader.index >= len(words) {
+			wordLoader.index = 0
+		}
+		if _, err := decode(wordLoader); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeTable(b *testing.B) {
+	var set D16InstructionSet
+	benchmarkDecode(b, set.Decode)
+}
+
+func BenchmarkDecodeSwitch(b *testing.B) {
+	var set D16InstructionSet
+	benchmarkDecode(b, func(wordLoader WordLoader) (DecodedInstruction, error) {
+		word, err := wordLoader.WordLoad()
+		if err != nil {
+			return DecodedInstruction{}, err
+		}
+		d, err := set.decodeWord(word)
+		if err != nil {
+			return d, err
+		}
+		return d, d.loadNextWords(wordLoader, false)
+	})
+}
+
+// sliceWordLoader loads words from a slice, for benchmarks.
+type sliceWordLoader struct {
+	words []Word
+	index int
+}
+
+func (l *sliceWordLoader) WordLoad() (Word, error) {
+	w := l.words[l.index]
+	l.index++
+	return w, nil
+}
+
+func (l *sliceWordLoader) SkipWords(count Word) error {
+	l.index += int(count)
+	return nil
+}
@@ -0,0 +1,72 @@
+package core
+
+import (
+	"fmt"
+)
+
+// DecodedInstruction is a self-contained decoded instruction. Unlike the
+// Instruction returned by an InstructionSet, it does not refer to any state
+// held by the instruction set, so it can be copied, compared and retained (for
+// example in a slice) without being cloned.
+type DecodedInstruction struct {
+	// Info describes the instruction. It points into static tables of the
+	// instruction set that decoded the instruction, and must not be modified.
+	Info *InstructionInfo
+	A    Operand
+	// B has Kind NoOperand for unary instructions.
+	B Operand
+}
+
+// loadNextWords loads the next words of the operands. The next word of A comes
+// before that of B, unless bNextFirst is true.
+func (d *DecodedInstruction) loadNextWords(wordLoader WordLoader, bNextFirst bool) error {
+	if bNextFirst {
+		if err := d.B.LoadExtraWords(wordLoader); err != nil {
+			return err
+		}
+		return d.A.LoadExtraWords(wordLoader)
+	}
+	if err := d.A.LoadExtraWords(wordLoader); err != nil {
+		return err
+	}
+	return d.B.LoadExtraWords(wordLoader)
+}
+
+// Size returns the number of words in the instruction.
+func (d DecodedInstruction) Size() Word {
+	return 1 + d.A.NumExtraWords() + d.B.NumExtraWords()
+}
+
+// Instruction returns the executable instruction from set, which must be the
+// instruction set that decoded d. As with other instructions returned by an
+// instruction set, the result is only valid until the next instruction is
+// returned, and it refers to the operands of d.
+func (d *DecodedInstruction) Instruction(set InstructionSet) (Instruction, error) {
+	instruction, ok := set.InstructionByName(d.Info.Name)
+	if !ok {
+		return nil, UnknownInstructionError(d.Info.Name)
+	}
+	switch instruction := instruction.(type) {
+	case UnaryInstruction:
+		instruction.SetUnaryValue(&d.A)
+	case BinaryInstruction:
+		instruction.SetBinaryValue(&d.A, &d.B)
+	}
+	return instruction, nil
+}
+
+// Encode returns the words for the instruction, using set, which must be the
+// instruction set that decoded d.
+func (d *DecodedInstruction) Encode(set InstructionSet) ([]Word, error) {
+	if d.Info.Kind == UnaryKind {
+		return set.Encode(d.Info.Name, nil, &d.A)
+	}
+	return set.Encode(d.Info.Name, &d.B, &d.A)
+}
+
+func (d DecodedInstruction) String() string {
+	if d.Info.Kind == UnaryKind {
+		return fmt.Sprintf("%s %v", d.Info.Name, &d.A)
+	}
+	return fmt.Sprintf("%s %v, %v", d.Info.Name, &d.B, &d.A)
+}
@@ -0,0 +1,113 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+)
+
+func checkDecode(t *testing.T, set InstructionSet, tests []TestInstruction) {
+	// Retain all of the decoded instructions, to check that they do not alias
+	// each other.
+	var decoded []DecodedInstruction
+	for _, test := range tests {
+		wordLoader := &FakeWordLoader{t, test.Words, 0}
+		d, err := set.Decode(wordLoader)
+		if err != nil {
+			t.Errorf("Instruction %#v returned error %v", test.Words, err)
+			continue
+		}
+		if !wordLoader.exhausted() {
+			t.Errorf("Instruction %v (%#v) did not exhaust words to load", d, test.Words)
+		}
+		if int(d.Size()) != len(test.Words) {
+			t.Errorf("Instruction %v (%#v) has size %d", d, test.Words, d.Size())
+		}
+		decoded = append(decoded, d)
+	}
+
+	for i, d := range decoded {
+		test := tests[i]
+		if str := d.String(); test.Str != str {
+			t.Errorf("Instruction %#v disagrees on string repr (expected %q, got %q)",
+				test.Words, test.Str, str)
+		}
+		words, err := d.Encode(set)
+		if err != nil {
+			t.Errorf("Instruction %v encode returned error %v", d, err)
+		} else if fmt.Sprint(words) != fmt.Sprint(test.Words) {
+			t.Errorf("Instruction %v encoded as %#v, expected %#v", d, words, test.Words)
+		}
+
+		again, _ := set.Decode(&FakeWordLoader{t, test.Words, 0})
+		if again != d {
+			t.Errorf("Instruction %#v decoded differently: %#v and %#v", test.Words, d, again)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	checkDecode(t, &D16InstructionSet{}, notchExample)
+	checkDecode(t, &D16InstructionSet{}, []TestInstruction{
+		{"SET PUSH, A", []Word{0x0301}},
+		{"IAS 0x1234", []Word{0x7d40, 0x1234}},
+		{"SET C, PICK 0x0003", []Word{0x6841, 0x0003}},
+		{"ADD EX, -1", []Word{0x83a2}},
+	})
+	checkDecode(t, &D16V11InstructionSet{}, notchExampleV11)
+	checkDecode(t, &D16V11InstructionSet{}, []TestInstruction{
+		{"SET PUSH, O", []Word{0x75a1}},
+		{"SET [A+0x0001], [B+0x0002]", []Word{0x4501, 0x0001, 0x0002}},
+	})
+}
+
+func TestDecodeError(t *testing.T) {
+	var set D16InstructionSet
+	if _, err := set.Decode(&FakeWordLoader{t, []Word{0x0018}, 0}); err != InvalidBinaryOpCodeError(0x18) {
+		t.Errorf("expected InvalidBinaryOpCodeError, got %v", err)
+	}
+	if _, err := set.Decode(&FakeWordLoader{t, []Word{0x0000}, 0}); err != InvalidUnaryOpCodeError(0x00) {
+		t.Errorf("expected InvalidUnaryOpCodeError, got %v", err)
+	}
+}
+
+func TestDecodeAllocs(t *testing.T) {
+	var set D16InstructionSet
+	words := concatTestInstructions(notchExample)
+	wordLoader := &FakeWordLoader{t, words, 0}
+	allocs := testing.AllocsPerRun(100, func() {
+		wordLoader.Loc = 0
+		for wordLoader.Loc < len(words) {
+			if _, err := set.Decode(wordLoader); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("Decode allocated %v times per run", allocs)
+	}
+}
+
+func TestDecodedInstructionExecute(t *testing.T) {
+	var set D16InstructionSet
+	var state D16MachineState
+	state.Init()
+	state.WriteRegister(RegB, 0x0005)
+
+	// ADD A, B
+	d, err := set.Decode(&FakeWordLoader{t, []Word{0x0402}, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		instruction, err := d.Instruction(&set)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := instruction.Execute(&state); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if a := state.Register(RegA); a != 0x000a {
+		t.Errorf("expected A=0x000a, got 0x%04x", a)
+	}
+}
@@ -1,9 +1,292 @@
 package core
 
-func Step(state MachineState) error {
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Step delivers at most one pending interrupt, and then executes a single
+// instruction. Returns the number of ticks (cycles) taken. Errors are returned
+// as a *Fault, unless the fault handler chooses to continue execution. A
+// halting fault leaves the PC at the faulting instruction.
+func Step(state MachineState) (int, error) {
+	state.BeginInstruction()
+	ticks, err := step(state)
+	endErr := state.EndInstruction(ticks)
+	if _, ok := endErr.(*Fault); ok || err == nil {
+		// Faults from within the instruction precede any error at its end.
+		err = endErr
+	}
+	return ticks, err
+}
+
+func step(state MachineState) (int, error) {
+	if DeliverInterrupt(state) {
+		state.BeginInstruction()
+	}
+	pc := state.PC()
 	instruction, err := InstructionLoad(state, state)
+	if err == fetchDeniedError {
+		// The protection fault has been handled, so skip the instruction as a
+		// no-op.
+		_, words := decodeAt(state, pc)
+		state.WritePC(pc + Word(len(words)))
+		return 1, nil
+	}
+	if _, ok := err.(*Fault); ok {
+		// A protection fault has been handled, and halts.
+		state.WritePC(pc)
+		return 0, err
+	}
 	if err != nil {
-		return err
+		fault := NewFault(state, pc, err)
+		if err := state.HandleFault(state, fault); err != nil {
+			// Halt at the faulting instruction.
+			state.WritePC(pc)
+			return 0, err
+		}
+		// Skip the instruction as a no-op.
+		state.WritePC(pc + Word(len(fault.Words)))
+		return 1, nil
+	}
+	ticks, err := instruction.Execute(state)
+	if err != nil {
+		if err := state.HandleFault(state, NewFault(state, pc, err)); err != nil {
+			// Halt at the faulting instruction. Registers and memory that it
+			// changed before failing are left changed.
+			state.WritePC(pc)
+			return ticks, err
+		}
+	}
+	return ticks, nil
+}
+
+// ClockRate is the clock rate of the DCPU-16, in cycles per second.
+const ClockRate = 100000
+
+const (
+	// pacingInterval is the number of cycles between checks of the wall clock
+	// when running in real time.
+	pacingInterval = ClockRate / 100
+	// maxPacingLag is how far execution may fall behind the wall clock before
+	// it stops trying to catch up.
+	maxPacingLag = 100 * time.Millisecond
+)
+
+// StopReason is the reason that an Emulator stopped running.
+type StopReason uint8
+
+const (
+	// StopCancelled is returned when the context is cancelled.
+	StopCancelled StopReason = iota
+	// StopBudget is returned when the cycle budget is used.
+	StopBudget
+	// StopBreakpoint is returned when the predicate of RunUntil is satisfied,
+	// or a watchpoint pauses execution.
+	StopBreakpoint
+	// StopFault is returned when Step returns any other error.
+	StopFault
+	// StopStep is returned when a step of a Debugger finishes.
+	StopStep
+)
+
+func (r StopReason) String() string {
+	switch r {
+	case StopCancelled:
+		return "cancelled"
+	case StopBudget:
+		return "budget"
+	case StopBreakpoint:
+		return "breakpoint"
+	case StopFault:
+		return "fault"
+	case StopStep:
+		return "step"
+	}
+	return fmt.Sprintf("StopReason(%d)", r)
+}
+
+// RunResult describes a run of an Emulator.
+type RunResult struct {
+	Reason StopReason
+	// Steps and Cycles are those executed by the run.
+	Steps  uint64
+	Cycles uint64
+	// Err is the error from Step for StopFault, or from a watchpoint for
+	// StopBreakpoint, or from the context for StopCancelled.
+	Err error
+}
+
+// Emulator runs a machine by repeatedly calling Step. Only one run may be in
+// progress at a time, but Pause, Resume, Paused and Cycles may be called from
+// other goroutines.
+type Emulator struct {
+	State MachineState
+	// RealTime paces execution to the wall clock at ClockRate.
+	RealTime bool
+
+	cycles uint64 // Accessed atomically.
+
+	pausedFlag int32 // Accessed atomically, set while paused.
+	mu         sync.Mutex
+	resume     chan struct{} // Closed when resumed.
+
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+// NewEmulator creates an Emulator for the machine.
+func NewEmulator(state MachineState) *Emulator {
+	return &Emulator{State: state, now: time.Now, sleep: time.Sleep}
+}
+
+// Cycles returns the number of cycles executed by all runs.
+func (e *Emulator) Cycles() uint64 {
+	return atomic.LoadUint64(&e.cycles)
+}
+
+// Pause suspends execution, until Resume is called. A run in progress does
+// not return while paused, unless its context is cancelled.
+func (e *Emulator) Pause() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if atomic.LoadInt32(&e.pausedFlag) == 0 {
+		e.resume = make(chan struct{})
+		atomic.StoreInt32(&e.pausedFlag, 1)
+	}
+}
+
+// Resume continues execution after Pause.
+func (e *Emulator) Resume() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if atomic.LoadInt32(&e.pausedFlag) != 0 {
+		atomic.StoreInt32(&e.pausedFlag, 0)
+		close(e.resume)
+	}
+}
+
+// Paused returns true if execution is paused.
+func (e *Emulator) Paused() bool {
+	return atomic.LoadInt32(&e.pausedFlag) != 0
+}
+
+// waitResume waits while paused, and returns false if the context is
+// cancelled first.
+func (e *Emulator) waitResume(ctx context.Context) bool {
+	e.mu.Lock()
+	resume := e.resume
+	paused := atomic.LoadInt32(&e.pausedFlag) != 0
+	e.mu.Unlock()
+	if !paused {
+		return true
+	}
+	select {
+	case <-resume:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Run runs until the context is cancelled, or Step returns an error.
+func (e *Emulator) Run(ctx context.Context) RunResult {
+	return e.run(ctx, 0, nil)
+}
+
+// RunCycles runs for at least n cycles, stopping before the first step after
+// n cycles have been executed, when the context is cancelled, or when Step
+// returns an error.
+func (e *Emulator) RunCycles(ctx context.Context, n uint64) RunResult {
+	if n == 0 {
+		return RunResult{Reason: StopBudget}
+	}
+	return e.run(ctx, n, nil)
+}
+
+// RunUntil runs until until returns true after a step, the context is
+// cancelled, or Step returns an error.
+func (e *Emulator) RunUntil(ctx context.Context, until func(state MachineState) bool) RunResult {
+	return e.run(ctx, 0, until)
+}
+
+// pacer keeps execution in time with the wall clock. Sleeps are calculated
+// from when pacing started, so that errors in sleeping do not accumulate.
+type pacer struct {
+	start  time.Time
+	cycles uint64 // Cycles executed when pacing started.
+	next   uint64 // Cycles at which to next check the clock.
+}
+
+func (e *Emulator) startPacing(p *pacer, cycles uint64) {
+	p.start = e.now()
+	p.cycles = cycles
+	p.next = cycles + pacingInterval
+}
+
+func (e *Emulator) pace(p *pacer, cycles uint64) {
+	p.next = cycles + pacingInterval
+	target := time.Duration(cycles-p.cycles) * time.Second / ClockRate
+	elapsed := e.now().Sub(p.start)
+	if target > elapsed {
+		e.sleep(target - elapsed)
+	} else if elapsed-target > maxPacingLag {
+		// Too far behind to catch up.
+		e.startPacing(p, cycles)
+	}
+}
+
+// run runs until the budget of cycles (if not zero) is used, until returns
+// true (if not nil), the context is cancelled, or Step returns an error.
+func (e *Emulator) run(ctx context.Context, budget uint64, until func(MachineState) bool) RunResult {
+	var result RunResult
+	var p pacer
+	if e.RealTime {
+		e.startPacing(&p, 0)
+	}
+	done := ctx.Done()
+	for {
+		select {
+		case <-done:
+			result.Reason, result.Err = StopCancelled, ctx.Err()
+			return result
+		default:
+		}
+		if atomic.LoadInt32(&e.pausedFlag) != 0 {
+			if !e.waitResume(ctx) {
+				continue
+			}
+			if e.RealTime {
+				e.startPacing(&p, result.Cycles)
+			}
+		}
+		if budget != 0 && result.Cycles >= budget {
+			result.Reason = StopBudget
+			return result
+		}
+
+		ticks, err := Step(e.State)
+		result.Steps++
+		result.Cycles += uint64(ticks)
+		atomic.AddUint64(&e.cycles, uint64(ticks))
+		if err != nil {
+			result.Err = err
+			if _, ok := err.(*WatchpointError); ok {
+				result.Reason = StopBreakpoint
+			} else {
+				result.Reason = StopFault
+			}
+			return result
+		}
+		if until != nil && until(e.State) {
+			result.Reason = StopBreakpoint
+			return result
+		}
+		if e.RealTime && result.Cycles >= p.next {
+			e.pace(&p, result.Cycles)
+		}
 	}
-	return instruction.Execute(state)
 }
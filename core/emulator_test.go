@@ -1,7 +1,9 @@
 package core
 
 import (
+	"context"
 	"testing"
+	"time"
 )
 
 type ExpMem struct {
@@ -33,12 +35,12 @@ type ErrState struct {
 func (exp *ErrState) StateCheck(t *testing.T, testName string, state *D16MachineState) bool {
 	testName = testName + "::" + exp.Name
 	for i := 0; i < (exp.NumSteps - 1); i++ {
-		err := Step(state)
+		_, err := Step(state)
 		if err != nil {
 			t.Fatalf("%s: unexpected emulation error: %v", testName, err)
 		}
 	}
-	err := Step(state)
+	_, err := Step(state)
 	if err == nil {
 		t.Fatalf("%s: no expected emulation error", testName)
 	}
@@ -49,14 +51,25 @@ type ExpState struct {
 	Name     string
 	NumSteps int
 	CPU      D16CPU
+	Ints     D16Interrupts
 	Mems     []ExpMem
 }
 
+// queuedInterrupts creates the expected interrupt controller state.
+func queuedInterrupts(queueing bool, messages ...Word) D16Interrupts {
+	var ints D16Interrupts
+	ints.WriteQueueInterrupts(queueing)
+	for _, message := range messages {
+		ints.TriggerInterrupt(message)
+	}
+	return ints
+}
+
 func (exp *ExpState) StateCheck(t *testing.T, testName string, state *D16MachineState) bool {
 	testName = testName + "::" + exp.Name
 
 	for i := 0; i < exp.NumSteps; i++ {
-		err := Step(state)
+		_, err := Step(state)
 		if err != nil {
 			t.Fatalf("%s: unexpected emulation error: %v", testName, err)
 		}
@@ -69,6 +82,13 @@ func (exp *ExpState) StateCheck(t *testing.T, testName string, state *D16Machine
 		return false
 	}
 
+	if !InterruptsEquals(&exp.Ints, &state.D16Interrupts) {
+		t.Errorf("%s: interrupt state", testName)
+		t.Errorf("expected: queueing=%t queue=%#v", exp.Ints.QueueInterrupts(), exp.Ints.QueuedInterrupts())
+		t.Errorf("got:      queueing=%t queue=%#v", state.QueueInterrupts(), state.QueuedInterrupts())
+		return false
+	}
+
 	for i := range exp.Mems {
 		if !exp.Mems[i].StateCheck(t, testName, state) {
 			return false
@@ -81,10 +101,71 @@ func (exp *ExpState) StateCheck(t *testing.T, testName string, state *D16Machine
 func TestStep(t *testing.T) {
 	type Test struct {
 		Name      string
+		ISA       InstructionSet
 		InitMem   []Word
 		ExpStates []StateChecker
 	}
 
+	notchExampleStates := []StateChecker{
+		&ExpState{
+			Name:     "after basic stuff",
+			NumSteps: 4,
+			CPU:      D16CPU{registers: [8]Word{0x0010}, pc: 0x000a, sp: 0xffff, ex: 0x0000},
+			Mems: []ExpMem{
+				{0x1000, []Word{0x0020}},
+			},
+		},
+		&ExpState{
+			Name:     "after loop init",
+			NumSteps: 2,
+			CPU:      D16CPU{registers: [8]Word{0x2000, 0, 0, 0, 0, 0, 10, 0}, pc: 0x000d, sp: 0xffff, ex: 0x0000},
+		},
+		&ExpState{
+			Name:     "after first test+iteration",
+			NumSteps: 4,
+			CPU:      D16CPU{registers: [8]Word{0x2000, 0, 0, 0, 0, 0, 9, 0}, pc: 0x000d, sp: 0xffff, ex: 0x0000},
+			Mems: []ExpMem{
+				{0x2010, []Word{0x0000}},
+			},
+		},
+		&ExpState{
+			Name:     "after loop completion",
+			NumSteps: 4*8 + 3,
+			CPU:      D16CPU{registers: [8]Word{0x2000, 0, 0, 0, 0, 0, 0, 0}, pc: 0x0013, sp: 0xffff, ex: 0x0000},
+			Mems: []ExpMem{
+				{0x2000, []Word{0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000}},
+			},
+		},
+		&ExpState{
+			Name:     "before JSR",
+			NumSteps: 1,
+			CPU:      D16CPU{registers: [8]Word{0x2000, 0, 0, 0x0004}, pc: 0x0014, sp: 0xffff, ex: 0x0000},
+		},
+		&ExpState{
+			Name:     "in testsub",
+			NumSteps: 1,
+			CPU:      D16CPU{registers: [8]Word{0x2000, 0, 0, 0x0004}, pc: 0x0018, sp: 0xfffe, ex: 0x0000},
+			Mems: []ExpMem{
+				{0xfffe, []Word{0x0016, 0x0000}},
+			},
+		},
+		&ExpState{
+			Name:     "returned from testsub",
+			NumSteps: 2,
+			CPU:      D16CPU{registers: [8]Word{0x2000, 0, 0, 0x0040}, pc: 0x0016, sp: 0xffff, ex: 0x0000},
+		},
+		&ExpState{
+			Name:     "entering crash loop",
+			NumSteps: 1,
+			CPU:      D16CPU{registers: [8]Word{0x2000, 0, 0, 0x0040}, pc: 0x001a, sp: 0xffff, ex: 0x0000},
+		},
+		&ExpState{
+			Name:     "still in crash loop",
+			NumSteps: 10,
+			CPU:      D16CPU{registers: [8]Word{0x2000, 0, 0, 0x0040}, pc: 0x001a, sp: 0xffff, ex: 0x0000},
+		},
+	}
+
 	tests := []Test{
 		{
 			Name:    "SET A, 0x0030",
@@ -124,6 +205,60 @@ func TestStep(t *testing.T) {
 				},
 			},
 		},
+		{
+			Name:    "ADD PUSH, 1",
+			InitMem: []Word{0x8b02},
+			ExpStates: []StateChecker{
+				&ExpState{
+					NumSteps: 1,
+					CPU:      D16CPU{pc: 0x0001, sp: 0xfffe},
+					Mems:     []ExpMem{{0xfffd, []Word{0x0000, 0x0001}}},
+				},
+			},
+		},
+		{
+			Name: "SET PUSH, 0x1234 / SET PUSH, PEEK",
+			InitMem: []Word{
+				0x7f01, 0x1234, // SET PUSH, 0x1234
+				0x6701, // SET PUSH, PEEK
+			},
+			ExpStates: []StateChecker{
+				&ExpState{
+					NumSteps: 2,
+					CPU:      D16CPU{pc: 0x0003, sp: 0xfffd},
+					Mems:     []ExpMem{{0xfffd, []Word{0x1234, 0x1234}}},
+				},
+			},
+		},
+		{
+			Name: "SET PUSH, 0x1234 / SET PUSH, PEEK (1.1)",
+			ISA:  &D16V11InstructionSet{},
+			InitMem: []Word{
+				0x7da1, 0x1234, // SET PUSH, 0x1234
+				0x65a1, // SET PUSH, PEEK
+			},
+			ExpStates: []StateChecker{
+				&ExpState{
+					NumSteps: 2,
+					CPU:      D16CPU{pc: 0x0003, sp: 0xfffd},
+					Mems:     []ExpMem{{0xfffd, []Word{0x0000, 0x1234}}},
+				},
+			},
+		},
+		{
+			Name: "SET PUSH, 0x0010 / JSR POP",
+			InitMem: []Word{
+				0x7f01, 0x0010, // SET PUSH, 0x0010
+				0x6020, // JSR POP
+			},
+			ExpStates: []StateChecker{
+				&ExpState{
+					NumSteps: 2,
+					CPU:      D16CPU{pc: 0x0010, sp: 0xfffe},
+					Mems:     []ExpMem{{0xfffe, []Word{0x0003}}},
+				},
+			},
+		},
 		{
 			Name:    "unknown instruction",
 			InitMem: []Word{0x0018},
@@ -139,79 +274,160 @@ func TestStep(t *testing.T) {
 				&ExpState{
 					Name:     "state check",
 					NumSteps: 0,
-					CPU:      D16CPU{pc: 0x0002, sp: 0xffff},
+					CPU:      D16CPU{pc: 0x0000, sp: 0xffff},
 				},
 			},
 		},
 		{
-			Name:    "Notch example",
-			InitMem: concatTestInstructions(notchExample),
+			Name: "IFE A, 1 / IFE A, 0 / SET B, 1 / SET C, 1",
+			InitMem: []Word{
+				0x8812, // IFE A, 1
+				0x8412, // IFE A, 0
+				0x8821, // SET B, 1
+				0x8841, // SET C, 1
+			},
 			ExpStates: []StateChecker{
 				&ExpState{
-					Name:     "after basic stuff",
-					NumSteps: 4,
-					CPU:      D16CPU{registers: [8]Word{0x0010}, pc: 0x000a, sp: 0xffff, ex: 0x0000},
-					Mems: []ExpMem{
-						{0x1000, []Word{0x0020}},
-					},
+					Name:     "skipped chain",
+					NumSteps: 1,
+					CPU:      D16CPU{pc: 0x0003, sp: 0xffff},
 				},
 				&ExpState{
-					Name:     "after loop init",
-					NumSteps: 2,
-					CPU:      D16CPU{registers: [8]Word{0x2000, 0, 0, 0, 0, 0, 10, 0}, pc: 0x000d, sp: 0xffff, ex: 0x0000},
+					Name:     "after chain",
+					NumSteps: 1,
+					CPU:      D16CPU{registers: [8]Word{0, 0, 0x0001}, pc: 0x0004, sp: 0xffff},
 				},
+			},
+		},
+		{
+			Name: "IFN A, 0 / IFG 0x1000, A / SET [0x2000], 1 / SET C, 1",
+			InitMem: []Word{
+				0x8413,         // IFN A, 0
+				0x03f4, 0x1000, // IFG 0x1000, A
+				0x8bc1, 0x2000, // SET [0x2000], 1
+				0x8841, // SET C, 1
+			},
+			ExpStates: []StateChecker{
 				&ExpState{
-					Name:     "after first test+iteration",
-					NumSteps: 4,
-					CPU:      D16CPU{registers: [8]Word{0x2000, 0, 0, 0, 0, 0, 9, 0}, pc: 0x000d, sp: 0xffff, ex: 0x0000},
-					Mems: []ExpMem{
-						{0x2010, []Word{0x0000}},
-					},
+					Name:     "skipped chain",
+					NumSteps: 1,
+					CPU:      D16CPU{pc: 0x0005, sp: 0xffff},
+					Mems:     []ExpMem{{0x2000, []Word{0x0000}}},
 				},
 				&ExpState{
-					Name:     "after loop completion",
-					NumSteps: 4*8 + 3,
-					CPU:      D16CPU{registers: [8]Word{0x2000, 0, 0, 0, 0, 0, 0, 0}, pc: 0x0013, sp: 0xffff, ex: 0x0000},
-					Mems: []ExpMem{
-						{0x2000, []Word{0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000}},
-					},
+					Name:     "after chain",
+					NumSteps: 1,
+					CPU:      D16CPU{registers: [8]Word{0, 0, 0x0001}, pc: 0x0006, sp: 0xffff},
 				},
+			},
+		},
+		{
+			Name:    "IFE A, 0 / IFE A, 1 / SET B, 1 / SET C, 1",
+			InitMem: []Word{0x8412, 0x8812, 0x8821, 0x8841},
+			ExpStates: []StateChecker{
 				&ExpState{
-					Name:     "before JSR",
-					NumSteps: 1,
-					CPU:      D16CPU{registers: [8]Word{0x2000, 0, 0, 0x0004}, pc: 0x0014, sp: 0xffff, ex: 0x0000},
+					Name:     "inner condition fails",
+					NumSteps: 2,
+					CPU:      D16CPU{pc: 0x0003, sp: 0xffff},
+				},
+			},
+		},
+		{
+			Name: "SET I, 16 / SET J, 0x0020 / STI [I], [J] / STI [I], [J]",
+			InitMem: []Word{
+				0xc4c1,         // SET I, 16
+				0x7ce1, 0x0020, // SET J, 0x0020
+				0x3dde, // STI [I], [J]
+				0x3dde, // STI [I], [J]
+				0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+				0xaaaa, 0xbbbb,
+			},
+			ExpStates: []StateChecker{
+				&ExpState{
+					NumSteps: 4,
+					CPU:      D16CPU{registers: [8]Word{0, 0, 0, 0, 0, 0, 0x0012, 0x0022}, pc: 0x0005, sp: 0xffff},
+					Mems:     []ExpMem{{0x0010, []Word{0xaaaa, 0xbbbb}}},
 				},
+			},
+		},
+		{
+			Name: "SET I, 17 / SET J, 0x0021 / STD [I], [J] / STD [I], [J]",
+			InitMem: []Word{
+				0xc8c1,         // SET I, 17
+				0x7ce1, 0x0021, // SET J, 0x0021
+				0x3ddf, // STD [I], [J]
+				0x3ddf, // STD [I], [J]
+				0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+				0xaaaa, 0xbbbb,
+			},
+			ExpStates: []StateChecker{
 				&ExpState{
-					Name:     "in testsub",
-					NumSteps: 1,
-					CPU:      D16CPU{registers: [8]Word{0x2000, 0, 0, 0x0004}, pc: 0x0018, sp: 0xfffe, ex: 0x0000},
-					Mems: []ExpMem{
-						{0xfffe, []Word{0x0016, 0x0000}},
-					},
+					NumSteps: 4,
+					CPU:      D16CPU{registers: [8]Word{0, 0, 0, 0, 0, 0, 0x000f, 0x001f}, pc: 0x0005, sp: 0xffff},
+					Mems:     []ExpMem{{0x0010, []Word{0xaaaa, 0xbbbb}}},
 				},
+			},
+		},
+		{
+			Name: "IAS 0x0010 / INT 0x0042 / SET C, 1",
+			InitMem: []Word{
+				0x7d40, 0x0010, // IAS 0x0010
+				0x7d00, 0x0042, // INT 0x0042
+				0x8841, // SET C, 1
+				0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+				0x0021, // SET B, A
+				0x8560, // RFI 0
+			},
+			ExpStates: []StateChecker{
 				&ExpState{
-					Name:     "returned from testsub",
+					Name:     "interrupt queued",
 					NumSteps: 2,
-					CPU:      D16CPU{registers: [8]Word{0x2000, 0, 0, 0x0040}, pc: 0x0016, sp: 0xffff, ex: 0x0000},
+					CPU:      D16CPU{pc: 0x0004, sp: 0xffff, ia: 0x0010},
+					Ints:     queuedInterrupts(false, 0x0042),
 				},
 				&ExpState{
-					Name:     "entering crash loop",
+					Name:     "in interrupt handler",
 					NumSteps: 1,
-					CPU:      D16CPU{registers: [8]Word{0x2000, 0, 0, 0x0040}, pc: 0x001a, sp: 0xffff, ex: 0x0000},
+					CPU:      D16CPU{registers: [8]Word{0x0042, 0x0042}, pc: 0x0011, sp: 0xfffd, ia: 0x0010},
+					Ints:     queuedInterrupts(true),
+					Mems:     []ExpMem{{0xfffd, []Word{0x0000, 0x0004}}},
 				},
 				&ExpState{
-					Name:     "still in crash loop",
-					NumSteps: 10,
-					CPU:      D16CPU{registers: [8]Word{0x2000, 0, 0, 0x0040}, pc: 0x001a, sp: 0xffff, ex: 0x0000},
+					Name:     "returned from interrupt",
+					NumSteps: 2,
+					CPU:      D16CPU{registers: [8]Word{0x0000, 0x0042, 0x0001}, pc: 0x0005, sp: 0xffff, ia: 0x0010},
 				},
 			},
 		},
+		{
+			Name: "interrupt queue overflow",
+			InitMem: []Word{
+				0x7d40, 0x0010, // IAS 0x0010
+				0x8980, // IAQ 1
+				0x8900, // INT 1
+				0x9381, // SET PC, 3
+			},
+			ExpStates: []StateChecker{
+				&ErrState{NumSteps: 2 + 2*MaxInterruptQueue + 1},
+			},
+		},
+		{
+			Name:      "Notch example",
+			InitMem:   concatTestInstructions(notchExample),
+			ExpStates: notchExampleStates,
+		},
+		{
+			Name:      "Notch example (1.1)",
+			ISA:       &D16V11InstructionSet{},
+			InitMem:   concatTestInstructions(notchExampleV11),
+			ExpStates: notchExampleStates,
+		},
 	}
 
 	for i := range tests {
 		test := &tests[i]
 
-		state := D16MachineState{}
+		state := D16MachineState{ISA: test.ISA}
 		state.D16CPU.Init()
 		copy(state.D16MemoryState.Data[0:], test.InitMem)
 
@@ -220,3 +436,315 @@ func TestStep(t *testing.T) {
 		}
 	}
 }
+
+func TestStepTicks(t *testing.T) {
+	type Test struct {
+		Name     string
+		InitMem  []Word
+		ExpTicks int
+	}
+
+	tests := []Test{
+		{"SET A, B", []Word{0x0401}, 1},
+		{"SET A, 0x0030", []Word{0x7c01, 0x0030}, 2},
+		{"ADD [0x1000], 0x0001", []Word{0x7fc2, 0x0001, 0x1000}, 4},
+		{"DIV A, 1", []Word{0x8806}, 3},
+		{"MOD A, [B+0x0010]", []Word{0x4408, 0x0010}, 4},
+		{"IFE A, 0 (success)", []Word{0x8412, 0x0401}, 2},
+		{"IFE A, 1 (failure)", []Word{0x8812, 0x0401}, 3},
+		{"IFE A, 1 (failure, chain of 2)", []Word{0x8812, 0x8412, 0x0401}, 4},
+		{"IFE A, 1 (failure, chain of 3)", []Word{0x8812, 0x8412, 0x03f4, 0x1000, 0x0401}, 5},
+		{"IFN A, 0x0000 (failure, chain of 2)", []Word{0x7c13, 0x0000, 0x8412, 0x0401}, 5},
+		{"ADX A, B", []Word{0x041a}, 3},
+		{"SBX A, B", []Word{0x041b}, 3},
+		{"STI A, B", []Word{0x041e}, 2},
+		{"STD [A], 0x0001", []Word{0x7d1f, 0x0001}, 3},
+		{"JSR 0x0010", []Word{0x7c20, 0x0010}, 4},
+		{"INT 5", []Word{0x9900}, 4},
+		{"IAG A", []Word{0x0120}, 1},
+	}
+
+	for _, test := range tests {
+		state := D16MachineState{}
+		state.Init()
+		copy(state.D16MemoryState.Data[0:], test.InitMem)
+
+		ticks, err := Step(&state)
+		if err != nil {
+			t.Errorf("%s: unexpected emulation error: %v", test.Name, err)
+			continue
+		}
+		if ticks != test.ExpTicks {
+			t.Errorf("%s: expected %d ticks, got %d", test.Name, test.ExpTicks, ticks)
+		}
+	}
+}
+
+func TestStepSkipWrap(t *testing.T) {
+	// Every instruction is a failing IFN A, A, so the chain of skipped
+	// instructions wraps around memory.
+	state := &D16MachineState{}
+	state.Init()
+	for i := range state.D16MemoryState.Data {
+		state.D16MemoryState.Data[i] = 0x0013
+	}
+
+	done := make(chan struct{})
+	var ticks int
+	var err error
+	go func() {
+		ticks, err = Step(state)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Step did not return")
+	}
+	if err != nil {
+		t.Fatalf("unexpected emulation error: %v", err)
+	}
+	if expTicks := 2 + MemorySize; ticks != expTicks {
+		t.Errorf("expected %d ticks, got %d", expTicks, ticks)
+	}
+	if pc := state.PC(); pc != 0x0001 {
+		t.Errorf("expected PC 0x0001, got 0x%04x", pc)
+	}
+}
+
+func BenchmarkStep(b *testing.B) {
+	var state D16MachineState
+	state.Init()
+	copy(state.D16MemoryState.Data[0:], concatTestInstructions(notchExample))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := Step(&state); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkStepV11(b *testing.B) {
+	state := D16MachineState{ISA: &D16V11InstructionSet{}}
+	state.Init()
+	copy(state.D16MemoryState.Data[0:], concatTestInstructions(notchExampleV11))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := Step(&state); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// runTestProgram increments A forever, taking 3 cycles per loop.
+var runTestProgram = []Word{
+	0x8802, // 0x0000: ADD A, 1
+	0x8781, // 0x0001: SET PC, 0
+}
+
+func newRunTestEmulator() (*Emulator, *D16MachineState) {
+	state := &D16MachineState{}
+	state.Init()
+	copy(state.D16MemoryState.Data[:], runTestProgram)
+	return NewEmulator(state), state
+}
+
+func TestEmulatorRun(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	type Test struct {
+		Name      string
+		Run       func(e *Emulator, state *D16MachineState) RunResult
+		ExpReason StopReason
+		ExpSteps  uint64
+		ExpCycles uint64
+		ExpA      Word
+	}
+
+	tests := []Test{
+		{
+			Name:      "budget",
+			Run:       func(e *Emulator, _ *D16MachineState) RunResult { return e.RunCycles(context.Background(), 10) },
+			ExpReason: StopBudget,
+			ExpSteps:  7,
+			ExpCycles: 11,
+			ExpA:      4,
+		},
+		{
+			Name: "until",
+			Run: func(e *Emulator, _ *D16MachineState) RunResult {
+				return e.RunUntil(context.Background(), func(state MachineState) bool { return state.Register(RegA) == 5 })
+			},
+			ExpReason: StopBreakpoint,
+			ExpSteps:  9,
+			ExpCycles: 14,
+			ExpA:      5,
+		},
+		{
+			Name: "watchpoint",
+			Run: func(e *Emulator, state *D16MachineState) RunResult {
+				state.AddWatchpoint(0x0001, 1, WatchExecute, func(MachineState, WatchEvent) bool {
+					return state.Register(RegA) == 2
+				})
+				return e.Run(context.Background())
+			},
+			ExpReason: StopBreakpoint,
+			ExpSteps:  4,
+			ExpCycles: 6,
+			ExpA:      2,
+		},
+		{
+			Name: "fault",
+			Run: func(e *Emulator, state *D16MachineState) RunResult {
+				state.D16MemoryState.Data[0x0001] = 0x0018
+				return e.Run(context.Background())
+			},
+			ExpReason: StopFault,
+			ExpSteps:  2,
+			ExpCycles: 2,
+			ExpA:      1,
+		},
+		{
+			Name:      "cancelled",
+			Run:       func(e *Emulator, _ *D16MachineState) RunResult { return e.Run(cancelled) },
+			ExpReason: StopCancelled,
+		},
+		{
+			Name:      "budget cancelled",
+			Run:       func(e *Emulator, _ *D16MachineState) RunResult { return e.RunCycles(cancelled, 10) },
+			ExpReason: StopCancelled,
+		},
+		{
+			Name: "until cancelled",
+			Run: func(e *Emulator, _ *D16MachineState) RunResult {
+				return e.RunUntil(cancelled, func(MachineState) bool { return false })
+			},
+			ExpReason: StopCancelled,
+		},
+	}
+
+	for _, test := range tests {
+		e, state := newRunTestEmulator()
+		result := test.Run(e, state)
+		if result.Reason != test.ExpReason || result.Steps != test.ExpSteps || result.Cycles != test.ExpCycles {
+			t.Errorf("%s: got %v after %d steps and %d cycles, expected %v after %d steps and %d cycles",
+				test.Name, result.Reason, result.Steps, result.Cycles, test.ExpReason, test.ExpSteps, test.ExpCycles)
+		}
+		if a := state.Register(RegA); a != test.ExpA {
+			t.Errorf("%s: got A=%04x, expected %04x", test.Name, a, test.ExpA)
+		}
+		if e.Cycles() != result.Cycles {
+			t.Errorf("%s: Cycles() returned %d, expected %d", test.Name, e.Cycles(), result.Cycles)
+		}
+		switch test.ExpReason {
+		case StopBreakpoint:
+			if _, ok := result.Err.(*WatchpointError); !ok && test.Name == "watchpoint" {
+				t.Errorf("%s: got error %v, expected *WatchpointError", test.Name, result.Err)
+			}
+		case StopFault:
+			if _, ok := result.Err.(*Fault); !ok {
+				t.Errorf("%s: got error %v, expected *Fault", test.Name, result.Err)
+			}
+		case StopCancelled:
+			if result.Err != context.Canceled {
+				t.Errorf("%s: got error %v, expected %v", test.Name, result.Err, context.Canceled)
+			}
+		}
+	}
+}
+
+func TestEmulatorPause(t *testing.T) {
+	e, _ := newRunTestEmulator()
+	e.Pause()
+	if !e.Paused() {
+		t.Fatalf("Paused returned false after Pause")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	results := make(chan RunResult)
+	go func() { results <- e.RunUntil(ctx, func(MachineState) bool { return false }) }()
+
+	time.Sleep(10 * time.Millisecond)
+	if cycles := e.Cycles(); cycles != 0 {
+		t.Errorf("executed %d cycles while paused", cycles)
+	}
+	e.Resume()
+	for e.Cycles() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	e.Pause()
+	cycles := e.Cycles()
+	time.Sleep(10 * time.Millisecond)
+	// At most one step may finish after pausing.
+	if paused := e.Cycles(); paused > cycles+2 {
+		t.Errorf("executed %d cycles after pausing", paused-cycles)
+	}
+
+	// Cancelling while paused stops the run.
+	cancel()
+	select {
+	case result := <-results:
+		if result.Reason != StopCancelled {
+			t.Errorf("run stopped for %v, expected %v", result.Reason, StopCancelled)
+		}
+		if result.Cycles != e.Cycles() {
+			t.Errorf("run returned %d cycles, expected %d", result.Cycles, e.Cycles())
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("run did not stop when cancelled")
+	}
+}
+
+// fakeClock is a clock for pacing that only advances when sleeping, or by
+// step on each reading.
+type fakeClock struct {
+	now       time.Time
+	step      time.Duration
+	overSleep time.Duration
+	sleeps    int
+}
+
+func (c *fakeClock) install(e *Emulator) {
+	e.now = func() time.Time {
+		now := c.now
+		c.now = c.now.Add(c.step)
+		return now
+	}
+	e.sleep = func(d time.Duration) {
+		c.sleeps++
+		c.now = c.now.Add(d + c.overSleep)
+	}
+}
+
+func TestEmulatorRealTime(t *testing.T) {
+	type Test struct {
+		Name       string
+		Clock      fakeClock
+		ExpElapsed time.Duration // Not checked if 0.
+		ExpSleeps  bool
+	}
+
+	tests := []Test{
+		{"exact", fakeClock{}, time.Second, true},
+		// Oversleeping is corrected by the following sleeps.
+		{"oversleep", fakeClock{overSleep: time.Millisecond}, time.Second, true},
+		// Too far behind to catch up, so never sleeps.
+		{"behind", fakeClock{step: time.Second}, 0, false},
+	}
+
+	for _, test := range tests {
+		e, _ := newRunTestEmulator()
+		e.RealTime = true
+		clock := test.Clock
+		clock.install(e)
+		start := clock.now
+		result := e.RunCycles(context.Background(), ClockRate)
+		elapsed := clock.now.Sub(start)
+		if diff := elapsed - test.ExpElapsed; test.ExpElapsed != 0 && (diff < -10*time.Millisecond || diff > 10*time.Millisecond) {
+			t.Errorf("%s: ran %d cycles in %v, expected %v", test.Name, result.Cycles, elapsed, test.ExpElapsed)
+		}
+		if (clock.sleeps > 0) != test.ExpSleeps {
+			t.Errorf("%s: slept %d times", test.Name, clock.sleeps)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+)
+
+// FaultClass classifies the cause of a Fault.
+type FaultClass uint8
+
+const (
+	IllegalOpCodeFault     FaultClass = iota // invalid opcode
+	IllegalOperandFault                      // value invalid or used in the wrong context
+	InterruptOverflowFault                   // interrupt queue overflow
+	DeviceFault                              // missing device, or error from a device
+	ProtectionFault                          // access denied by memory protection
+	NumFaultClasses        = iota
+)
+
+func (c FaultClass) String() string {
+	switch c {
+	case IllegalOpCodeFault:
+		return "illegal opcode"
+	case IllegalOperandFault:
+		return "illegal operand"
+	case InterruptOverflowFault:
+		return "interrupt queue overflow"
+	case DeviceFault:
+		return "device fault"
+	case ProtectionFault:
+		return "protection fault"
+	}
+	return fmt.Sprintf("FaultClass(%d)", c)
+}
+
+// classifiedError is implemented by errors that belong to a class of fault.
+type classifiedError interface {
+	faultClass() FaultClass
+}
+
+// faultClassOf returns the class of fault for the error. The errors of the
+// instruction sets and memory protection each belong to a class, so any other
+// error, such as one returned by a device, is a DeviceFault.
+func faultClassOf(err error) FaultClass {
+	var classified classifiedError
+	if errors.As(err, &classified) {
+		return classified.faultClass()
+	}
+	switch err {
+	case ValueContextError:
+		return IllegalOperandFault
+	case InterruptQueueOverflowError:
+		return InterruptOverflowFault
+	}
+	return DeviceFault
+}
+
+// Fault is the error returned by Step when executing an instruction fails. It
+// describes the instruction that caused the error.
+type Fault struct {
+	Class FaultClass
+	// PC is the address of the faulting instruction.
+	PC Word
+	// Words are the words of the faulting instruction. Only the first word is
+	// included if the instruction could not be decoded.
+	Words []Word
+	// Decoded is the faulting instruction, decoded as far as possible.
+	Decoded DecodedInstruction
+	// Err is the underlying error.
+	Err error
+}
+
+// NewFault creates a Fault for the error from the instruction at pc, reading
+// the instruction from memory. The class of the fault is that of the error.
+func NewFault(state MachineState, pc Word, err error) *Fault {
+	decoded, words := decodeAt(state, pc)
+	return &Fault{
+		Class:   faultClassOf(err),
+		PC:      pc,
+		Words:   words,
+		Decoded: decoded,
+		Err:     err,
+	}
+}
+
+func (f *Fault) Error() string {
+	instruction := fmt.Sprintf("%#04x", f.Words)
+	if f.Decoded.Info != nil {
+		instruction = f.Decoded.String()
+	}
+	return fmt.Sprintf("%v at PC 0x%04x (%s): %v", f.Class, f.PC, instruction, f.Err)
+}
+
+func (f *Fault) Unwrap() error {
+	return f.Err
+}
+
+// decodeAt decodes the instruction at pc as far as possible, and returns it
+// with its words, without side effects on the CPU or memory.
+func decodeAt(state MachineState, pc Word) (DecodedInstruction, []Word) {
+	memory := rawMemoryOf(state)
+	wordLoader := memoryWordLoader{memory, pc}
+	decoded, _ := state.Decode(&wordLoader)
+	words := make([]Word, wordLoader.address-pc)
+	for i := range words {
+		words[i] = memory.ReadMemory(pc + Word(i))
+	}
+	return decoded, words
+}
+
+// memoryWordLoader loads words from memory without side effects on the CPU.
+type memoryWordLoader struct {
+	memory  Memory
+	address Word
+}
+
+func (l *memoryWordLoader) WordLoad() (Word, error) {
+	w := l.memory.ReadMemory(l.address)
+	l.address++
+	return w, nil
+}
+
+func (l *memoryWordLoader) SkipWords(count Word) error {
+	l.address += count
+	return nil
+}
+
+// FaultAction is the action taken when a fault occurs.
+type FaultAction uint8
+
+const (
+	// HaltOnFault stops execution with the PC at the faulting instruction, and
+	// Step returns the fault.
+	HaltOnFault FaultAction = iota
+	// IgnoreFault continues execution. An instruction that could not be
+	// decoded is skipped as if it were a no-op.
+	IgnoreFault
+	// InterruptOnFault triggers an interrupt with the message for the class
+	// of fault, and continues as IgnoreFault.
+	InterruptOnFault
+	// CatchFireOnFault corrupts random memory, and continues as IgnoreFault.
+	CatchFireOnFault
+)
+
+func (a FaultAction) String() string {
+	switch a {
+	case HaltOnFault:
+		return "halt"
+	case IgnoreFault:
+		return "ignore"
+	case InterruptOnFault:
+		return "interrupt"
+	case CatchFireOnFault:
+		return "catch fire"
+	}
+	return fmt.Sprintf("FaultAction(%d)", a)
+}
+
+// fireDamage is the number of words corrupted by CatchFireOnFault.
+const fireDamage = 16
+
+// FaultHandler handles faults that occur during Step.
+type FaultHandler interface {
+	// HandleFault returns nil if execution should continue after the fault,
+	// otherwise the error for Step to return.
+	HandleFault(state MachineState, fault *Fault) error
+}
+
+// FaultPolicy is a FaultHandler that takes a configured action for each class
+// of fault. The zero value halts on all faults.
+type FaultPolicy struct {
+	Actions [NumFaultClasses]FaultAction
+	// Messages are the interrupt messages for InterruptOnFault.
+	Messages [NumFaultClasses]Word
+	// Rand is the source of randomness for CatchFireOnFault. If nil, the
+	// default source of math/rand is used.
+	Rand *rand.Rand
+}
+
+func (p *FaultPolicy) HandleFault(state MachineState, fault *Fault) error {
+	switch p.Actions[fault.Class] {
+	case IgnoreFault:
+		return nil
+	case InterruptOnFault:
+		if err := state.TriggerInterrupt(p.Messages[fault.Class]); err != nil {
+			return fault
+		}
+		return nil
+	case CatchFireOnFault:
+		p.catchFire(state)
+		return nil
+	}
+	return fault
+}
+
+// catchFire corrupts memory, without triggering watchpoints or protection, but
+// through PokeMemory where available so that the damage is recorded by any
+// undo log or tracer.
+func (p *FaultPolicy) catchFire(state MachineState) {
+	write := state.WriteMemory
+	if raw, ok := state.(RawMemory); ok {
+		write = raw.PokeMemory
+	}
+	random := rand.Uint32
+	if p.Rand != nil {
+		random = p.Rand.Uint32
+	}
+	for i := 0; i < fireDamage; i++ {
+		address, value := DWord(random()).Split()
+		write(address, value)
+	}
+}
@@ -0,0 +1,201 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+var faultHandlerImplTest FaultHandler = &FaultPolicy{}
+
+func TestStepFault(t *testing.T) {
+	type Test struct {
+		Name       string
+		InitMem    []Word
+		NumSteps   int
+		ExpClass   FaultClass
+		ExpPC      Word
+		ExpWords   []Word
+		ExpDecoded string // Empty if the opcode is invalid.
+		ExpErr     error
+	}
+
+	tests := []Test{
+		{
+			Name:     "unknown instruction",
+			InitMem:  []Word{0x0018},
+			NumSteps: 1,
+			ExpClass: IllegalOpCodeFault,
+			ExpWords: []Word{0x0018},
+			ExpErr:   InvalidBinaryOpCodeError(0x18),
+		},
+		{
+			Name:     "unknown special instruction",
+			InitMem:  []Word{0x0401, 0x7dc0, 0x1234},
+			NumSteps: 2,
+			ExpClass: IllegalOpCodeFault,
+			ExpPC:    0x0001,
+			ExpWords: []Word{0x7dc0},
+			ExpErr:   InvalidUnaryOpCodeError(0x0e),
+		},
+		{
+			Name:       "HWI with no device",
+			InitMem:    []Word{0x7e40, 0x0005},
+			NumSteps:   1,
+			ExpClass:   DeviceFault,
+			ExpWords:   []Word{0x7e40, 0x0005},
+			ExpDecoded: "HWI 0x0005",
+			ExpErr:     NoSuchDeviceError(5),
+		},
+		{
+			Name: "interrupt queue overflow",
+			InitMem: []Word{
+				0x7d40, 0x0010, // IAS 0x0010
+				0x8980, // IAQ 1
+				0x8900, // INT 1
+				0x9381, // SET PC, 3
+			},
+			NumSteps:   2 + 2*MaxInterruptQueue + 1,
+			ExpClass:   InterruptOverflowFault,
+			ExpPC:      0x0003,
+			ExpWords:   []Word{0x8900},
+			ExpDecoded: "INT 1",
+			ExpErr:     InterruptQueueOverflowError,
+		},
+	}
+
+	for _, test := range tests {
+		var state D16MachineState
+		state.Init()
+		copy(state.D16MemoryState.Data[0:], test.InitMem)
+
+		var err error
+		for i := 0; i < test.NumSteps && err == nil; i++ {
+			_, err = Step(&state)
+		}
+		fault, ok := err.(*Fault)
+		if !ok {
+			t.Errorf("%s: expected *Fault, got %#v", test.Name, err)
+			continue
+		}
+		if fault.Class != test.ExpClass {
+			t.Errorf("%s: expected class %v, got %v", test.Name, test.ExpClass, fault.Class)
+		}
+		if fault.PC != test.ExpPC {
+			t.Errorf("%s: expected PC 0x%04x, got 0x%04x", test.Name, test.ExpPC, fault.PC)
+		}
+		if pc := state.PC(); pc != fault.PC {
+			t.Errorf("%s: halted with PC 0x%04x, expected 0x%04x", test.Name, pc, fault.PC)
+		}
+		if !wordsEqual(fault.Words, test.ExpWords) {
+			t.Errorf("%s: expected words %#v, got %#v", test.Name, test.ExpWords, fault.Words)
+		}
+		if test.ExpDecoded == "" {
+			if fault.Decoded.Info != nil {
+				t.Errorf("%s: expected no instruction info, got %v", test.Name, fault.Decoded.Info)
+			}
+		} else if str := fault.Decoded.String(); str != test.ExpDecoded {
+			t.Errorf("%s: expected decoded %q, got %q", test.Name, test.ExpDecoded, str)
+		}
+		if !errors.Is(err, test.ExpErr) {
+			t.Errorf("%s: expected error %v, got %v", test.Name, test.ExpErr, fault.Err)
+		}
+	}
+}
+
+func TestFaultPolicy(t *testing.T) {
+	initMem := []Word{
+		0x7d40, 0x0100, // IAS 0x0100
+		0x0018,         // unknown instruction
+		0x7c01, 0x0030, // SET A, 0x0030
+	}
+	newState := func(action FaultAction) *D16MachineState {
+		state := &D16MachineState{}
+		state.Init()
+		state.FaultPolicy.Actions[IllegalOpCodeFault] = action
+		state.FaultPolicy.Messages[IllegalOpCodeFault] = 0x0042
+		state.FaultPolicy.Rand = rand.New(rand.NewSource(1))
+		copy(state.D16MemoryState.Data[0:], initMem)
+		return state
+	}
+
+	// HaltOnFault
+	state := newState(HaltOnFault)
+	Step(state)
+	if _, err := Step(state); err == nil {
+		t.Errorf("HaltOnFault: expected fault")
+	}
+
+	// IgnoreFault
+	state = newState(IgnoreFault)
+	for i := 0; i < 3; i++ {
+		if _, err := Step(state); err != nil {
+			t.Fatalf("IgnoreFault: unexpected error %v", err)
+		}
+	}
+	expCPU := D16CPU{registers: [8]Word{0x0030}, pc: 0x0005, sp: 0xffff, ia: 0x0100}
+	if !CPUEquals(&expCPU, &state.D16CPU) {
+		t.Errorf("IgnoreFault: expected CPU state %#v, got %#v", expCPU, state.D16CPU)
+	}
+
+	// InterruptOnFault
+	state = newState(InterruptOnFault)
+	for i := 0; i < 2; i++ {
+		if _, err := Step(state); err != nil {
+			t.Fatalf("InterruptOnFault: unexpected error %v", err)
+		}
+	}
+	if queued := state.QueuedInterrupts(); !wordsEqual(queued, []Word{0x0042}) {
+		t.Errorf("InterruptOnFault: expected queued interrupt 0x0042, got %#v", queued)
+	}
+	if state.PC() != 0x0003 {
+		t.Errorf("InterruptOnFault: expected PC 0x0003, got 0x%04x", state.PC())
+	}
+
+	// CatchFireOnFault
+	state = newState(CatchFireOnFault)
+	state.Undo = NewUndoLog(4 * fireDamage)
+	var trace bytes.Buffer
+	state.Trace = NewTracer(&trace, TraceJSON)
+	before := state.D16MemoryState.Data
+	for i := 0; i < 2; i++ {
+		if _, err := Step(state); err != nil {
+			t.Fatalf("CatchFireOnFault: unexpected error %v", err)
+		}
+	}
+	if before == state.D16MemoryState.Data {
+		t.Errorf("CatchFireOnFault: expected memory to be corrupted")
+	}
+	// The damage is traced, and can be undone.
+	state.Trace.Flush()
+	var record TraceRecord
+	decoder := json.NewDecoder(&trace)
+	for i := 0; i < 2; i++ {
+		if err := decoder.Decode(&record); err != nil {
+			t.Fatalf("CatchFireOnFault: decoding trace: %v", err)
+		}
+	}
+	if len(record.Memory) == 0 {
+		t.Errorf("CatchFireOnFault: damage was not traced")
+	}
+	if _, err := state.StepBack(2); err != nil {
+		t.Fatalf("CatchFireOnFault: StepBack returned error %v", err)
+	}
+	if before != state.D16MemoryState.Data {
+		t.Errorf("CatchFireOnFault: damage was not undone")
+	}
+}
+
+func wordsEqual(a, b []Word) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,64 @@
+package core
+
+import (
+	"errors"
+)
+
+// UnforkableMemoryError is returned when forking a machine with memory that
+// does not implement ForkableMemory.
+var UnforkableMemoryError = errors.New("machine memory cannot be forked")
+
+// ForkableMemory is Memory that can be copied for a forked machine.
+type ForkableMemory interface {
+	Memory
+	// ForkMemory returns a copy of the memory.
+	ForkMemory() Memory
+}
+
+// ForkableDevice is a Device with state that is copied for a forked machine.
+type ForkableDevice interface {
+	Device
+	// ForkDevice returns a copy of the device for the forked machine, which is
+	// complete except for its devices.
+	ForkDevice(child *D16MachineState) Device
+}
+
+// Fork returns a copy of the machine. If its Memory is ForkableMemory, such as
+// PagedMemory, then it is forked, otherwise the embedded D16MemoryState is
+// copied. Memory handlers, watchpoint functions, and devices that are not
+// ForkableDevice are shared with the copy, as is a non-nil ISA. The copy has no
+// undo log, tracer, profiler or coverage. The machine must not be running on
+// another goroutine during Fork, but the machine and its copy may then be used
+// concurrently.
+func (state *D16MachineState) Fork() (*D16MachineState, error) {
+	child := &D16MachineState{
+		ISA:           state.ISA,
+		D16CPU:        state.D16CPU,
+		D16Interrupts: state.D16Interrupts,
+		FaultPolicy:   state.FaultPolicy,
+		instructionPC: state.instructionPC,
+		pendingErr:    state.pendingErr,
+		cycles:        state.cycles,
+	}
+
+	switch memory := state.Memory.(type) {
+	case nil:
+		state.D16MemoryState.fork(&child.D16MemoryState)
+	case ForkableMemory:
+		child.Memory = memory.ForkMemory()
+	default:
+		return nil, UnforkableMemoryError
+	}
+	child.D16Watchpoints = state.D16Watchpoints.fork()
+	child.D16Protection = state.D16Protection.fork()
+
+	child.Devices = make([]Device, len(state.Devices))
+	for i, device := range state.Devices {
+		if forkable, ok := device.(ForkableDevice); ok {
+			device = forkable.ForkDevice(child)
+		}
+		child.Devices[i] = device
+	}
+
+	return child, nil
+}
@@ -0,0 +1,171 @@
+package core
+
+import (
+	"sync"
+	"testing"
+)
+
+var pagedMemoryForkableTest ForkableMemory = &PagedMemory{}
+var mmuDeviceForkableTest ForkableDevice = &MMUDevice{}
+
+func TestPagedMemoryFork(t *testing.T) {
+	parent, _ := NewPagedMemory(NumPages)
+	parent.WriteMemory(0x0000, 0x1111)
+	parent.WriteMemory(0x1000, 0x2222)
+
+	child := parent.Fork()
+	for i := range parent.frames {
+		if parent.frames[i] != child.frames[i] {
+			t.Errorf("frame %d is not shared after fork", i)
+		}
+	}
+
+	child.WriteMemory(0x0000, 0x3333)
+	parent.WriteMemory(0x1000, 0x4444)
+	if r := parent.ReadMemory(0x0000); r != 0x1111 {
+		t.Errorf("parent read 0x%04x, expected 0x1111", r)
+	}
+	if r := child.ReadMemory(0x0000); r != 0x3333 {
+		t.Errorf("child read 0x%04x, expected 0x3333", r)
+	}
+	if r := parent.ReadMemory(0x1000); r != 0x4444 {
+		t.Errorf("parent read 0x%04x, expected 0x4444", r)
+	}
+	if r := child.ReadMemory(0x1000); r != 0x2222 {
+		t.Errorf("child read 0x%04x, expected 0x2222", r)
+	}
+	if parent.frames[2] != nil || child.frames[2] != nil {
+		t.Errorf("unwritten frame was allocated")
+	}
+	if parent.frames[0] == child.frames[0] || parent.frames[1] == child.frames[1] {
+		t.Errorf("written frames are still shared")
+	}
+
+	// Page tables are independent.
+	child.MapPage(0x0, 0x1)
+	if r := parent.ReadMemory(0x0000); r != 0x1111 {
+		t.Errorf("parent read 0x%04x after child mapped page, expected 0x1111", r)
+	}
+}
+
+func TestMachineStateFork(t *testing.T) {
+	program := []Word{
+		0x8401,         // SET A, 0
+		0x8821,         // SET B, 1
+		0x7c41, 0x0020, // SET C, 0x0020
+		0x8640,                 // HWI 0
+		0x7fc1, 0xbeef, 0x1000, // SET [0x1000], 0xbeef
+	}
+
+	paged, _, _ := NewPagedMachineState(0x100)
+	flat := &D16MachineState{}
+	flat.Init()
+	flat.AttachDevice(&FakeDevice{})
+	for _, parent := range []*D16MachineState{paged, flat} {
+		for i, w := range program {
+			parent.WriteMemory(Word(i), w)
+		}
+		for i := 0; i < 3; i++ {
+			if _, err := Step(parent); err != nil {
+				t.Fatalf("unexpected emulation error: %v", err)
+			}
+		}
+
+		child, err := parent.Fork()
+		if err != nil {
+			t.Fatalf("Fork returned error %v", err)
+		}
+		if !CPUEquals(&parent.D16CPU, &child.D16CPU) {
+			t.Errorf("forked CPU state %#v, expected %#v", child.D16CPU, parent.D16CPU)
+		}
+
+		// The parent continues, while the child is diverted.
+		child.WriteRegister(RegC, 0x0030)
+		for i := 0; i < 2; i++ {
+			if _, err := Step(parent); err != nil {
+				t.Fatalf("unexpected emulation error: %v", err)
+			}
+			if _, err := Step(child); err != nil {
+				t.Fatalf("unexpected emulation error: %v", err)
+			}
+		}
+		if parent.PeekMemory(0x1000) != 0xbeef || child.PeekMemory(0x1000) != 0xbeef {
+			t.Errorf("memory write not seen")
+		}
+		if paged == parent {
+			memory := parent.Memory.(*PagedMemory)
+			childMemory := child.Memory.(*PagedMemory)
+			if memory.ReadBacking(0x20*PageSize) != 0xbeef || childMemory.ReadBacking(0x30*PageSize) != 0xbeef {
+				t.Errorf("pages not mapped independently")
+			}
+			if memory.ReadBacking(0x30*PageSize) != 0 || childMemory.ReadBacking(0x20*PageSize) != 0 {
+				t.Errorf("forked memory is not independent")
+			}
+		}
+		if parent.Register(RegC) != 0x0020 {
+			t.Errorf("parent register changed by child")
+		}
+	}
+
+	unforkable := &D16MachineState{Memory: MemoryFuncs{}}
+	if _, err := unforkable.Fork(); err != UnforkableMemoryError {
+		t.Errorf("expected UnforkableMemoryError, got %v", err)
+	}
+}
+
+func TestForkConcurrent(t *testing.T) {
+	paged, _, _ := NewPagedMachineState(NumPages)
+	flat := &D16MachineState{}
+	flat.Init()
+	for _, parent := range []*D16MachineState{paged, flat} {
+		for i, w := range []Word{
+			0x03c1, 0x1000, // SET [0x1000], A
+			0x8802, // ADD A, 1
+			0x8781, // SET PC, 0
+		} {
+			parent.WriteMemory(Word(i), w)
+		}
+		child, err := parent.Fork()
+		if err != nil {
+			t.Fatalf("Fork returned error %v", err)
+		}
+		child.WriteRegister(RegA, 0x8000)
+
+		// The parent and child write the same shared frame concurrently.
+		var wg sync.WaitGroup
+		for _, state := range []*D16MachineState{parent, child} {
+			wg.Add(1)
+			go func(state *D16MachineState) {
+				defer wg.Done()
+				for i := 0; i < 300; i++ {
+					Step(state)
+				}
+			}(state)
+		}
+		wg.Wait()
+		if r := parent.PeekMemory(0x1000); r != 0x0063 {
+			t.Errorf("parent read 0x%04x, expected 0x0063", r)
+		}
+		if r := child.PeekMemory(0x1000); r != 0x8063 {
+			t.Errorf("child read 0x%04x, expected 0x8063", r)
+		}
+	}
+}
+
+func BenchmarkFork(b *testing.B) {
+	state, _, _ := NewPagedMachineState(0x100)
+	for i, w := range concatTestInstructions(notchExample) {
+		state.WriteMemory(Word(i), w)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		child, err := state.Fork()
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := Step(child); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
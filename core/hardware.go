@@ -0,0 +1,58 @@
+package core
+
+import (
+	"fmt"
+)
+
+type NoSuchDeviceError Word
+
+func (err NoSuchDeviceError) Error() string {
+	return fmt.Sprintf("no hardware device at index 0x%04x", Word(err))
+}
+
+func (err NoSuchDeviceError) faultClass() FaultClass {
+	return DeviceFault
+}
+
+// Device is a piece of hardware that can be connected to the DCPU-16.
+type Device interface {
+	// ID returns the 32 bit word identifying the hardware id.
+	ID() DWord
+	// Version returns the hardware version.
+	Version() Word
+	// Manufacturer returns the 32 bit word identifying the manufacturer.
+	Manufacturer() DWord
+	// Interrupt is called when the DCPU-16 sends an interrupt to the device
+	// (HWI). Returns the number of ticks taken in addition to the base cost of
+	// HWI.
+	Interrupt(MachineState) (int, error)
+}
+
+type Hardware interface {
+	// NumDevices returns the number of connected hardware devices.
+	NumDevices() Word
+	// Device returns the device at the given index, and false if there is no
+	// such device.
+	Device(index Word) (Device, bool)
+}
+
+type D16Hardware struct {
+	Devices []Device
+}
+
+// AttachDevice connects the device, and returns its index.
+func (hw *D16Hardware) AttachDevice(device Device) Word {
+	hw.Devices = append(hw.Devices, device)
+	return Word(len(hw.Devices) - 1)
+}
+
+func (hw *D16Hardware) NumDevices() Word {
+	return Word(len(hw.Devices))
+}
+
+func (hw *D16Hardware) Device(index Word) (Device, bool) {
+	if int(index) >= len(hw.Devices) {
+		return nil, false
+	}
+	return hw.Devices[index], true
+}
@@ -0,0 +1,3 @@
+package core
+
+var hardwareImplTest Hardware = &D16Hardware{}
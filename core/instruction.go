@@ -1,7 +1,9 @@
 package core
 
 import (
+	"errors"
 	"fmt"
+	"strings"
 )
 
 type InvalidUnaryOpCodeError Word
@@ -10,15 +12,39 @@ func (err InvalidUnaryOpCodeError) Error() string {
 	return fmt.Sprintf("invalid unary opcode 0x%02x", Word(err))
 }
 
+func (err InvalidUnaryOpCodeError) faultClass() FaultClass {
+	return IllegalOpCodeFault
+}
+
 type InvalidBinaryOpCodeError Word
 
 func (err InvalidBinaryOpCodeError) Error() string {
 	return fmt.Sprintf("invalid binary opcode 0x%02x", Word(err))
 }
 
+func (err InvalidBinaryOpCodeError) faultClass() FaultClass {
+	return IllegalOpCodeFault
+}
+
+type UnknownVersionError string
+
+func (err UnknownVersionError) Error() string {
+	return fmt.Sprintf("unknown DCPU-16 version %q", string(err))
+}
+
+type UnknownInstructionError string
+
+func (err UnknownInstructionError) Error() string {
+	return fmt.Sprintf("unknown instruction %q", string(err))
+}
+
+var ValueCountError = errors.New("wrong number of values for instruction")
+
 type Instruction interface {
 	LoadNextWords(WordLoader) error
-	Execute(MachineState) error // TODO Return ticks.
+	// Execute performs the instruction, returning the number of ticks
+	// (cycles) that it took.
+	Execute(MachineState) (int, error)
 	// Create a copy of the instruction.
 	Clone() Instruction
 	String() string
@@ -27,11 +53,94 @@ type Instruction interface {
 type UnaryInstruction interface {
 	Instruction
 	SetUnaryValue(Value)
+	UnaryValue() Value
 }
 
 type BinaryInstruction interface {
 	Instruction
 	SetBinaryValue(Value, Value)
+	BinaryValues() (Value, Value)
+}
+
+// InstructionKind distinguishes the instruction encodings.
+type InstructionKind uint8
+
+const (
+	// BinaryKind instructions take values b and a. These are the "basic"
+	// instructions in the specification.
+	BinaryKind InstructionKind = iota
+	// UnaryKind instructions take a single value a. These are the "special"
+	// (or "non-basic") instructions in the specification.
+	UnaryKind
+)
+
+func (k InstructionKind) String() string {
+	switch k {
+	case BinaryKind:
+		return "binary"
+	case UnaryKind:
+		return "unary"
+	}
+	return fmt.Sprintf("InstructionKind(%d)", k)
+}
+
+// OperandMask is a set of operand positions within an instruction.
+type OperandMask uint8
+
+const (
+	OperandA OperandMask = 1 << iota
+	OperandB
+)
+
+// InstructionInfo describes an instruction in an instruction set.
+type InstructionInfo struct {
+	// Name is the upper case mnemonic of the instruction.
+	Name string
+	// OpCode is the opcode number of the instruction within its Kind.
+	OpCode Word
+	Kind   InstructionKind
+	// Operands is the set of operand positions that the instruction takes.
+	Operands OperandMask
+	// Writes is the set of operand positions that the instruction writes to.
+	// Literal values are not legal (or at least not useful) in these positions.
+	Writes OperandMask
+	// Ticks is the base cost of the instruction, excluding the costs of
+	// values that read the next word, and of skipping instructions.
+	Ticks int
+	// Conditional is true for the IFx instructions.
+	Conditional bool
+}
+
+// instructionInfoIndex indexes instruction information by name and opcode.
+type instructionInfoIndex struct {
+	byName map[string]*InstructionInfo
+	unary  [0x40]*InstructionInfo
+	binary [0x20]*InstructionInfo
+}
+
+func newInstructionInfoIndex(infos []InstructionInfo) *instructionInfoIndex {
+	index := &instructionInfoIndex{
+		byName: make(map[string]*InstructionInfo, len(infos)),
+	}
+	for i := range infos {
+		info := &infos[i]
+		index.byName[info.Name] = info
+		if info.Kind == UnaryKind {
+			index.unary[info.OpCode] = info
+		} else {
+			index.binary[info.OpCode] = info
+		}
+	}
+	return index
+}
+
+// lookup returns the information for the given case-insensitive name.
+func (index *instructionInfoIndex) lookup(name string) (InstructionInfo, bool) {
+	info, ok := index.byName[strings.ToUpper(name)]
+	if !ok {
+		return InstructionInfo{}, false
+	}
+	return *info, true
 }
 
 // InstructionSet is the common interface for the instruction set understood by
@@ -44,24 +153,115 @@ type InstructionSet interface {
 	// properly.
 	Instruction(word Word) (Instruction, error)
 
-	// Instruction returns the instruction for the given name.
+	// InstructionByName returns the instruction for the given
+	// (case-insensitive) mnemonic. The returned Instruction has no values, and
+	// must have SetUnaryValue or SetBinaryValue called on it (as appropriate
+	// to its kind) before it will function properly.
 	InstructionByName(string) (Instruction, bool)
 
+	// InstructionInfo returns information about the instruction with the
+	// given (case-insensitive) mnemonic.
+	InstructionInfo(name string) (InstructionInfo, bool)
+
+	// InstructionInfos returns information about all of the instructions in
+	// the set, which must not be modified.
+	InstructionInfos() []InstructionInfo
+
+	// Decode loads and decodes the next instruction from wordLoader, without
+	// reference to any state held by the instruction set. On error, the
+	// instruction is decoded as far as possible: Info is set if the opcode is
+	// valid, as are any valid operands.
+	Decode(wordLoader WordLoader) (DecodedInstruction, error)
+
+	// Encode returns the words for the named instruction with the given
+	// values. b must be nil for unary instructions. This is the inverse of
+	// InstructionLoad.
+	Encode(name string, b, a Value) ([]Word, error)
+
 	// NumExtraWords returns the number of extra words required to be read for
 	// the given instruction.
 	NumExtraWords(word Word) (Word, error)
+
+	// Conditional returns true if the given word is a conditional (IFx)
+	// instruction.
+	Conditional(word Word) bool
 }
 
-func InstructionSkip(wordLoader WordLoader, set InstructionSet) error {
-	word, err := wordLoader.WordLoad()
+// NewInstructionSet returns the instruction set for the given version of the
+// DCPU-16 specification, either "1.1" or "1.7".
+func NewInstructionSet(version string) (InstructionSet, error) {
+	switch version {
+	case "1.1":
+		return &D16V11InstructionSet{}, nil
+	case "1.7":
+		return &D16InstructionSet{}, nil
+	}
+	return nil, UnknownVersionError(version)
+}
+
+// encodeInstruction encodes the given instruction for instruction sets that
+// place value a in the upper 6 bits of the instruction word. The positions of
+// the opcode and of value b are given by their shifts. The next word of value
+// a comes before that of value b, unless bNextFirst is true.
+func encodeInstruction(info InstructionInfo, opShift, bShift uint, bNextFirst bool, values ValueSet, b, a Value) ([]Word, error) {
+	if (info.Kind == UnaryKind) != (b == nil) || a == nil {
+		return nil, ValueCountError
+	}
+
+	aCode, aNext, aHasNext, err := values.Encode(a, false)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	count, err := set.NumExtraWords(word)
+	words := []Word{info.OpCode<<opShift | aCode<<10}
+	if b == nil {
+		if aHasNext {
+			words = append(words, aNext)
+		}
+		return words, nil
+	}
+
+	bCode, bNext, bHasNext, err := values.Encode(b, true)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	words[0] |= bCode << bShift
+	if bHasNext && bNextFirst {
+		words = append(words, bNext)
+	}
+	if aHasNext {
+		words = append(words, aNext)
+	}
+	if bHasNext && !bNextFirst {
+		words = append(words, bNext)
+	}
+	return words, nil
+}
+
+// InstructionSkip skips the next instruction. If the skipped instruction is a
+// conditional, then the instruction following it is also skipped, and so on,
+// stopping after MemorySize instructions if the chain wraps around memory.
+// Returns the number of instructions skipped.
+func InstructionSkip(wordLoader WordLoader, set InstructionSet) (int, error) {
+	skipped := 0
+	for skipped < MemorySize {
+		word, err := wordLoader.WordLoad()
+		if err != nil {
+			return skipped, err
+		}
+		count, err := set.NumExtraWords(word)
+		if err != nil {
+			return skipped, err
+		}
+		err = wordLoader.SkipWords(count)
+		if err != nil {
+			return skipped, err
+		}
+		skipped++
+		if !set.Conditional(word) {
+			break
+		}
 	}
-	return wordLoader.SkipWords(count)
+	return skipped, nil
 }
 
 func InstructionLoad(wordLoader WordLoader, set InstructionSet) (Instruction, error) {
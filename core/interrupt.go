@@ -0,0 +1,111 @@
+package core
+
+import (
+	"errors"
+)
+
+// MaxInterruptQueue is the maximum number of interrupts that can be queued.
+const MaxInterruptQueue = 256
+
+// InterruptQueueOverflowError is returned when an interrupt is triggered while
+// the queue is full. The DCPU-16 is considered to have caught fire.
+var InterruptQueueOverflowError = errors.New("interrupt queue overflow, DCPU-16 caught fire")
+
+type Interrupts interface {
+	// QueueInterrupts returns true if interrupts are being queued rather than
+	// triggered.
+	QueueInterrupts() bool
+	WriteQueueInterrupts(queue bool)
+	// TriggerInterrupt adds an interrupt with the given message to the queue.
+	TriggerInterrupt(message Word) error
+	// NextInterrupt removes and returns the oldest queued interrupt message,
+	// and false if there is none.
+	NextInterrupt() (Word, bool)
+	// QueuedInterrupts returns the queued interrupt messages, oldest first.
+	QueuedInterrupts() []Word
+}
+
+func InterruptsEquals(a, b Interrupts) bool {
+	if a.QueueInterrupts() != b.QueueInterrupts() {
+		return false
+	}
+	aQueue, bQueue := a.QueuedInterrupts(), b.QueuedInterrupts()
+	if len(aQueue) != len(bQueue) {
+		return false
+	}
+	for i := range aQueue {
+		if aQueue[i] != bQueue[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// DeliverInterrupt delivers at most one queued interrupt, unless interrupt
+// queueing is enabled. If IA is 0 then the interrupt is discarded, otherwise
+// interrupt queueing is turned on, PC and A are pushed to the stack, PC is set
+// to IA and A is set to the interrupt message. Returns true if an interrupt
+// was delivered.
+func DeliverInterrupt(state MachineState) bool {
+	if state.QueueInterrupts() {
+		return false
+	}
+	message, ok := state.NextInterrupt()
+	if !ok {
+		return false
+	}
+	ia := state.IA()
+	if ia == 0 {
+		return false
+	}
+	state.WriteQueueInterrupts(true)
+	state.WriteMemory(state.DecReadSP(), state.PC())
+	state.WriteMemory(state.DecReadSP(), state.Register(RegA))
+	state.WritePC(ia)
+	state.WriteRegister(RegA, message)
+	return true
+}
+
+// D16Interrupts is the interrupt controller of the DCPU-16, holding a FIFO
+// queue of up to MaxInterruptQueue interrupt messages.
+type D16Interrupts struct {
+	queueing bool
+	queue    [MaxInterruptQueue]Word // Ring buffer of pending messages.
+	head     int                     // Index of the oldest message in queue.
+	length   int                     // Number of pending messages.
+}
+
+func (ints *D16Interrupts) QueueInterrupts() bool {
+	return ints.queueing
+}
+
+func (ints *D16Interrupts) WriteQueueInterrupts(queue bool) {
+	ints.queueing = queue
+}
+
+func (ints *D16Interrupts) TriggerInterrupt(message Word) error {
+	if ints.length >= MaxInterruptQueue {
+		return InterruptQueueOverflowError
+	}
+	ints.queue[(ints.head+ints.length)%MaxInterruptQueue] = message
+	ints.length++
+	return nil
+}
+
+func (ints *D16Interrupts) NextInterrupt() (Word, bool) {
+	if ints.length == 0 {
+		return 0, false
+	}
+	message := ints.queue[ints.head]
+	ints.head = (ints.head + 1) % MaxInterruptQueue
+	ints.length--
+	return message, true
+}
+
+func (ints *D16Interrupts) QueuedInterrupts() []Word {
+	messages := make([]Word, ints.length)
+	for i := range messages {
+		messages[i] = ints.queue[(ints.head+i)%MaxInterruptQueue]
+	}
+	return messages
+}
@@ -0,0 +1,38 @@
+package core
+
+import (
+	"testing"
+)
+
+var interruptsImplTest Interrupts = &D16Interrupts{}
+
+func TestD16InterruptsQueue(t *testing.T) {
+	var ints D16Interrupts
+
+	// Cycle through the ring buffer more than once.
+	for i := 0; i < 3*MaxInterruptQueue/2; i++ {
+		if err := ints.TriggerInterrupt(Word(i)); err != nil {
+			t.Fatalf("TriggerInterrupt(%d): unexpected error: %v", i, err)
+		}
+		message, ok := ints.NextInterrupt()
+		if !ok || message != Word(i) {
+			t.Fatalf("NextInterrupt() = %d, %t, expected %d, true", message, ok, i)
+		}
+	}
+	if _, ok := ints.NextInterrupt(); ok {
+		t.Errorf("NextInterrupt() on empty queue returned a message")
+	}
+
+	for i := 0; i < MaxInterruptQueue; i++ {
+		if err := ints.TriggerInterrupt(Word(i)); err != nil {
+			t.Fatalf("TriggerInterrupt(%d): unexpected error: %v", i, err)
+		}
+	}
+	if err := ints.TriggerInterrupt(0xffff); err != InterruptQueueOverflowError {
+		t.Errorf("expected InterruptQueueOverflowError, got %v", err)
+	}
+	queue := ints.QueuedInterrupts()
+	if len(queue) != MaxInterruptQueue || queue[0] != 0 || queue[MaxInterruptQueue-1] != MaxInterruptQueue-1 {
+		t.Errorf("unexpected queue contents %#v", queue)
+	}
+}
@@ -0,0 +1,76 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// LabelMap maps addresses to the names of labels in a program.
+type LabelMap map[Word]string
+
+// LabelSyntaxError is returned when reading a malformed line of a label map.
+type LabelSyntaxError struct {
+	Line int
+	Text string
+}
+
+func (err *LabelSyntaxError) Error() string {
+	return fmt.Sprintf("label map line %d: expected address and name, got %q", err.Line, err.Text)
+}
+
+// ReadLabelMap reads a label map with a label per line, as an address (in
+// decimal, or hex with a 0x prefix) followed by the name. Blank lines and lines
+// starting with ';' or '#' are ignored.
+func ReadLabelMap(r io.Reader) (LabelMap, error) {
+	labels := make(LabelMap)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || text[0] == ';' || text[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, &LabelSyntaxError{line, text}
+		}
+		address, err := strconv.ParseUint(fields[0], 0, 16)
+		if err != nil {
+			return nil, &LabelSyntaxError{line, text}
+		}
+		labels[Word(address)] = fields[1]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return labels, nil
+}
+
+// labelIndex finds the label at or before an address.
+type labelIndex struct {
+	labels    LabelMap
+	addresses []Word // Sorted.
+}
+
+func newLabelIndex(labels LabelMap) *labelIndex {
+	index := &labelIndex{labels: labels}
+	for address := range labels {
+		index.addresses = append(index.addresses, address)
+	}
+	sort.Slice(index.addresses, func(i, j int) bool { return index.addresses[i] < index.addresses[j] })
+	return index
+}
+
+// lookup returns the address and name of the label at or before the address,
+// and false if there is none.
+func (index *labelIndex) lookup(address Word) (Word, string, bool) {
+	i := sort.Search(len(index.addresses), func(i int) bool { return index.addresses[i] > address })
+	if i == 0 {
+		return 0, "", false
+	}
+	start := index.addresses[i-1]
+	return start, index.labels[start], true
+}
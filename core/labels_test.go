@@ -0,0 +1,56 @@
+package core
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadLabelMap(t *testing.T) {
+	labels, err := ReadLabelMap(strings.NewReader(`
+; comment
+0x0000 main
+# comment
+32 loop
+	0x1000   data
+`))
+	if err != nil {
+		t.Fatalf("ReadLabelMap returned error %v", err)
+	}
+	expected := LabelMap{0x0000: "main", 0x0020: "loop", 0x1000: "data"}
+	if len(labels) != len(expected) {
+		t.Errorf("got %v, expected %v", labels, expected)
+	}
+	for address, name := range expected {
+		if labels[address] != name {
+			t.Errorf("got label %q at 0x%04x, expected %q", labels[address], address, name)
+		}
+	}
+
+	if _, err := ReadLabelMap(strings.NewReader("main\n")); err == nil {
+		t.Errorf("expected error for line without address")
+	}
+	if _, err := ReadLabelMap(strings.NewReader("0x10000 main\n")); err == nil {
+		t.Errorf("expected error for address out of range")
+	}
+}
+
+func TestLabelIndexLookup(t *testing.T) {
+	index := newLabelIndex(LabelMap{0x0010: "a", 0x0020: "b"})
+	tests := []struct {
+		Address  Word
+		ExpStart Word
+		ExpName  string
+		ExpOk    bool
+	}{
+		{0x0000, 0, "", false},
+		{0x0010, 0x0010, "a", true},
+		{0x001f, 0x0010, "a", true},
+		{0xffff, 0x0020, "b", true},
+	}
+	for _, test := range tests {
+		start, name, ok := index.lookup(test.Address)
+		if start != test.ExpStart || name != test.ExpName || ok != test.ExpOk {
+			t.Errorf("lookup(0x%04x) = 0x%04x, %q, %v", test.Address, start, name, ok)
+		}
+	}
+}
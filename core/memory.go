@@ -1,15 +1,187 @@
 package core
 
+import (
+	"errors"
+)
+
 const MemorySize = 0x10000
 
+// InvalidMemoryRegionError is returned when mapping a region that is empty or
+// extends beyond the end of memory.
+var InvalidMemoryRegionError = errors.New("memory region is empty or out of range")
+
+// MemoryRegionOverlapError is returned when mapping a region that overlaps an
+// already mapped region.
+var MemoryRegionOverlapError = errors.New("memory region overlaps a mapped region")
+
+// validRegion returns true if size words from start are within memory.
+func validRegion(start Word, size int) bool {
+	return size > 0 && int(start)+size <= MemorySize
+}
+
+// RawMemory is implemented by machine states that can access memory without
+// the side effects of ReadMemory and WriteMemory, such as triggering
+// watchpoints or memory protection.
+type RawMemory interface {
+	PeekMemory(address Word) Word
+	PokeMemory(address Word, value Word)
+}
+
+// rawMemory adapts RawMemory to Memory.
+type rawMemory struct {
+	RawMemory
+}
+
+func (m rawMemory) ReadMemory(address Word) Word {
+	return m.PeekMemory(address)
+}
+
+func (m rawMemory) WriteMemory(address Word, value Word) {
+	m.PokeMemory(address, value)
+}
+
+// rawMemoryOf returns the memory of the state, without side effects if
+// possible.
+func rawMemoryOf(state MachineState) Memory {
+	if raw, ok := state.(RawMemory); ok {
+		return rawMemory{raw}
+	}
+	return state
+}
+
+// MemoryMapper is Memory that can map regions of its addresses to other
+// Memory, such as memory-mapped devices or ROM overlays.
+type MemoryMapper interface {
+	Memory
+	// MapMemory maps size words from start to handler. The handler is given
+	// addresses relative to start.
+	MapMemory(start Word, size int, handler Memory) error
+	// UnmapMemory removes the region mapped at start, and returns false if
+	// there is none.
+	UnmapMemory(start Word) bool
+}
+
+// MemoryFuncs adapts functions to Memory. If Read is nil then reads return
+// zero, and if Write is nil then writes are ignored.
+type MemoryFuncs struct {
+	Read  func(address Word) Word
+	Write func(address Word, value Word)
+}
+
+func (f MemoryFuncs) ReadMemory(address Word) Word {
+	if f.Read == nil {
+		return 0
+	}
+	return f.Read(address)
+}
+
+func (f MemoryFuncs) WriteMemory(address Word, value Word) {
+	if f.Write != nil {
+		f.Write(address, value)
+	}
+}
+
+// addressPageShift determines the granularity of addressPages.
+const addressPageShift = 8
+
+// addressPages records the pages that contain addresses needing special
+// handling, so that other addresses can be quickly excluded.
+type addressPages [MemorySize >> addressPageShift]bool
+
+func (p *addressPages) contains(address Word) bool {
+	return p[address>>addressPageShift]
+}
+
+func (p *addressPages) add(start Word, size int) {
+	first := int(start) >> addressPageShift
+	last := (int(start) + size - 1) >> addressPageShift
+	for page := first; page <= last; page++ {
+		p[page] = true
+	}
+}
+
+type memoryRegion struct {
+	start   Word
+	size    int
+	handler Memory
+}
+
+func (r *memoryRegion) contains(address Word) bool {
+	return address >= r.start && int(address-r.start) < r.size
+}
+
+// D16MemoryState is the memory of the DCPU-16. Accesses to mapped regions go to
+// their handlers, otherwise to Data.
 type D16MemoryState struct {
 	Data [MemorySize]Word
+
+	regions     []memoryRegion
+	mappedPages addressPages
 }
 
 func (mem *D16MemoryState) ReadMemory(address Word) Word {
+	if mem.mappedPages.contains(address) {
+		if region := mem.region(address); region != nil {
+			return region.handler.ReadMemory(address - region.start)
+		}
+	}
 	return mem.Data[address]
 }
 
 func (mem *D16MemoryState) WriteMemory(address Word, value Word) {
+	if mem.mappedPages.contains(address) {
+		if region := mem.region(address); region != nil {
+			region.handler.WriteMemory(address-region.start, value)
+			return
+		}
+	}
 	mem.Data[address] = value
 }
+
+func (mem *D16MemoryState) region(address Word) *memoryRegion {
+	for i := range mem.regions {
+		if mem.regions[i].contains(address) {
+			return &mem.regions[i]
+		}
+	}
+	return nil
+}
+
+func (mem *D16MemoryState) MapMemory(start Word, size int, handler Memory) error {
+	if !validRegion(start, size) {
+		return InvalidMemoryRegionError
+	}
+	for _, region := range mem.regions {
+		if int(start) < int(region.start)+region.size && int(region.start) < int(start)+size {
+			return MemoryRegionOverlapError
+		}
+	}
+	mem.regions = append(mem.regions, memoryRegion{start, size, handler})
+	mem.updateMappedPages()
+	return nil
+}
+
+func (mem *D16MemoryState) UnmapMemory(start Word) bool {
+	for i, region := range mem.regions {
+		if region.start == start {
+			mem.regions = append(mem.regions[:i:i], mem.regions[i+1:]...)
+			mem.updateMappedPages()
+			return true
+		}
+	}
+	return false
+}
+
+func (mem *D16MemoryState) updateMappedPages() {
+	mem.mappedPages = addressPages{}
+	for _, region := range mem.regions {
+		mem.mappedPages.add(region.start, region.size)
+	}
+}
+
+// fork copies the memory to child, which then shares the mapped regions until
+// either maps or unmaps a region.
+func (mem *D16MemoryState) fork(child *D16MemoryState) {
+	*child = *mem
+	child.regions = mem.regions[:len(mem.regions):len(mem.regions)]
+}
@@ -1,3 +1,105 @@
 package core
 
+import (
+	"testing"
+)
+
 var memoryStateImplTest Memory = &D16MemoryState{}
+
+var memoryMapperImplTest MemoryMapper = &D16MemoryState{}
+
+// fakeMemoryDevice records accesses to a mapped region.
+type fakeMemoryDevice struct {
+	data   [0x10]Word
+	reads  []Word
+	writes []Word
+}
+
+func (d *fakeMemoryDevice) ReadMemory(address Word) Word {
+	d.reads = append(d.reads, address)
+	return d.data[address]
+}
+
+func (d *fakeMemoryDevice) WriteMemory(address Word, value Word) {
+	d.writes = append(d.writes, address)
+	d.data[address] = value
+}
+
+func TestMapMemory(t *testing.T) {
+	var mem D16MemoryState
+	var device fakeMemoryDevice
+	if err := mem.MapMemory(0x8000, 0x10, &device); err != nil {
+		t.Fatalf("MapMemory returned error %v", err)
+	}
+
+	mem.WriteMemory(0x7fff, 0x1111)
+	mem.WriteMemory(0x8000, 0x2222)
+	mem.WriteMemory(0x800f, 0x3333)
+	mem.WriteMemory(0x8010, 0x4444)
+	if !wordsEqual(device.writes, []Word{0x0000, 0x000f}) {
+		t.Errorf("device got writes at %#v", device.writes)
+	}
+	if mem.Data[0x8000] != 0 || mem.Data[0x800f] != 0 {
+		t.Errorf("mapped writes reached memory")
+	}
+	if mem.Data[0x7fff] != 0x1111 || mem.Data[0x8010] != 0x4444 {
+		t.Errorf("unmapped writes did not reach memory")
+	}
+	if r := mem.ReadMemory(0x800f); r != 0x3333 {
+		t.Errorf("read 0x%04x from mapped memory, expected 0x3333", r)
+	}
+	if r := mem.ReadMemory(0x8010); r != 0x4444 {
+		t.Errorf("read 0x%04x from unmapped memory, expected 0x4444", r)
+	}
+	if !wordsEqual(device.reads, []Word{0x000f}) {
+		t.Errorf("device got reads at %#v", device.reads)
+	}
+
+	if err := mem.MapMemory(0x800f, 1, MemoryFuncs{}); err != MemoryRegionOverlapError {
+		t.Errorf("expected MemoryRegionOverlapError, got %v", err)
+	}
+	if err := mem.MapMemory(0xfff0, 0x11, MemoryFuncs{}); err != InvalidMemoryRegionError {
+		t.Errorf("expected InvalidMemoryRegionError, got %v", err)
+	}
+	if err := mem.MapMemory(0x0000, 0, MemoryFuncs{}); err != InvalidMemoryRegionError {
+		t.Errorf("expected InvalidMemoryRegionError, got %v", err)
+	}
+
+	if !mem.UnmapMemory(0x8000) {
+		t.Errorf("UnmapMemory failed")
+	}
+	if mem.UnmapMemory(0x8000) {
+		t.Errorf("UnmapMemory succeeded twice")
+	}
+	if r := mem.ReadMemory(0x800f); r != 0 {
+		t.Errorf("read 0x%04x from unmapped memory, expected 0x0000", r)
+	}
+}
+
+func TestMapMemoryStep(t *testing.T) {
+	// A ROM overlay containing a program that tries to overwrite itself.
+	rom := []Word{
+		0x8bc1, 0x0000, // SET [0x0000], 1
+		0x7c01, 0x0030, // SET A, 0x0030
+	}
+	var state D16MachineState
+	state.Init()
+	state.MapMemory(0x0000, len(rom), MemoryFuncs{
+		Read: func(address Word) Word {
+			return rom[address]
+		},
+	})
+
+	for i := 0; i < 2; i++ {
+		if _, err := Step(&state); err != nil {
+			t.Fatalf("unexpected emulation error: %v", err)
+		}
+	}
+	expCPU := D16CPU{registers: [8]Word{0x0030}, pc: 0x0004, sp: 0xffff}
+	if !CPUEquals(&expCPU, &state.D16CPU) {
+		t.Errorf("expected CPU state %#v, got %#v", expCPU, state.D16CPU)
+	}
+	if state.ReadMemory(0x0000) != 0x8bc1 {
+		t.Errorf("ROM was overwritten")
+	}
+}
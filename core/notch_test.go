@@ -8,7 +8,7 @@ type TestInstruction struct {
 // Test examples taken from dcpu-16_1.1.txt, and modified such that literal
 // lengths indicate "next word" (4 hex digits) vs "embedded literal" (2 hex
 // digits), and replacing labels with address values, and so that it works with
-// the supported DCPU version.
+// DCPU-16 1.7.
 var notchExample = []TestInstruction{
 	// Try some basic stuff
 	{"SET A, 0x0030", []Word{0x7c01, 0x0030}},
@@ -33,6 +33,32 @@ var notchExample = []TestInstruction{
 	{"SET PC, 0x001a", []Word{0x7f81, 0x001a}},
 }
 
+// The same examples as notchExample, with the original DCPU-16 1.1 encoding
+// from dcpu-16_1.1.txt.
+var notchExampleV11 = []TestInstruction{
+	// Try some basic stuff
+	{"SET A, 0x0030", []Word{0x7c01, 0x0030}},
+	{"SET [0x1000], 0x0020", []Word{0x7de1, 0x1000, 0x0020}},
+	{"SUB A, [0x1000]", []Word{0x7803, 0x1000}},
+	{"IFN A, 16", []Word{0xc00d}},
+	{"SET PC, 0x001a", []Word{0x7dc1, 0x001a}},
+	// Do a loopy thing
+	{"SET I, 10", []Word{0xa861}},
+	{"SET A, 0x2000", []Word{0x7c01, 0x2000}},
+	{"SET [I+0x2000], [A]", []Word{0x2161, 0x2000}},
+	{"SUB I, 1", []Word{0x8463}},
+	{"IFN I, 0", []Word{0x806d}},
+	{"SET PC, 0x000d", []Word{0x7dc1, 0x000d}},
+	// Call a subroutine
+	{"SET X, 4", []Word{0x9031}},
+	{"JSR 0x0018", []Word{0x7c10, 0x0018}},
+	{"SET PC, 0x001a", []Word{0x7dc1, 0x001a}},
+	{"SHL X, 4", []Word{0x9037}},
+	{"SET PC, POP", []Word{0x61c1}},
+	// Hang forever. X should now be 0x40 if everything went right.
+	{"SET PC, 0x001a", []Word{0x7dc1, 0x001a}},
+}
+
 func concatTestInstructions(instructions []TestInstruction) []Word {
 	var result []Word
 	for _, ins := range instructions {
@@ -0,0 +1,159 @@
+package core
+
+import (
+	"fmt"
+)
+
+// OperandKind identifies the kind of a value, independently of the encoding of
+// any particular instruction set.
+type OperandKind uint8
+
+const (
+	NoOperand                 OperandKind = iota
+	RegisterOperand                       // register
+	RegisterAddressOperand                // [register]
+	RegisterRelAddressOperand             // [register + next word]
+	PopOperand                            // POP / [SP++]
+	PushOperand                           // PUSH / [--SP]
+	PeekOperand                           // PEEK / [SP]
+	PickOperand                           // PICK n / [SP + next word]
+	SPOperand                             // SP
+	PCOperand                             // PC
+	EXOperand                             // EX
+	OOperand                              // O (the DCPU-16 1.1 name for EX)
+	AddressOperand                        // [next word]
+	WordOperand                           // next word (literal)
+	LiteralOperand                        // literal encoded in the instruction word
+)
+
+// Operand is a self-contained value. Unlike the values returned by a
+// ValueSet, an Operand is a plain value that can be copied, compared and
+// retained. It implements Value, although Clone allocates.
+type Operand struct {
+	Kind OperandKind
+	Reg  RegisterId // Register, for the register kinds.
+	Next Word       // Next word, or the value of a LiteralOperand.
+}
+
+// OperandOf returns the Operand equivalent to the given value, and false if
+// there is none.
+func OperandOf(v Value) (Operand, bool) {
+	switch v := v.(type) {
+	case *Operand:
+		return *v, true
+	case *RegisterValue:
+		return Operand{Kind: RegisterOperand, Reg: v.Reg}, true
+	case *RegisterAddressValue:
+		return Operand{Kind: RegisterAddressOperand, Reg: v.Reg}, true
+	case *RegisterRelAddressValue:
+		return Operand{Kind: RegisterRelAddressOperand, Reg: v.Reg, Next: v.Value}, true
+	case PopValue:
+		return Operand{Kind: PopOperand}, true
+	case PushValue:
+		return Operand{Kind: PushOperand}, true
+	case PeekValue:
+		return Operand{Kind: PeekOperand}, true
+	case *PickValue:
+		return Operand{Kind: PickOperand, Next: v.Value}, true
+	case SpValue:
+		return Operand{Kind: SPOperand}, true
+	case PcValue:
+		return Operand{Kind: PCOperand}, true
+	case EXValue:
+		return Operand{Kind: EXOperand}, true
+	case OValue:
+		return Operand{Kind: OOperand}, true
+	case *AddressValue:
+		return Operand{Kind: AddressOperand, Next: v.Value}, true
+	case *WordValue:
+		return Operand{Kind: WordOperand, Next: v.Value}, true
+	case *LiteralValue:
+		return Operand{Kind: LiteralOperand, Next: v.Literal}, true
+	}
+	return Operand{}, false
+}
+
+func (o *Operand) Resolve(state MachineState) Location {
+	switch o.Kind {
+	case RegisterOperand:
+		return Location{Kind: RegisterLocation, Reg: o.Reg}
+	case RegisterAddressOperand:
+		return Location{Kind: MemoryLocation, Word: state.Register(o.Reg)}
+	case RegisterRelAddressOperand:
+		return Location{Kind: MemoryLocation, Word: state.Register(o.Reg) + o.Next}
+	case PopOperand:
+		return Location{Kind: MemoryLocation, Word: state.ReadIncSP()}
+	case PushOperand:
+		return Location{Kind: MemoryLocation, Word: state.DecReadSP()}
+	case PeekOperand:
+		return Location{Kind: MemoryLocation, Word: state.SP()}
+	case PickOperand:
+		return Location{Kind: MemoryLocation, Word: state.SP() + o.Next}
+	case SPOperand:
+		return Location{Kind: SPLocation}
+	case PCOperand:
+		return Location{Kind: PCLocation}
+	case EXOperand, OOperand:
+		return Location{Kind: EXLocation}
+	case AddressOperand:
+		return Location{Kind: MemoryLocation, Word: o.Next}
+	}
+	// WordOperand and LiteralOperand.
+	return Location{Kind: LiteralLocation, Word: o.Next}
+}
+
+func (o *Operand) LoadExtraWords(wordLoader WordLoader) error {
+	if o.NumExtraWords() == 0 {
+		return nil
+	}
+	var err error
+	o.Next, err = wordLoader.WordLoad()
+	return err
+}
+
+func (o *Operand) NumExtraWords() Word {
+	switch o.Kind {
+	case RegisterRelAddressOperand, PickOperand, AddressOperand, WordOperand:
+		return 1
+	}
+	return 0
+}
+
+func (o *Operand) Clone() Value {
+	c := *o
+	return &c
+}
+
+func (o *Operand) String() string {
+	switch o.Kind {
+	case RegisterOperand:
+		return o.Reg.String()
+	case RegisterAddressOperand:
+		return "[" + o.Reg.String() + "]"
+	case RegisterRelAddressOperand:
+		return fmt.Sprintf("[%v+0x%04x]", o.Reg, o.Next)
+	case PopOperand:
+		return "POP"
+	case PushOperand:
+		return "PUSH"
+	case PeekOperand:
+		return "PEEK"
+	case PickOperand:
+		return fmt.Sprintf("PICK 0x%04x", o.Next)
+	case SPOperand:
+		return "SP"
+	case PCOperand:
+		return "PC"
+	case EXOperand:
+		return "EX"
+	case OOperand:
+		return "O"
+	case AddressOperand:
+		return fmt.Sprintf("[0x%04x]", o.Next)
+	case WordOperand:
+		return fmt.Sprintf("0x%04x", o.Next)
+	case LiteralOperand:
+		return fmt.Sprintf("%d", int16(o.Next))
+	}
+	return "<none>"
+}
@@ -0,0 +1,54 @@
+package core
+
+import (
+	"testing"
+)
+
+var operandImplTest Value = &Operand{}
+
+func TestOperandOf(t *testing.T) {
+	var valueSet D16ValueSet
+	for code := Word(0x00); code <= 0x3f; code++ {
+		for _, asValueB := range []bool{false, true} {
+			value, err := valueSet.Value(code, asValueB)
+			if err != nil {
+				continue
+			}
+			value.LoadExtraWords(&FakeWordLoader{t, []Word{0x1234}, 0})
+			o, ok := OperandOf(value)
+			if !ok {
+				t.Errorf("OperandOf(%#v) failed", value)
+				continue
+			}
+			if o.String() != value.String() {
+				t.Errorf("Operand %#v disagrees on string repr (expected %q, got %q)",
+					o, value.String(), o.String())
+			}
+			if o.NumExtraWords() != value.NumExtraWords() {
+				t.Errorf("Operand %#v disagrees on NumExtraWords", o)
+			}
+
+			// Check that resolving, reading and writing has the same effect.
+			var valueState, operandState D16MachineState
+			for _, state := range []*D16MachineState{&valueState, &operandState} {
+				state.Init()
+				state.D16CPU = D16CPU{registers: [8]Word{0x1000, 0x1001}, sp: 0xfff0}
+				for i := range state.Data {
+					state.Data[i] = Word(i)
+				}
+			}
+			loc, oLoc := value.Resolve(&valueState), o.Resolve(&operandState)
+			if loc != oLoc {
+				t.Errorf("Operand %v resolved to %#v, expected %#v", &o, oLoc, loc)
+			}
+			if r, or := loc.Read(&valueState), oLoc.Read(&operandState); r != or {
+				t.Errorf("Operand %v read 0x%04x, expected 0x%04x", &o, or, r)
+			}
+			loc.Write(&valueState, 0xbeef)
+			oLoc.Write(&operandState, 0xbeef)
+			if !CPUEquals(&valueState.D16CPU, &operandState.D16CPU) || valueState.Data != operandState.Data {
+				t.Errorf("Operand %v has different effect to %v", &o, value)
+			}
+		}
+	}
+}
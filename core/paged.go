@@ -0,0 +1,244 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+const (
+	// PageSize is the number of words in a page of PagedMemory.
+	PageSize = 0x1000
+	// NumPages is the number of pages in the address space of the DCPU-16.
+	NumPages = MemorySize / PageSize
+	// MaxFrames is the maximum number of frames in a PagedMemory.
+	MaxFrames = 0xffff
+
+	pageShift = 12
+)
+
+// InvalidFrameCountError is returned when creating a PagedMemory with too few
+// or too many frames.
+var InvalidFrameCountError = errors.New("invalid number of page frames")
+
+type InvalidPageError Word
+
+func (err InvalidPageError) Error() string {
+	return fmt.Sprintf("invalid page 0x%04x", Word(err))
+}
+
+type InvalidFrameError Word
+
+func (err InvalidFrameError) Error() string {
+	return fmt.Sprintf("invalid page frame 0x%04x", Word(err))
+}
+
+// pageFrame is a page sized unit of the backing store of PagedMemory, shared
+// between forks of the memory until written.
+type pageFrame struct {
+	refs  int32 // The number of memories sharing the frame. Accessed atomically.
+	words [PageSize]Word
+}
+
+// share adds a reference to the frame, for a fork.
+func (f *pageFrame) share() *pageFrame {
+	if f != nil {
+		atomic.AddInt32(&f.refs, 1)
+	}
+	return f
+}
+
+// release removes a reference to the frame.
+func (f *pageFrame) release() {
+	if f != nil {
+		atomic.AddInt32(&f.refs, -1)
+	}
+}
+
+// PagedMemory is Memory that maps each of the 16 pages of 4K words of the
+// DCPU-16 address space onto a frame of a larger backing store. Frames are
+// allocated when first written, and copied when written if they are shared
+// with a fork of the memory.
+type PagedMemory struct {
+	frames    []*pageFrame // nil frames contain zeros.
+	pageTable [NumPages]Word
+}
+
+// NewPagedMemory creates a PagedMemory with the given number of frames,
+// between NumPages and MaxFrames. Page n is initially mapped to frame n.
+func NewPagedMemory(numFrames int) (*PagedMemory, error) {
+	if numFrames < NumPages || numFrames > MaxFrames {
+		return nil, InvalidFrameCountError
+	}
+	m := &PagedMemory{
+		frames: make([]*pageFrame, numFrames),
+	}
+	for page := range m.pageTable {
+		m.pageTable[page] = Word(page)
+	}
+	return m, nil
+}
+
+// NumFrames returns the number of frames in the backing store.
+func (m *PagedMemory) NumFrames() int {
+	return len(m.frames)
+}
+
+// MapPage maps the page to the frame.
+func (m *PagedMemory) MapPage(page, frame Word) error {
+	if int(page) >= NumPages {
+		return InvalidPageError(page)
+	}
+	if int(frame) >= len(m.frames) {
+		return InvalidFrameError(frame)
+	}
+	m.pageTable[page] = frame
+	return nil
+}
+
+// PageFrame returns the frame that the page is mapped to.
+func (m *PagedMemory) PageFrame(page Word) (Word, error) {
+	if int(page) >= NumPages {
+		return 0, InvalidPageError(page)
+	}
+	return m.pageTable[page], nil
+}
+
+func (m *PagedMemory) ReadMemory(address Word) Word {
+	frame := m.frames[m.pageTable[address>>pageShift]]
+	if frame == nil {
+		return 0
+	}
+	return frame.words[address&(PageSize-1)]
+}
+
+func (m *PagedMemory) WriteMemory(address Word, value Word) {
+	m.writableFrame(m.pageTable[address>>pageShift]).words[address&(PageSize-1)] = value
+}
+
+// ReadBacking reads the word at the address in the backing store, where frame
+// n starts at address n*PageSize. The address must be less than
+// NumFrames()*PageSize.
+func (m *PagedMemory) ReadBacking(address DWord) Word {
+	frame := m.frames[address>>pageShift]
+	if frame == nil {
+		return 0
+	}
+	return frame.words[address&(PageSize-1)]
+}
+
+// WriteBacking writes the word at the address in the backing store.
+func (m *PagedMemory) WriteBacking(address DWord, value Word) {
+	m.writableFrame(Word(address >> pageShift)).words[address&(PageSize-1)] = value
+}
+
+// writableFrame returns the frame, allocating it if nil, or replacing it with a
+// copy if it is shared with a fork.
+func (m *PagedMemory) writableFrame(index Word) *pageFrame {
+	frame := m.frames[index]
+	if frame != nil && atomic.LoadInt32(&frame.refs) == 1 {
+		return frame
+	}
+	copied := &pageFrame{refs: 1}
+	if frame != nil {
+		copied.words = frame.words
+		frame.release()
+	}
+	m.frames[index] = copied
+	return copied
+}
+
+// Fork returns a copy of the memory, including its page table. The memories
+// share frames until either writes to them. Fork only reads the memory, which
+// must not be written by another goroutine during Fork, and the memories may
+// then be used concurrently.
+func (m *PagedMemory) Fork() *PagedMemory {
+	child := &PagedMemory{
+		frames:    make([]*pageFrame, len(m.frames)),
+		pageTable: m.pageTable,
+	}
+	for i, frame := range m.frames {
+		child.frames[i] = frame.share()
+	}
+	return child
+}
+
+func (m *PagedMemory) ForkMemory() Memory {
+	return m.Fork()
+}
+
+// MMU interrupt operations, selected by register A.
+const (
+	// MMUMapPage maps page B to frame C.
+	MMUMapPage Word = iota
+	// MMUQueryPage sets C to the frame that page B is mapped to.
+	MMUQueryPage
+	// MMUNumFrames sets C to the number of frames.
+	MMUNumFrames
+)
+
+// MMUDevice is a hardware device through which programs map the pages of a
+// PagedMemory. The operation is selected by register A when interrupted (see
+// MMUMapPage etc.).
+type MMUDevice struct {
+	Memory *PagedMemory
+}
+
+type InvalidMMUOperationError Word
+
+func (err InvalidMMUOperationError) Error() string {
+	return fmt.Sprintf("invalid MMU operation 0x%04x", Word(err))
+}
+
+func (d *MMUDevice) ID() DWord {
+	return 0x4d4d5531
+}
+
+func (d *MMUDevice) Version() Word {
+	return 0x0001
+}
+
+func (d *MMUDevice) Manufacturer() DWord {
+	return 0x44313647
+}
+
+func (d *MMUDevice) Interrupt(state MachineState) (int, error) {
+	page := state.Register(RegB)
+	switch op := state.Register(RegA); op {
+	case MMUMapPage:
+		return 0, d.Memory.MapPage(page, state.Register(RegC))
+	case MMUQueryPage:
+		frame, err := d.Memory.PageFrame(page)
+		if err != nil {
+			return 0, err
+		}
+		state.WriteRegister(RegC, frame)
+		return 0, nil
+	case MMUNumFrames:
+		state.WriteRegister(RegC, Word(d.Memory.NumFrames()))
+		return 0, nil
+	default:
+		return 0, InvalidMMUOperationError(op)
+	}
+}
+
+// ForkDevice returns an MMUDevice for the memory of the forked machine.
+func (d *MMUDevice) ForkDevice(child *D16MachineState) Device {
+	if memory, ok := child.Memory.(*PagedMemory); ok {
+		return &MMUDevice{memory}
+	}
+	return d
+}
+
+// NewPagedMachineState creates an initialized machine with a PagedMemory of
+// numFrames frames, and an MMUDevice to map it.
+func NewPagedMachineState(numFrames int) (*D16MachineState, *PagedMemory, error) {
+	memory, err := NewPagedMemory(numFrames)
+	if err != nil {
+		return nil, nil, err
+	}
+	state := &D16MachineState{Memory: memory}
+	state.Init()
+	state.AttachDevice(&MMUDevice{memory})
+	return state, memory, nil
+}
@@ -0,0 +1,90 @@
+package core
+
+import (
+	"testing"
+)
+
+var pagedMemoryImplTest Memory = &PagedMemory{}
+var mmuDeviceImplTest Device = &MMUDevice{}
+
+func TestPagedMemory(t *testing.T) {
+	if _, err := NewPagedMemory(NumPages - 1); err != InvalidFrameCountError {
+		t.Errorf("expected InvalidFrameCountError, got %v", err)
+	}
+
+	m, err := NewPagedMemory(256)
+	if err != nil {
+		t.Fatalf("NewPagedMemory returned error %v", err)
+	}
+	m.WriteMemory(0x1005, 0x1234)
+	if r := m.ReadBacking(0x1005); r != 0x1234 {
+		t.Errorf("read 0x%04x from backing, expected 0x1234", r)
+	}
+
+	if err := m.MapPage(0x1, 0x80); err != nil {
+		t.Fatalf("MapPage returned error %v", err)
+	}
+	if r := m.ReadMemory(0x1005); r != 0x0000 {
+		t.Errorf("read 0x%04x from newly mapped page, expected 0x0000", r)
+	}
+	m.WriteMemory(0x1005, 0x5678)
+	if r := m.ReadBacking(0x80*PageSize + 0x5); r != 0x5678 {
+		t.Errorf("read 0x%04x from backing, expected 0x5678", r)
+	}
+	if r := m.ReadBacking(0x1005); r != 0x1234 {
+		t.Errorf("unmapped frame changed to 0x%04x", r)
+	}
+	if frame, _ := m.PageFrame(0x1); frame != 0x80 {
+		t.Errorf("page mapped to frame 0x%04x, expected 0x0080", frame)
+	}
+
+	if err := m.MapPage(NumPages, 0); err != InvalidPageError(NumPages) {
+		t.Errorf("expected InvalidPageError, got %v", err)
+	}
+	if err := m.MapPage(0, 256); err != InvalidFrameError(256) {
+		t.Errorf("expected InvalidFrameError, got %v", err)
+	}
+}
+
+func TestPagedMachineState(t *testing.T) {
+	state, memory, err := NewPagedMachineState(256)
+	if err != nil {
+		t.Fatalf("NewPagedMachineState returned error %v", err)
+	}
+	program := []Word{
+		0x8401,         // SET A, 0
+		0x8821,         // SET B, 1
+		0x7c41, 0x0020, // SET C, 0x0020
+		0x8640,                 // HWI 0
+		0x7fc1, 0xbeef, 0x1000, // SET [0x1000], 0xbeef
+		0x8801, // SET A, 1
+		0x8441, // SET C, 0
+		0x8640, // HWI 0
+	}
+	for i, w := range program {
+		state.WriteMemory(Word(i), w)
+	}
+
+	for i := 0; i < 8; i++ {
+		if _, err := Step(state); err != nil {
+			t.Fatalf("unexpected emulation error: %v", err)
+		}
+	}
+	if r := memory.ReadBacking(0x20*PageSize + 0x0000); r != 0xbeef {
+		t.Errorf("read 0x%04x from frame 0x20, expected 0xbeef", r)
+	}
+	if r := memory.ReadBacking(0x1000); r != 0x0000 {
+		t.Errorf("read 0x%04x from frame 0x01, expected 0x0000", r)
+	}
+	if c := state.Register(RegC); c != 0x0020 {
+		t.Errorf("MMU query returned frame 0x%04x, expected 0x0020", c)
+	}
+
+	// Invalid operations are device faults.
+	state.WriteRegister(RegA, 0x0003)
+	state.WritePC(0x0004)
+	_, err = Step(state)
+	if fault, ok := err.(*Fault); !ok || fault.Class != DeviceFault || fault.Err != InvalidMMUOperationError(3) {
+		t.Errorf("expected device fault, got %v", err)
+	}
+}
@@ -0,0 +1,376 @@
+package core
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// maxProfileDepth is the maximum depth of call stacks in a profile. Deeper
+// calls are attributed to the deepest recorded caller.
+const maxProfileDepth = 256
+
+// nanosPerCycle is the duration of a cycle of the DCPU-16 at 100kHz.
+const nanosPerCycle = 10000
+
+type profileCallKind uint8
+
+const (
+	profileNoCall          profileCallKind = iota
+	profileCall                            // JSR
+	profileReturn                          // SET PC, POP
+	profileReturnInterrupt                 // RFI
+)
+
+// profileFrame is a call in the current call stack.
+type profileFrame struct {
+	node          int // The profileNode of the stack up to this call.
+	returnAddress Word
+	interrupt     bool
+}
+
+// profileNode is a node in the tree of call stacks, identifying a stack.
+type profileNode struct {
+	parent   int
+	callSite Word
+}
+
+type profileEdge struct {
+	parent   int
+	callSite Word
+}
+
+type profileKey struct {
+	node int
+	pc   Word
+}
+
+type profileCount struct {
+	instructions int64
+	cycles       int64
+}
+
+// Profiler counts the instructions and cycles executed at each address by the
+// Step of a D16MachineState that it is set as the Profile of, with the call
+// stack built from JSR and SET PC, POP, and from interrupts and RFI. The
+// counts are written as a pprof profile.
+type Profiler struct {
+	// Labels name the functions of the program in the profile. Addresses
+	// without a label at or before them are named by their address.
+	Labels LabelMap
+
+	counts   map[profileKey]*profileCount
+	nodes    []profileNode
+	children map[profileEdge]int
+	frames   []profileFrame
+	dropped  int // Calls beyond maxProfileDepth.
+
+	stepping bool
+	pc       Word
+	call     profileCallKind
+	size     Word
+}
+
+// NewProfiler creates an empty Profiler.
+func NewProfiler() *Profiler {
+	return &Profiler{
+		counts:   make(map[profileKey]*profileCount),
+		nodes:    []profileNode{{}},
+		children: make(map[profileEdge]int),
+	}
+}
+
+// instructionWordLoader loads an instruction word followed by zeros.
+type instructionWordLoader struct {
+	word   Word
+	loaded bool
+}
+
+func (l *instructionWordLoader) WordLoad() (Word, error) {
+	if l.loaded {
+		return 0, nil
+	}
+	l.loaded = true
+	return l.word, nil
+}
+
+func (l *instructionWordLoader) SkipWords(Word) error {
+	return nil
+}
+
+func (p *Profiler) begin(state *D16MachineState) {
+	if p.stepping {
+		// Called again after delivering an interrupt.
+		p.push(p.pc, p.pc, true)
+	}
+	p.stepping = true
+	p.pc = state.PC()
+	p.call = profileNoCall
+	decoded, err := state.Decode(&instructionWordLoader{word: state.PeekMemory(p.pc)})
+	if err != nil {
+		return
+	}
+	p.size = decoded.Size()
+	switch {
+	case decoded.Info.Name == "JSR":
+		p.call = profileCall
+	case decoded.Info.Name == "RFI":
+		p.call = profileReturnInterrupt
+	case decoded.Info.Name == "SET" && decoded.B.Kind == PCOperand && decoded.A.Kind == PopOperand:
+		p.call = profileReturn
+	}
+}
+
+func (p *Profiler) end(state *D16MachineState, ticks int) {
+	if !p.stepping {
+		return
+	}
+	p.stepping = false
+	key := profileKey{0, p.pc}
+	if len(p.frames) > 0 {
+		key.node = p.frames[len(p.frames)-1].node
+	}
+	count := p.counts[key]
+	if count == nil {
+		count = &profileCount{}
+		p.counts[key] = count
+	}
+	count.instructions++
+	count.cycles += int64(ticks)
+
+	pc := state.PC()
+	switch p.call {
+	case profileCall:
+		if pc != p.pc {
+			p.push(p.pc, p.pc+p.size, false)
+		}
+	case profileReturn, profileReturnInterrupt:
+		if p.dropped > 0 {
+			p.dropped--
+			return
+		}
+		interrupt := p.call == profileReturnInterrupt
+		for i := len(p.frames) - 1; i >= 0; i-- {
+			if p.frames[i].returnAddress == pc && p.frames[i].interrupt == interrupt {
+				p.frames = p.frames[:i]
+				return
+			}
+		}
+	}
+}
+
+// push adds a call from callSite to the call stack.
+func (p *Profiler) push(callSite, returnAddress Word, interrupt bool) {
+	if len(p.frames) >= maxProfileDepth {
+		p.dropped++
+		return
+	}
+	edge := profileEdge{0, callSite}
+	if len(p.frames) > 0 {
+		edge.parent = p.frames[len(p.frames)-1].node
+	}
+	node, ok := p.children[edge]
+	if !ok {
+		node = len(p.nodes)
+		p.nodes = append(p.nodes, profileNode{edge.parent, callSite})
+		p.children[edge] = node
+	}
+	p.frames = append(p.frames, profileFrame{node, returnAddress, interrupt})
+}
+
+// WriteProfile writes the counts as a gzipped profile.proto, as read by
+// go tool pprof. The sample types are instructions and cycles, defaulting to
+// cycles.
+func (p *Profiler) WriteProfile(w io.Writer) error {
+	var table profileStrings
+	table.index("")
+	var labels *labelIndex
+	if p.Labels != nil {
+		labels = newLabelIndex(p.Labels)
+	}
+	functions := make(map[string]uint64)
+	var functionBuf protoBuffer
+	function := func(pc Word) uint64 {
+		name := fmt.Sprintf("0x%04x", pc)
+		if labels != nil {
+			if _, label, ok := labels.lookup(pc); ok {
+				name = label
+			}
+		}
+		id, ok := functions[name]
+		if !ok {
+			id = uint64(len(functions) + 1)
+			functions[name] = id
+			functionBuf.message(5, func(m *protoBuffer) {
+				m.uint64Field(1, id)
+				m.int64Field(2, table.index(name))
+				m.int64Field(3, table.index(name))
+			})
+		}
+		return id
+	}
+	locations := make(map[Word]uint64)
+	var locationBuf protoBuffer
+	location := func(pc Word) uint64 {
+		id, ok := locations[pc]
+		if !ok {
+			id = uint64(len(locations) + 1)
+			locations[pc] = id
+			functionId := function(pc)
+			locationBuf.message(4, func(m *protoBuffer) {
+				m.uint64Field(1, id)
+				m.uint64Field(2, 1)
+				m.uint64Field(3, uint64(pc))
+				m.message(4, func(line *protoBuffer) {
+					line.uint64Field(1, functionId)
+				})
+			})
+		}
+		return id
+	}
+
+	keys := make([]profileKey, 0, len(p.counts))
+	var totalCycles int64
+	for key, count := range p.counts {
+		keys = append(keys, key)
+		totalCycles += count.cycles
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].node != keys[j].node {
+			return keys[i].node < keys[j].node
+		}
+		return keys[i].pc < keys[j].pc
+	})
+
+	var b protoBuffer
+	for _, sampleType := range []string{"instructions", "cycles"} {
+		b.message(1, func(m *protoBuffer) {
+			m.int64Field(1, table.index(sampleType))
+			m.int64Field(2, table.index("count"))
+		})
+	}
+	for _, key := range keys {
+		ids := []uint64{location(key.pc)}
+		for node := key.node; node != 0; node = p.nodes[node].parent {
+			ids = append(ids, location(p.nodes[node].callSite))
+		}
+		count := p.counts[key]
+		b.message(2, func(m *protoBuffer) {
+			m.packedUint64s(1, ids)
+			m.packedInt64s(2, []int64{count.instructions, count.cycles})
+		})
+	}
+	b.message(3, func(m *protoBuffer) {
+		m.uint64Field(1, 1)
+		m.uint64Field(3, MemorySize)
+		m.int64Field(5, table.index("dcpu16"))
+		m.boolField(7, true)
+	})
+	b.data = append(b.data, locationBuf.data...)
+	b.data = append(b.data, functionBuf.data...)
+	cycles := table.index("cycles")
+	count := table.index("count")
+	for _, s := range table.strings {
+		b.stringField(6, s)
+	}
+	b.int64Field(10, totalCycles*nanosPerCycle)
+	b.message(11, func(m *protoBuffer) {
+		m.int64Field(1, cycles)
+		m.int64Field(2, count)
+	})
+	b.int64Field(12, 1)
+	b.int64Field(14, cycles)
+
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(b.data); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// profileStrings is the string table of a profile.
+type profileStrings struct {
+	strings []string
+	indices map[string]int64
+}
+
+func (s *profileStrings) index(str string) int64 {
+	if s.indices == nil {
+		s.indices = make(map[string]int64)
+	}
+	i, ok := s.indices[str]
+	if !ok {
+		i = int64(len(s.strings))
+		s.strings = append(s.strings, str)
+		s.indices[str] = i
+	}
+	return i
+}
+
+// protoBuffer encodes a protocol buffer message.
+type protoBuffer struct {
+	data []byte
+}
+
+func (b *protoBuffer) varint(v uint64) {
+	for v >= 0x80 {
+		b.data = append(b.data, byte(v)|0x80)
+		v >>= 7
+	}
+	b.data = append(b.data, byte(v))
+}
+
+func (b *protoBuffer) key(field int, wireType uint64) {
+	b.varint(uint64(field)<<3 | wireType)
+}
+
+func (b *protoBuffer) uint64Field(field int, v uint64) {
+	if v != 0 {
+		b.key(field, 0)
+		b.varint(v)
+	}
+}
+
+func (b *protoBuffer) int64Field(field int, v int64) {
+	b.uint64Field(field, uint64(v))
+}
+
+func (b *protoBuffer) boolField(field int, v bool) {
+	if v {
+		b.uint64Field(field, 1)
+	}
+}
+
+func (b *protoBuffer) bytesField(field int, data []byte) {
+	b.key(field, 2)
+	b.varint(uint64(len(data)))
+	b.data = append(b.data, data...)
+}
+
+// stringField writes a string, even if empty, as required by string tables.
+func (b *protoBuffer) stringField(field int, s string) {
+	b.bytesField(field, []byte(s))
+}
+
+func (b *protoBuffer) packedUint64s(field int, vs []uint64) {
+	var packed protoBuffer
+	for _, v := range vs {
+		packed.varint(v)
+	}
+	b.bytesField(field, packed.data)
+}
+
+func (b *protoBuffer) packedInt64s(field int, vs []int64) {
+	var packed protoBuffer
+	for _, v := range vs {
+		packed.varint(uint64(v))
+	}
+	b.bytesField(field, packed.data)
+}
+
+func (b *protoBuffer) message(field int, fn func(m *protoBuffer)) {
+	var m protoBuffer
+	fn(&m)
+	b.bytesField(field, m.data)
+}
@@ -0,0 +1,157 @@
+package core
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// protoField is a field decoded from a protocol buffer message.
+type protoField struct {
+	field int
+	value uint64 // Varint fields.
+	data  []byte // Length delimited fields.
+}
+
+func decodeProto(t *testing.T, data []byte) []protoField {
+	var fields []protoField
+	varint := func() uint64 {
+		var v uint64
+		for shift := uint(0); ; shift += 7 {
+			if len(data) == 0 {
+				t.Fatalf("truncated varint")
+			}
+			b := data[0]
+			data = data[1:]
+			v |= uint64(b&0x7f) << shift
+			if b < 0x80 {
+				return v
+			}
+		}
+	}
+	for len(data) > 0 {
+		key := varint()
+		f := protoField{field: int(key >> 3)}
+		switch key & 7 {
+		case 0:
+			f.value = varint()
+		case 2:
+			n := varint()
+			f.data, data = data[:n], data[n:]
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+func decodePacked(data []byte) []uint64 {
+	var values []uint64
+	for len(data) > 0 {
+		var v uint64
+		for shift := uint(0); ; shift += 7 {
+			b := data[0]
+			data = data[1:]
+			v |= uint64(b&0x7f) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		values = append(values, v)
+	}
+	return values
+}
+
+func TestProfile(t *testing.T) {
+	state := &D16MachineState{Profile: NewProfiler()}
+	state.Init()
+	copy(state.D16MemoryState.Data[0x00:], []Word{
+		0x7c20, 0x0010, // main: JSR outer
+		0x8781, // SET PC, 0
+	})
+	copy(state.D16MemoryState.Data[0x10:], []Word{
+		0x7c20, 0x0020, // outer: JSR inner
+		0x6381, // SET PC, POP
+	})
+	copy(state.D16MemoryState.Data[0x20:], []Word{
+		0x8802, // inner: ADD A, 1
+		0x6381, // SET PC, POP
+	})
+	for i := 0; i < 12; i++ {
+		if _, err := Step(state); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	state.Profile.Labels = LabelMap{0x00: "main", 0x10: "outer", 0x20: "inner"}
+
+	var buf bytes.Buffer
+	if err := state.Profile.WriteProfile(&buf); err != nil {
+		t.Fatalf("WriteProfile returned error %v", err)
+	}
+	gz, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("profile is not gzipped: %v", err)
+	}
+	data, err := ioutil.ReadAll(gz)
+	if err != nil {
+		t.Fatalf("reading profile: %v", err)
+	}
+
+	var strs []string
+	var samples []protoField
+	locationFunctions := make(map[uint64]uint64)
+	functionNames := make(map[uint64]uint64)
+	for _, f := range decodeProto(t, data) {
+		switch f.field {
+		case 2:
+			samples = append(samples, f)
+		case 4:
+			var id, function uint64
+			for _, lf := range decodeProto(t, f.data) {
+				switch lf.field {
+				case 1:
+					id = lf.value
+				case 4:
+					function = decodeProto(t, lf.data)[0].value
+				}
+			}
+			locationFunctions[id] = function
+		case 5:
+			fields := decodeProto(t, f.data)
+			functionNames[fields[0].value] = fields[1].value
+		case 6:
+			strs = append(strs, string(f.data))
+		}
+	}
+
+	// Totals of instructions and cycles by stack, leaf first.
+	totals := make(map[string][2]uint64)
+	for _, sample := range samples {
+		fields := decodeProto(t, sample.data)
+		var names []string
+		for _, id := range decodePacked(fields[0].data) {
+			names = append(names, strs[functionNames[locationFunctions[id]]])
+		}
+		values := decodePacked(fields[1].data)
+		stack := strings.Join(names, ";")
+		total := totals[stack]
+		totals[stack] = [2]uint64{total[0] + values[0], total[1] + values[1]}
+	}
+
+	expected := map[string][2]uint64{
+		"main":             {4, 10},
+		"outer;main":       {4, 10},
+		"inner;outer;main": {4, 6},
+	}
+	if len(totals) != len(expected) {
+		t.Errorf("got stacks %v, expected %v", totals, expected)
+	}
+	for stack, exp := range expected {
+		if totals[stack] != exp {
+			t.Errorf("stack %s: got %v, expected %v", stack, totals[stack], exp)
+		}
+	}
+}
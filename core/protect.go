@@ -0,0 +1,137 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Protection restricts the kinds of access allowed to a region of memory.
+type Protection uint8
+
+const (
+	// ReadOnly denies writes.
+	ReadOnly Protection = iota + 1
+	// NoExecute denies fetching instruction words.
+	NoExecute
+	// NoAccess denies all access.
+	NoAccess
+)
+
+func (p Protection) String() string {
+	switch p {
+	case ReadOnly:
+		return "read-only"
+	case NoExecute:
+		return "no-execute"
+	case NoAccess:
+		return "no-access"
+	}
+	return fmt.Sprintf("Protection(%d)", p)
+}
+
+// denies returns true if the protection denies the access.
+func (p Protection) denies(access WatchAccess) bool {
+	switch p {
+	case ReadOnly:
+		return access == WatchWrite
+	case NoExecute:
+		return access == WatchExecute
+	case NoAccess:
+		return true
+	}
+	return false
+}
+
+// fetchDeniedError is returned by WordLoad when memory protection denies
+// fetching an instruction word, after the fault has been handled.
+var fetchDeniedError = errors.New("instruction fetch denied by memory protection")
+
+// ProtectionError is the error of a Fault for an access denied by memory
+// protection.
+type ProtectionError struct {
+	Access     WatchAccess
+	Address    Word
+	Protection Protection
+}
+
+func (err *ProtectionError) Error() string {
+	access := "read"
+	switch err.Access {
+	case WatchWrite:
+		access = "write"
+	case WatchExecute:
+		access = "execute"
+	}
+	return fmt.Sprintf("%s access to %v memory at 0x%04x", access, err.Protection, err.Address)
+}
+
+func (err *ProtectionError) faultClass() FaultClass {
+	return ProtectionFault
+}
+
+type protectedRegion struct {
+	start      Word
+	size       int
+	protection Protection
+}
+
+// D16Protection holds the memory protection of a D16MachineState. Accesses
+// denied by protection do not happen: writes are discarded, reads return
+// zero, and instructions whose fetch is denied are skipped. Each denied access
+// is a ProtectionFault, handled according to the machine's fault handler. If
+// the handler halts, the rest of the instruction does not write memory or
+// trigger interrupts, and the CPU is left as it was before the instruction.
+type D16Protection struct {
+	regions []protectedRegion
+	pages   addressPages
+}
+
+// Protect applies the protection to size words from start. Regions may
+// overlap, in which case an access is denied if any region denies it.
+func (p *D16Protection) Protect(start Word, size int, protection Protection) error {
+	if !validRegion(start, size) {
+		return InvalidMemoryRegionError
+	}
+	p.regions = append(p.regions, protectedRegion{start, size, protection})
+	p.pages.add(start, size)
+	return nil
+}
+
+// Unprotect removes the protection of the region from start, and returns
+// false if there is none.
+func (p *D16Protection) Unprotect(start Word) bool {
+	for i := range p.regions {
+		if p.regions[i].start == start {
+			p.regions = append(p.regions[:i:i], p.regions[i+1:]...)
+			p.pages = addressPages{}
+			for _, region := range p.regions {
+				p.pages.add(region.start, region.size)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// protected returns true if an access to the address might be denied.
+func (p *D16Protection) protected(address Word) bool {
+	return p.pages.contains(address)
+}
+
+// check returns the protection that denies the access, and false if the
+// access is allowed.
+func (p *D16Protection) check(access WatchAccess, address Word) (Protection, bool) {
+	for _, region := range p.regions {
+		if address >= region.start && int(address-region.start) < region.size && region.protection.denies(access) {
+			return region.protection, true
+		}
+	}
+	return 0, false
+}
+
+// fork returns a copy of the protection.
+func (p *D16Protection) fork() D16Protection {
+	c := *p
+	c.regions = p.regions[:len(p.regions):len(p.regions)]
+	return c
+}
@@ -0,0 +1,174 @@
+package core
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestProtection(t *testing.T) {
+	type Test struct {
+		Name       string
+		InitMem    []Word
+		Start      Word
+		Size       int
+		Protection Protection
+		NumSteps   int
+		ExpPC      Word // PC of the faulting instruction.
+		ExpErr     ProtectionError
+		// Expected state if the fault is ignored.
+		ExpCPU D16CPU
+		ExpMem ExpMem // Not checked if Expected is nil.
+	}
+
+	tests := []Test{
+		{
+			Name:       "write to read-only memory",
+			InitMem:    []Word{0x8bc1, 0x0000}, // SET [0x0000], 1
+			Start:      0x0000,
+			Size:       2,
+			Protection: ReadOnly,
+			NumSteps:   1,
+			ExpErr:     ProtectionError{WatchWrite, 0x0000, ReadOnly},
+			ExpCPU:     D16CPU{pc: 0x0002, sp: 0xffff},
+			ExpMem:     ExpMem{0x0000, []Word{0x8bc1}},
+		},
+		{
+			Name: "read from no-access memory",
+			InitMem: []Word{
+				0x7801, 0x0010, // SET A, [0x0010]
+				0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000,
+				0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000,
+				0x0000, 0x0000, 0x1234,
+			},
+			Start:      0x0010,
+			Size:       1,
+			Protection: NoAccess,
+			NumSteps:   1,
+			ExpErr:     ProtectionError{WatchRead, 0x0010, NoAccess},
+			ExpCPU:     D16CPU{pc: 0x0002, sp: 0xffff},
+			ExpMem:     ExpMem{0x0010, []Word{0x1234}},
+		},
+		{
+			Name: "execute no-execute memory",
+			InitMem: []Word{
+				0xc781, // SET PC, 0x0010
+				0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000,
+				0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000,
+				0x7c01, 0x1234, // SET A, 0x1234
+			},
+			Start:      0x0010,
+			Size:       0x10,
+			Protection: NoExecute,
+			NumSteps:   2,
+			ExpPC:      0x0010,
+			ExpErr:     ProtectionError{WatchExecute, 0x0010, NoExecute},
+			// The instruction is skipped.
+			ExpCPU: D16CPU{pc: 0x0012, sp: 0xffff},
+		},
+		{
+			Name: "write to read-only memory before setting EX",
+			InitMem: []Word{
+				0x7fc2, 0xffff, 0x0010, // ADD [0x0010], 0xffff
+				0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000,
+				0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000,
+				0x0001,
+			},
+			Start:      0x0010,
+			Size:       1,
+			Protection: ReadOnly,
+			NumSteps:   1,
+			ExpErr:     ProtectionError{WatchWrite, 0x0010, ReadOnly},
+			ExpCPU:     D16CPU{pc: 0x0003, sp: 0xffff, ex: 0x0001},
+			ExpMem:     ExpMem{0x0010, []Word{0x0001}},
+		},
+		{
+			Name: "read from no-access memory before writing",
+			InitMem: []Word{
+				0x7bc1, 0x0011, 0x0010, // SET [0x0010], [0x0011]
+				0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000,
+				0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000,
+				0x5555, 0x1234,
+			},
+			Start:      0x0011,
+			Size:       1,
+			Protection: NoAccess,
+			NumSteps:   1,
+			ExpErr:     ProtectionError{WatchRead, 0x0011, NoAccess},
+			ExpCPU:     D16CPU{pc: 0x0003, sp: 0xffff},
+			ExpMem:     ExpMem{0x0010, []Word{0x0000, 0x1234}},
+		},
+	}
+
+	for _, test := range tests {
+		var state D16MachineState
+		state.Init()
+		copy(state.D16MemoryState.Data[0:], test.InitMem)
+		if err := state.Protect(test.Start, test.Size, test.Protection); err != nil {
+			t.Fatalf("%s: Protect returned error %v", test.Name, err)
+		}
+		var err error
+		for i := 0; i < test.NumSteps && err == nil; i++ {
+			_, err = Step(&state)
+		}
+		fault, ok := err.(*Fault)
+		if !ok {
+			t.Errorf("%s: expected *Fault, got %#v", test.Name, err)
+			continue
+		}
+		if fault.Class != ProtectionFault || fault.PC != test.ExpPC {
+			t.Errorf("%s: got %v fault at 0x%04x", test.Name, fault.Class, fault.PC)
+		}
+		var protErr *ProtectionError
+		if !errors.As(err, &protErr) || *protErr != test.ExpErr {
+			t.Errorf("%s: expected %v, got %v", test.Name, &test.ExpErr, fault.Err)
+		}
+		// Halting stops at the faulting instruction, before its side effects.
+		if pc := state.PC(); pc != fault.PC {
+			t.Errorf("%s: halted with PC 0x%04x, expected 0x%04x", test.Name, pc, fault.PC)
+		}
+		expCPU := D16CPU{pc: fault.PC, sp: 0xffff}
+		if !CPUEquals(&expCPU, &state.D16CPU) {
+			t.Errorf("%s: halted with CPU state %#v, expected %#v", test.Name, state.D16CPU, expCPU)
+		}
+		initMem := ExpMem{0x0000, test.InitMem}
+		initMem.StateCheck(t, test.Name+" halted", &state)
+	}
+
+	// Ignoring the faults.
+	for _, test := range tests {
+		var state D16MachineState
+		state.Init()
+		state.FaultPolicy.Actions[ProtectionFault] = IgnoreFault
+		copy(state.D16MemoryState.Data[0:], test.InitMem)
+		state.Protect(test.Start, test.Size, test.Protection)
+		for i := 0; i < test.NumSteps; i++ {
+			if _, err := Step(&state); err != nil {
+				t.Fatalf("%s: unexpected error when ignored: %v", test.Name, err)
+			}
+		}
+		if !CPUEquals(&test.ExpCPU, &state.D16CPU) {
+			t.Errorf("%s: expected CPU state %#v, got %#v", test.Name, test.ExpCPU, state.D16CPU)
+		}
+		if test.ExpMem.Expected != nil {
+			test.ExpMem.StateCheck(t, test.Name, &state)
+		}
+	}
+}
+
+func TestUnprotect(t *testing.T) {
+	var state D16MachineState
+	state.Init()
+	state.Protect(0x1000, 0x100, ReadOnly)
+	state.Protect(0x1080, 0x100, NoAccess)
+	if !state.Unprotect(0x1000) {
+		t.Fatalf("Unprotect failed")
+	}
+	state.WriteMemory(0x1000, 1)
+	state.WriteMemory(0x1080, 2)
+	if err := state.EndInstruction(0); err == nil {
+		t.Errorf("expected fault for access to remaining protected region")
+	}
+	if state.PeekMemory(0x1000) != 1 || state.PeekMemory(0x1080) != 0 {
+		t.Errorf("unexpected memory state after unprotecting")
+	}
+}
@@ -0,0 +1,372 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+)
+
+// ReplayFinishedError is returned when stepping a Replayer past the end of
+// its log.
+var ReplayFinishedError = errors.New("replay finished")
+
+// InvalidReplayLogError is returned when replaying a log without an initial
+// state or hashes.
+var InvalidReplayLogError = errors.New("invalid replay log")
+
+// NotInputDeviceError is returned when giving input to a device that is not an
+// InputDevice.
+type NotInputDeviceError Word
+
+func (err NotInputDeviceError) Error() string {
+	return fmt.Sprintf("hardware device at index 0x%04x does not take input", Word(err))
+}
+
+// InputDevice is a Device that receives input from outside the machine, such
+// as key presses or clock ticks.
+type InputDevice interface {
+	Device
+	Input(state MachineState, data []byte) error
+}
+
+// ReplayEventKind is the kind of a ReplayEvent.
+type ReplayEventKind uint8
+
+const (
+	// InterruptEvent triggers an interrupt with Message.
+	InterruptEvent ReplayEventKind = iota
+	// MemoryEvent writes Value to memory at Address.
+	MemoryEvent
+	// InputEvent gives Data to the InputDevice at index Device.
+	InputEvent
+)
+
+func (k ReplayEventKind) String() string {
+	switch k {
+	case InterruptEvent:
+		return "interrupt"
+	case MemoryEvent:
+		return "memory"
+	case InputEvent:
+		return "input"
+	}
+	return fmt.Sprintf("ReplayEventKind(%d)", k)
+}
+
+// ReplayEvent is an event from outside the machine, delivered between steps.
+type ReplayEvent struct {
+	// Step and Cycle are the number of steps and cycles executed since
+	// recording started when the event was delivered.
+	Step    uint64
+	Cycle   uint64
+	Kind    ReplayEventKind
+	Message Word   `json:",omitempty"`
+	Address Word   `json:",omitempty"`
+	Value   Word   `json:",omitempty"`
+	Device  Word   `json:",omitempty"`
+	Data    []byte `json:",omitempty"`
+}
+
+// StateHash is the hash of the state of a machine after a number of steps,
+// and the events delivered after them.
+type StateHash struct {
+	Step  uint64
+	Cycle uint64
+	Hash  uint64
+}
+
+// ReplayLog is the record of a run of a machine, from which it can be
+// replayed.
+type ReplayLog struct {
+	// Initial is the state of the machine when recording started.
+	Initial *Snapshot
+	Events  []ReplayEvent
+	// Hashes are taken periodically, and at the end of the recording.
+	Hashes []StateHash
+	// Faults are the steps at which Step returned a *Fault.
+	Faults []uint64 `json:",omitempty"`
+}
+
+// Write writes the log as JSON.
+func (log *ReplayLog) Write(w io.Writer) error {
+	return json.NewEncoder(w).Encode(log)
+}
+
+// ReadReplayLog reads a log written by ReplayLog.Write.
+func ReadReplayLog(r io.Reader) (*ReplayLog, error) {
+	var log ReplayLog
+	if err := json.NewDecoder(r).Decode(&log); err != nil {
+		return nil, err
+	}
+	if log.Initial == nil || len(log.Hashes) == 0 {
+		return nil, InvalidReplayLogError
+	}
+	return &log, nil
+}
+
+// HashState returns a hash of the state of the machine that is saved in a
+// snapshot.
+func HashState(state *D16MachineState) (uint64, error) {
+	h := fnv.New64a()
+	if err := state.Save(h); err != nil {
+		return 0, err
+	}
+	return h.Sum64(), nil
+}
+
+// Recorder records a run of a machine, and the events delivered to it, so
+// that it can be replayed. The machine must be stepped, and events delivered,
+// only through the Recorder. For the run to be reproducible, devices must
+// only change the machine when interrupted by it, or through InputEvent, and
+// a FaultPolicy that catches fire must have a seeded Rand.
+type Recorder struct {
+	State *D16MachineState
+	// HashInterval is the number of steps between hashes of the state. If 0,
+	// the state is only hashed at the end of the recording.
+	HashInterval uint64
+
+	log   ReplayLog
+	steps uint64
+	start uint64
+}
+
+// NewRecorder starts recording the machine.
+func NewRecorder(state *D16MachineState, hashInterval uint64) (*Recorder, error) {
+	initial, err := state.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{
+		State:        state,
+		HashInterval: hashInterval,
+		log:          ReplayLog{Initial: initial},
+		start:        state.Cycles(),
+	}, nil
+}
+
+func (r *Recorder) cycle() uint64 {
+	return r.State.Cycles() - r.start
+}
+
+// hash records the hash of the state, unless already recorded at this step.
+// A hash recorded by Log is dropped by event if the step then has more events.
+func (r *Recorder) hash() error {
+	if n := len(r.log.Hashes); n > 0 && r.log.Hashes[n-1].Step == r.steps {
+		return nil
+	}
+	hash, err := HashState(r.State)
+	if err != nil {
+		return err
+	}
+	r.log.Hashes = append(r.log.Hashes, StateHash{r.steps, r.cycle(), hash})
+	return nil
+}
+
+// Step steps the machine.
+func (r *Recorder) Step() (int, error) {
+	if r.HashInterval > 0 && r.steps > 0 && r.steps%r.HashInterval == 0 {
+		if err := r.hash(); err != nil {
+			return 0, err
+		}
+	}
+	ticks, err := Step(r.State)
+	if _, ok := err.(*Fault); ok {
+		r.log.Faults = append(r.log.Faults, r.steps)
+	}
+	r.steps++
+	return ticks, err
+}
+
+func (r *Recorder) event(event ReplayEvent) {
+	event.Step, event.Cycle = r.steps, r.cycle()
+	r.log.Events = append(r.log.Events, event)
+	if n := len(r.log.Hashes); n > 0 && r.log.Hashes[n-1].Step == r.steps {
+		// The hash was taken by Log before this event, but is replayed after
+		// all of the events of the step, so must be taken again.
+		r.log.Hashes = r.log.Hashes[:n-1]
+	}
+}
+
+// TriggerInterrupt triggers an interrupt on the machine, and records it.
+func (r *Recorder) TriggerInterrupt(message Word) error {
+	r.event(ReplayEvent{Kind: InterruptEvent, Message: message})
+	return r.State.TriggerInterrupt(message)
+}
+
+// WriteMemory writes memory of the machine, and records it.
+func (r *Recorder) WriteMemory(address, value Word) {
+	r.event(ReplayEvent{Kind: MemoryEvent, Address: address, Value: value})
+	r.State.PokeMemory(address, value)
+}
+
+// Input gives data to the InputDevice at the index, and records it.
+func (r *Recorder) Input(device Word, data []byte) error {
+	r.event(ReplayEvent{Kind: InputEvent, Device: device, Data: append([]byte(nil), data...)})
+	return applyInput(r.State, device, data)
+}
+
+func applyInput(state *D16MachineState, index Word, data []byte) error {
+	device, ok := state.Device(index)
+	if !ok {
+		return NoSuchDeviceError(index)
+	}
+	input, ok := device.(InputDevice)
+	if !ok {
+		return NotInputDeviceError(index)
+	}
+	return input.Input(state, data)
+}
+
+// Log hashes the current state, and returns the log recorded so far.
+func (r *Recorder) Log() (*ReplayLog, error) {
+	if err := r.hash(); err != nil {
+		return nil, err
+	}
+	log := r.log
+	log.Events = append([]ReplayEvent(nil), r.log.Events...)
+	log.Hashes = append([]StateHash(nil), r.log.Hashes...)
+	log.Faults = append([]uint64(nil), r.log.Faults...)
+	return &log, nil
+}
+
+// DivergenceError is returned when a replay diverges from its recording.
+type DivergenceError struct {
+	// Step and Cycle are where the divergence was detected.
+	Step  uint64
+	Cycle uint64
+	// Since is the step of the last hash that matched, after which the
+	// divergence started.
+	Since uint64
+	// Expected is the recorded hash, if the divergence was detected by a hash
+	// (Hash) not matching.
+	Expected *StateHash
+	Hash     uint64
+	// Event is the recorded event, if it could not be delivered at its cycle.
+	Event *ReplayEvent
+}
+
+func (err *DivergenceError) Error() string {
+	if err.Event != nil {
+		return fmt.Sprintf("replay diverged after step %d: %v event at cycle %d, expected at cycle %d",
+			err.Since, err.Event.Kind, err.Cycle, err.Event.Cycle)
+	}
+	return fmt.Sprintf("replay diverged after step %d: at step %d cycle %d got hash %016x, expected cycle %d hash %016x",
+		err.Since, err.Step, err.Cycle, err.Hash, err.Expected.Cycle, err.Expected.Hash)
+}
+
+// Replayer replays a ReplayLog on a machine, verifying that it reproduces the
+// recorded hashes.
+type Replayer struct {
+	State *D16MachineState
+
+	log       *ReplayLog
+	steps     uint64
+	start     uint64
+	nextEvent int
+	nextHash  int
+	nextFault int
+	since     uint64
+}
+
+// NewReplayer restores the machine to the initial state of the log. The
+// machine must be configured as the recorded machine was, with the same kind
+// of memory and devices.
+func NewReplayer(state *D16MachineState, log *ReplayLog) (*Replayer, error) {
+	if log.Initial == nil || len(log.Hashes) == 0 {
+		return nil, InvalidReplayLogError
+	}
+	if err := state.Restore(log.Initial); err != nil {
+		return nil, err
+	}
+	return &Replayer{State: state, log: log, start: state.Cycles()}, nil
+}
+
+// Done returns true if the whole log has been replayed.
+func (r *Replayer) Done() bool {
+	return r.nextHash == len(r.log.Hashes)
+}
+
+// sync delivers the events recorded at the current step, and verifies the
+// hash recorded at the step, if any.
+func (r *Replayer) sync() error {
+	cycle := r.State.Cycles() - r.start
+	for ; r.nextEvent < len(r.log.Events) && r.log.Events[r.nextEvent].Step == r.steps; r.nextEvent++ {
+		event := &r.log.Events[r.nextEvent]
+		if event.Cycle != cycle {
+			return &DivergenceError{Step: r.steps, Cycle: cycle, Since: r.since, Event: event}
+		}
+		var err error
+		switch event.Kind {
+		case InterruptEvent:
+			err = r.State.TriggerInterrupt(event.Message)
+		case MemoryEvent:
+			r.State.PokeMemory(event.Address, event.Value)
+		case InputEvent:
+			err = applyInput(r.State, event.Device, event.Data)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if r.nextHash < len(r.log.Hashes) && r.log.Hashes[r.nextHash].Step == r.steps {
+		expected := &r.log.Hashes[r.nextHash]
+		hash, err := HashState(r.State)
+		if err != nil {
+			return err
+		}
+		if hash != expected.Hash || cycle != expected.Cycle {
+			return &DivergenceError{Step: r.steps, Cycle: cycle, Since: r.since, Expected: expected, Hash: hash}
+		}
+		r.since = r.steps
+		r.nextHash++
+	}
+	return nil
+}
+
+// Step delivers the events recorded before the next step, and steps the
+// machine. Returns a *DivergenceError if the replay has diverged from the
+// recording, and ReplayFinishedError after the last step of the log. Otherwise
+// returns any error from stepping the machine.
+func (r *Replayer) Step() (int, error) {
+	if err := r.sync(); err != nil {
+		return 0, err
+	}
+	if r.Done() {
+		return 0, ReplayFinishedError
+	}
+	ticks, err := Step(r.State)
+	r.steps++
+	return ticks, err
+}
+
+// recordedFault returns true if Step returned a *Fault at the step when
+// recording.
+func (r *Replayer) recordedFault(step uint64) bool {
+	faults := r.log.Faults
+	for r.nextFault < len(faults) && faults[r.nextFault] < step {
+		r.nextFault++
+	}
+	return r.nextFault < len(faults) && faults[r.nextFault] == step
+}
+
+// Run replays the rest of the log. Faults at the steps that faulted when
+// recording, and other errors from stepping the machine, are ignored, as they
+// were when recording. Returns nil if the replay reproduced the recording,
+// otherwise the first divergence, or a *Fault that was not recorded.
+func (r *Replayer) Run() error {
+	for {
+		if err := r.sync(); err != nil {
+			return err
+		}
+		if r.Done() {
+			return nil
+		}
+		_, err := Step(r.State)
+		r.steps++
+		if _, ok := err.(*Fault); ok && !r.recordedFault(r.steps-1) {
+			return err
+		}
+	}
+}
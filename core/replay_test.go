@@ -0,0 +1,205 @@
+package core
+
+import (
+	"bytes"
+	"testing"
+)
+
+var keyDeviceInputTest InputDevice = &keyDevice{}
+
+// keyDevice is an InputDevice that buffers key presses, and sets C to the next
+// key when interrupted.
+type keyDevice struct {
+	FakeDevice
+	keys []byte
+}
+
+func (d *keyDevice) Input(state MachineState, data []byte) error {
+	d.keys = append(d.keys, data...)
+	return nil
+}
+
+func (d *keyDevice) Interrupt(state MachineState) (int, error) {
+	if len(d.keys) > 0 {
+		state.WriteRegister(RegC, Word(d.keys[0]))
+		d.keys = d.keys[1:]
+	}
+	return 0, nil
+}
+
+func (d *keyDevice) SaveState() ([]byte, error) {
+	return append([]byte(nil), d.keys...), nil
+}
+
+func (d *keyDevice) LoadState(data []byte) error {
+	d.keys = append([]byte(nil), data...)
+	return nil
+}
+
+func replayTestMachine() *D16MachineState {
+	state := &D16MachineState{}
+	state.Init()
+	copy(state.D16MemoryState.Data[:], undoTestProgram)
+	state.AttachDevice(&keyDevice{FakeDevice: FakeDevice{id: 0x30cf7406}})
+	return state
+}
+
+func recordReplayTest(t *testing.T) *ReplayLog {
+	recorder, err := NewRecorder(replayTestMachine(), 8)
+	if err != nil {
+		t.Fatalf("NewRecorder returned error %v", err)
+	}
+	for i := 0; i < 50; i++ {
+		switch i {
+		case 10:
+			recorder.WriteMemory(0x3000, 0x1234)
+		case 20:
+			recorder.TriggerInterrupt(0x0042)
+		case 30:
+			if err := recorder.Input(0, []byte("key")); err != nil {
+				t.Fatalf("Input returned error %v", err)
+			}
+		}
+		recorder.Step()
+	}
+	log, err := recorder.Log()
+	if err != nil {
+		t.Fatalf("Log returned error %v", err)
+	}
+	return log
+}
+
+func TestReplay(t *testing.T) {
+	log := recordReplayTest(t)
+	if len(log.Events) != 3 || len(log.Hashes) != 7 {
+		t.Fatalf("got %d events and %d hashes, expected 3 and 7", len(log.Events), len(log.Hashes))
+	}
+
+	var buf bytes.Buffer
+	if err := log.Write(&buf); err != nil {
+		t.Fatalf("Write returned error %v", err)
+	}
+	log, err := ReadReplayLog(&buf)
+	if err != nil {
+		t.Fatalf("ReadReplayLog returned error %v", err)
+	}
+
+	state := replayTestMachine()
+	state.WriteRegister(RegA, 0xffff)
+	replayer, err := NewReplayer(state, log)
+	if err != nil {
+		t.Fatalf("NewReplayer returned error %v", err)
+	}
+	if err := replayer.Run(); err != nil {
+		t.Fatalf("replay diverged: %v", err)
+	}
+	if !replayer.Done() {
+		t.Errorf("replay did not finish")
+	}
+	if _, err := replayer.Step(); err != ReplayFinishedError {
+		t.Errorf("Step after finishing returned %v", err)
+	}
+	if device, _ := state.Device(0); string(device.(*keyDevice).keys) != "key" {
+		t.Errorf("input was not replayed")
+	}
+}
+
+func TestReplayDivergence(t *testing.T) {
+	type Test struct {
+		Name     string
+		Modify   func(log *ReplayLog)
+		ExpStep  uint64
+		ExpSince uint64
+		ExpEvent bool
+	}
+
+	tests := []Test{
+		{"event value", func(log *ReplayLog) { log.Events[2].Data = []byte("kex") }, 32, 24, false},
+		{"event cycle", func(log *ReplayLog) { log.Events[1].Cycle++ }, 20, 16, true},
+		{"hash", func(log *ReplayLog) { log.Hashes[len(log.Hashes)-1].Hash++ }, 50, 48, false},
+	}
+
+	for _, test := range tests {
+		log := recordReplayTest(t)
+		test.Modify(log)
+		replayer, err := NewReplayer(replayTestMachine(), log)
+		if err != nil {
+			t.Fatalf("%s: NewReplayer returned error %v", test.Name, err)
+		}
+		err = replayer.Run()
+		divergence, ok := err.(*DivergenceError)
+		if !ok {
+			t.Errorf("%s: expected *DivergenceError, got %v", test.Name, err)
+			continue
+		}
+		if divergence.Step != test.ExpStep || divergence.Since != test.ExpSince || (divergence.Event != nil) != test.ExpEvent {
+			t.Errorf("%s: got divergence %v", test.Name, divergence)
+		}
+	}
+}
+
+func TestReplayLogBeforeEvent(t *testing.T) {
+	recorder, err := NewRecorder(replayTestMachine(), 8)
+	if err != nil {
+		t.Fatalf("NewRecorder returned error %v", err)
+	}
+	for i := 0; i < 30; i++ {
+		if i == 12 || i == 16 {
+			if _, err := recorder.Log(); err != nil {
+				t.Fatalf("Log returned error %v", err)
+			}
+			recorder.WriteMemory(0x3000, Word(i))
+		}
+		recorder.Step()
+	}
+	log, err := recorder.Log()
+	if err != nil {
+		t.Fatalf("Log returned error %v", err)
+	}
+	replayer, err := NewReplayer(replayTestMachine(), log)
+	if err != nil {
+		t.Fatalf("NewReplayer returned error %v", err)
+	}
+	if err := replayer.Run(); err != nil {
+		t.Errorf("replay diverged: %v", err)
+	}
+}
+
+func TestReplayFaults(t *testing.T) {
+	recorder, err := NewRecorder(replayTestMachine(), 8)
+	if err != nil {
+		t.Fatalf("NewRecorder returned error %v", err)
+	}
+	for i := 0; i < 50; i++ {
+		if i == 40 {
+			// Replace the final SET PC, 7 with an illegal instruction.
+			recorder.WriteMemory(0x0007, 0x0000)
+		}
+		recorder.Step()
+	}
+	log, err := recorder.Log()
+	if err != nil {
+		t.Fatalf("Log returned error %v", err)
+	}
+	if len(log.Faults) != 10 || log.Faults[0] != 40 {
+		t.Fatalf("got faults at steps %v, expected 40 to 49", log.Faults)
+	}
+
+	replayer, err := NewReplayer(replayTestMachine(), log)
+	if err != nil {
+		t.Fatalf("NewReplayer returned error %v", err)
+	}
+	if err := replayer.Run(); err != nil {
+		t.Errorf("replay with recorded faults returned %v", err)
+	}
+
+	log.Faults = log.Faults[1:]
+	replayer, err = NewReplayer(replayTestMachine(), log)
+	if err != nil {
+		t.Fatalf("NewReplayer returned error %v", err)
+	}
+	err = replayer.Run()
+	if fault, ok := err.(*Fault); !ok || fault.PC != 0x0007 {
+		t.Errorf("replay with unrecorded fault returned %v, expected *Fault at 0007", err)
+	}
+}
@@ -0,0 +1,328 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// DefaultSlice is the default number of cycles that a Scheduler runs a machine
+// for at a time, 10ms at ClockRate.
+const DefaultSlice = ClockRate / 100
+
+// NoSuchMachineError is returned when referring to a machine that has not
+// been added to a Scheduler, or has been removed.
+type NoSuchMachineError MachineId
+
+func (err NoSuchMachineError) Error() string {
+	return fmt.Sprintf("no machine with id %d", MachineId(err))
+}
+
+// MachineStoppedError is returned when posting an event to a machine that has
+// stopped.
+type MachineStoppedError MachineId
+
+func (err MachineStoppedError) Error() string {
+	return fmt.Sprintf("machine %d has stopped", MachineId(err))
+}
+
+// MachineNotPausedError is returned when resuming a machine that is not
+// paused.
+type MachineNotPausedError MachineId
+
+func (err MachineNotPausedError) Error() string {
+	return fmt.Sprintf("machine %d is not paused", MachineId(err))
+}
+
+// MachineId identifies a machine added to a Scheduler.
+type MachineId uint64
+
+// MachineEvent is delivered to a machine by a Scheduler between slices. An
+// error stops the machine with StopFault.
+type MachineEvent func(state MachineState) error
+
+// scheduledMachine is a machine in a Scheduler. The emulator and debt are
+// only used by the worker running the machine, and the other fields are
+// guarded by the mutex of the Scheduler.
+type scheduledMachine struct {
+	id       MachineId
+	emulator *Emulator
+	// debt is the number of cycles that the last slice overran its budget by,
+	// taken from the next slice.
+	debt uint64
+
+	events  []MachineEvent
+	removed bool
+	paused  bool
+	stopped bool
+	result  RunResult
+}
+
+// Scheduler runs many isolated machines concurrently on a pool of worker
+// goroutines. Machines wait in a queue to run for a slice of cycles on a
+// worker, and rejoin the back of the queue when the slice finishes, so that
+// each machine gets an equal share of cycles in turn without waiting for
+// slower machines. A slice that overruns its budget (by finishing its last
+// instruction) is shortened next time. A machine pauses when a watchpoint
+// pauses it, until resumed by Resume, and stops when Step returns any other
+// error, and is then not run again. Methods may be called from any goroutine.
+type Scheduler struct {
+	// Slice is the number of cycles that a machine runs for at a time. It must
+	// be positive, and not be changed while Run is running.
+	Slice uint64
+
+	workers  int
+	mu       sync.Mutex
+	cond     *sync.Cond // Broadcast when ready, inFlight or paused change.
+	machines map[MachineId]*scheduledMachine
+	nextId   MachineId
+	ready    []*scheduledMachine // The machines waiting for a slice.
+	inFlight int                 // The number of machines in a slice.
+	paused   int                 // The number of machines paused.
+	done     bool                // Set when the context of Run is done.
+}
+
+// NewScheduler creates a Scheduler that runs machines on the number of worker
+// goroutines, for slices of DefaultSlice cycles.
+func NewScheduler(workers int) *Scheduler {
+	if workers < 1 {
+		workers = 1
+	}
+	s := &Scheduler{
+		Slice:    DefaultSlice,
+		workers:  workers,
+		machines: make(map[MachineId]*scheduledMachine),
+	}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// Add adds a machine, to be run after those already added. The machine must
+// not be used other than through the Scheduler until removed.
+func (s *Scheduler) Add(state MachineState) MachineId {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextId++
+	m := &scheduledMachine{id: s.nextId, emulator: NewEmulator(state)}
+	s.machines[m.id] = m
+	s.ready = append(s.ready, m)
+	s.cond.Broadcast()
+	return m.id
+}
+
+// Remove removes a machine. If the machine is in a slice, it is removed at the
+// end of the slice, so it must not be used until Run returns.
+func (s *Scheduler) Remove(id MachineId) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.machines[id]
+	if !ok {
+		return NoSuchMachineError(id)
+	}
+	delete(s.machines, id)
+	m.removed = true
+	if m.paused {
+		m.paused = false
+		s.paused--
+	}
+	s.ready = removeScheduledMachine(s.ready, m)
+	s.cond.Broadcast()
+	return nil
+}
+
+func removeScheduledMachine(machines []*scheduledMachine, m *scheduledMachine) []*scheduledMachine {
+	for i, other := range machines {
+		if other == m {
+			return append(machines[:i], machines[i+1:]...)
+		}
+	}
+	return machines
+}
+
+// Post queues an event for a machine. Events are delivered before the next
+// slice of the machine, in the order that they were posted. Returns
+// MachineStoppedError if the machine has stopped, as the event would never be
+// delivered.
+func (s *Scheduler) Post(id MachineId, event MachineEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.machines[id]
+	if !ok {
+		return NoSuchMachineError(id)
+	}
+	if m.stopped {
+		return MachineStoppedError(id)
+	}
+	m.events = append(m.events, event)
+	return nil
+}
+
+// Resume resumes a machine paused by a watchpoint. Returns
+// MachineNotPausedError if the machine is not paused.
+func (s *Scheduler) Resume(id MachineId) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.machines[id]
+	if !ok {
+		return NoSuchMachineError(id)
+	}
+	if !m.paused {
+		return MachineNotPausedError(id)
+	}
+	m.paused = false
+	s.paused--
+	s.ready = append(s.ready, m)
+	s.cond.Broadcast()
+	return nil
+}
+
+// Interrupt queues an event that triggers an interrupt on a machine.
+func (s *Scheduler) Interrupt(id MachineId, message Word) error {
+	return s.Post(id, func(state MachineState) error {
+		return state.TriggerInterrupt(message)
+	})
+}
+
+// Cycles returns the number of cycles that a machine has executed.
+func (s *Scheduler) Cycles(id MachineId) (uint64, error) {
+	s.mu.Lock()
+	m, ok := s.machines[id]
+	s.mu.Unlock()
+	if !ok {
+		return 0, NoSuchMachineError(id)
+	}
+	return m.emulator.Cycles(), nil
+}
+
+// Paused returns the result of the slice that a watchpoint paused a machine
+// in, and false if it is not paused.
+func (s *Scheduler) Paused(id MachineId) (RunResult, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.machines[id]
+	if !ok {
+		return RunResult{}, false, NoSuchMachineError(id)
+	}
+	if !m.paused {
+		return RunResult{}, false, nil
+	}
+	return m.result, true, nil
+}
+
+// Stopped returns the result of the slice that stopped a machine, and false if
+// it has not stopped.
+func (s *Scheduler) Stopped(id MachineId) (RunResult, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.machines[id]
+	if !ok {
+		return RunResult{}, false, NoSuchMachineError(id)
+	}
+	if !m.stopped {
+		return RunResult{}, false, nil
+	}
+	return m.result, true, nil
+}
+
+// Run runs the machines until the context is done, or no machine is left to
+// run because all have stopped or been removed. Run waits for paused machines
+// to be resumed. Slices in progress are
+// finished before returning. Run must not be called again until it returns.
+func (s *Scheduler) Run(ctx context.Context) {
+	s.mu.Lock()
+	s.done = false
+	s.mu.Unlock()
+
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.mu.Lock()
+			s.done = true
+			s.cond.Broadcast()
+			s.mu.Unlock()
+		case <-finished:
+		}
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(s.workers)
+	for i := 0; i < s.workers; i++ {
+		go func() {
+			defer wg.Done()
+			for m := s.next(); m != nil; m = s.next() {
+				s.runSlice(m)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// next waits for a machine to run, and returns nil when Run should return.
+func (s *Scheduler) next() *scheduledMachine {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for !s.done && len(s.ready) == 0 {
+		if s.inFlight == 0 && s.paused == 0 {
+			// No machine is left to run.
+			s.cond.Broadcast()
+			return nil
+		}
+		// Wait for a machine to finish its slice, or be resumed.
+		s.cond.Wait()
+	}
+	if s.done {
+		return nil
+	}
+	m := s.ready[0]
+	s.ready = s.ready[1:]
+	s.inFlight++
+	return m
+}
+
+// runSlice delivers the events for a machine, and then runs it for a slice.
+func (s *Scheduler) runSlice(m *scheduledMachine) {
+	s.mu.Lock()
+	events := m.events
+	m.events = nil
+	s.mu.Unlock()
+
+	result := RunResult{Reason: StopBudget}
+	for _, event := range events {
+		if err := event(m.emulator.State); err != nil {
+			result = RunResult{Reason: StopFault, Err: err}
+			break
+		}
+	}
+	if result.Reason == StopBudget {
+		if m.debt < s.Slice {
+			budget := s.Slice - m.debt
+			// Slices are not cancelled, as Run finishes them before returning.
+			result = m.emulator.RunCycles(context.Background(), budget)
+			m.debt = 0
+			if result.Cycles > budget {
+				m.debt = result.Cycles - budget
+			}
+		} else {
+			m.debt -= s.Slice
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inFlight--
+	switch {
+	case m.removed:
+	case result.Reason == StopBudget:
+		s.ready = append(s.ready, m)
+	case result.Reason == StopBreakpoint:
+		m.paused = true
+		m.result = result
+		s.paused++
+	default:
+		m.stopped = true
+		m.result = result
+	}
+	s.cond.Broadcast()
+}
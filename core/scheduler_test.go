@@ -0,0 +1,255 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// schedulerTestProgram counts A up to 20, and then faults.
+var schedulerTestProgram = []Word{
+	0x8802, // 0x0000: ADD A, 1
+	0xd413, // 0x0001: IFN A, 20
+	0x8781, // 0x0002: SET PC, 0
+	0x0018, // 0x0003: invalid
+}
+
+func newSchedulerTestMachine(program []Word) *D16MachineState {
+	state := &D16MachineState{}
+	state.Init()
+	copy(state.D16MemoryState.Data[:], program)
+	return state
+}
+
+func TestSchedulerStop(t *testing.T) {
+	expected := NewEmulator(newSchedulerTestMachine(schedulerTestProgram)).Run(context.Background())
+
+	s := NewScheduler(4)
+	s.Slice = 7
+	var states []*D16MachineState
+	var ids []MachineId
+	for i := 0; i < 16; i++ {
+		state := newSchedulerTestMachine(schedulerTestProgram)
+		states = append(states, state)
+		ids = append(ids, s.Add(state))
+	}
+	s.Run(context.Background())
+
+	for i, id := range ids {
+		result, stopped, err := s.Stopped(id)
+		if err != nil || !stopped {
+			t.Fatalf("machine %d: Stopped returned %v, %v", id, stopped, err)
+		}
+		if result.Reason != StopFault {
+			t.Errorf("machine %d: stopped for %v, expected %v", id, result.Reason, StopFault)
+		}
+		if a := states[i].Register(RegA); a != 20 {
+			t.Errorf("machine %d: got A=%d, expected 20", id, a)
+		}
+		if cycles, _ := s.Cycles(id); cycles != expected.Cycles {
+			t.Errorf("machine %d: executed %d cycles, expected %d", id, cycles, expected.Cycles)
+		}
+	}
+}
+
+func TestSchedulerFairness(t *testing.T) {
+	// A single worker, so that no machine is held back in a slice by the
+	// scheduling of the workers.
+	s := NewScheduler(1)
+	s.Slice = 100
+	var ids []MachineId
+	for i := 0; i < 32; i++ {
+		ids = append(ids, s.Add(newSchedulerTestMachine(runTestProgram)))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	s.Run(ctx)
+
+	var min, max uint64
+	for i, id := range ids {
+		cycles, err := s.Cycles(id)
+		if err != nil {
+			t.Fatalf("Cycles returned error %v", err)
+		}
+		if i == 0 || cycles < min {
+			min = cycles
+		}
+		if cycles > max {
+			max = cycles
+		}
+	}
+	if min == 0 {
+		t.Fatalf("a machine did not run")
+	}
+	// Cancelling may stop part way through the queue, and a slice may overrun
+	// by an instruction.
+	if max-min > s.Slice+2 {
+		t.Errorf("machines executed between %d and %d cycles", min, max)
+	}
+}
+
+// waitForScheduler polls until cond returns true, or fails the test after a
+// second.
+func waitForScheduler(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSchedulerSlowMachine(t *testing.T) {
+	s := NewScheduler(2)
+	s.Slice = 100
+	slow := s.Add(newSchedulerTestMachine(runTestProgram))
+	fast := s.Add(newSchedulerTestMachine(runTestProgram))
+	started := make(chan struct{})
+	release := make(chan struct{})
+	s.Post(slow, func(MachineState) error {
+		close(started)
+		<-release
+		return nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	finished := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(finished)
+	}()
+
+	<-started
+	// The other machine keeps running while the slow machine is in a slice.
+	waitForScheduler(t, "the other machine to run", func() bool {
+		cycles, _ := s.Cycles(fast)
+		return cycles > 10*s.Slice
+	})
+	close(release)
+	cancel()
+	<-finished
+}
+
+func TestSchedulerPause(t *testing.T) {
+	s := NewScheduler(2)
+	s.Slice = 7
+	state := newSchedulerTestMachine(schedulerTestProgram)
+	state.AddWatchpoint(0x0002, 1, WatchExecute, func(state MachineState, event WatchEvent) bool {
+		return state.Register(RegA) == 10
+	})
+	id := s.Add(state)
+	if err := s.Resume(id); err != MachineNotPausedError(id) {
+		t.Errorf("Resume of running machine returned %v, expected %v", err, MachineNotPausedError(id))
+	}
+	finished := make(chan struct{})
+	go func() {
+		s.Run(context.Background())
+		close(finished)
+	}()
+
+	waitForScheduler(t, "the machine to pause", func() bool {
+		_, paused, _ := s.Paused(id)
+		return paused
+	})
+	result, _, _ := s.Paused(id)
+	if _, ok := result.Err.(*WatchpointError); result.Reason != StopBreakpoint || !ok {
+		t.Errorf("paused with result %#v, expected a watchpoint", result)
+	}
+	if a := state.Register(RegA); a != 10 {
+		t.Errorf("paused at A=%d, expected 10", a)
+	}
+	if _, stopped, _ := s.Stopped(id); stopped {
+		t.Errorf("paused machine reported as stopped")
+	}
+	select {
+	case <-finished:
+		t.Fatalf("Run returned while a machine was paused")
+	default:
+	}
+
+	if err := s.Resume(id); err != nil {
+		t.Fatalf("Resume returned error %v", err)
+	}
+	<-finished
+	if result, stopped, _ := s.Stopped(id); !stopped || result.Reason != StopFault {
+		t.Errorf("got stopped=%v result %#v, expected to stop with a fault", stopped, result)
+	}
+	if a := state.Register(RegA); a != 20 {
+		t.Errorf("got A=%d, expected 20", a)
+	}
+}
+
+func TestSchedulerEvents(t *testing.T) {
+	s := NewScheduler(4)
+	s.Slice = 10
+	state := newSchedulerTestMachine(runTestProgram)
+	id := s.Add(state)
+	var delivered []Word
+	for i := Word(0); i < 100; i++ {
+		i := i
+		s.Post(id, func(state MachineState) error {
+			delivered = append(delivered, i)
+			state.WriteMemory(0x1000, i)
+			return nil
+		})
+	}
+	if err := s.Interrupt(id, 7); err != nil {
+		t.Fatalf("Interrupt returned error %v", err)
+	}
+	eventErr := errors.New("event error")
+	s.Post(id, func(MachineState) error { return eventErr })
+	s.Post(id, func(MachineState) error {
+		t.Errorf("event delivered after an error")
+		return nil
+	})
+	s.Run(context.Background())
+
+	for i, message := range delivered {
+		if message != Word(i) {
+			t.Fatalf("events delivered in order %v", delivered)
+		}
+	}
+	if len(delivered) != 100 {
+		t.Errorf("delivered %d events, expected 100", len(delivered))
+	}
+	if value := state.ReadMemory(0x1000); value != 99 {
+		t.Errorf("got [0x1000]=%d, expected 99", value)
+	}
+	if queued := state.QueuedInterrupts(); len(queued) != 1 || queued[0] != 7 {
+		t.Errorf("got queued interrupts %v, expected [7]", queued)
+	}
+	if result, stopped, _ := s.Stopped(id); !stopped || result.Reason != StopFault || result.Err != eventErr {
+		t.Errorf("got stopped=%v result %#v, expected to stop with %v", stopped, result, eventErr)
+	}
+	if err := s.Post(id, func(MachineState) error { return nil }); err != MachineStoppedError(id) {
+		t.Errorf("Post to stopped machine returned %v, expected %v", err, MachineStoppedError(id))
+	}
+	if err := s.Interrupt(id, 7); err != MachineStoppedError(id) {
+		t.Errorf("Interrupt of stopped machine returned %v, expected %v", err, MachineStoppedError(id))
+	}
+}
+
+func TestSchedulerRemove(t *testing.T) {
+	s := NewScheduler(2)
+	id := s.Add(newSchedulerTestMachine(runTestProgram))
+	if err := s.Remove(id); err != nil {
+		t.Fatalf("Remove returned error %v", err)
+	}
+	// Returns as there is no machine to run.
+	s.Run(context.Background())
+
+	expErr := NoSuchMachineError(id)
+	if err := s.Remove(id); err != expErr {
+		t.Errorf("Remove returned %v, expected %v", err, expErr)
+	}
+	if err := s.Interrupt(id, 1); err != expErr {
+		t.Errorf("Interrupt returned %v, expected %v", err, expErr)
+	}
+	if _, err := s.Cycles(id); err != expErr {
+		t.Errorf("Cycles returned %v, expected %v", err, expErr)
+	}
+	if _, _, err := s.Stopped(id); err != expErr {
+		t.Errorf("Stopped returned %v, expected %v", err, expErr)
+	}
+}
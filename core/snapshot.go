@@ -0,0 +1,479 @@
+package core
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// SnapshotVersion is the version of the snapshot format written by Save.
+const SnapshotVersion = 1
+
+// snapshotMagic starts every binary snapshot.
+const snapshotMagic = "DCPU16SS"
+
+// InvalidSnapshotError is returned when loading data that is not a snapshot,
+// or is inconsistent.
+var InvalidSnapshotError = errors.New("invalid machine snapshot")
+
+// UnsavableMemoryError is returned when saving a machine whose Memory is
+// neither nil nor a *PagedMemory.
+var UnsavableMemoryError = errors.New("machine memory cannot be saved")
+
+type SnapshotVersionError uint16
+
+func (err SnapshotVersionError) Error() string {
+	return fmt.Sprintf("unsupported snapshot version %d", uint16(err))
+}
+
+// SnapshotDeviceError is returned when restoring a snapshot whose device at
+// the index does not match the device attached to the machine.
+type SnapshotDeviceError int
+
+func (err SnapshotDeviceError) Error() string {
+	return fmt.Sprintf("snapshot device %d does not match the machine", int(err))
+}
+
+// StatefulDevice is a Device whose state is included in snapshots.
+type StatefulDevice interface {
+	Device
+	SaveState() ([]byte, error)
+	LoadState(data []byte) error
+}
+
+// Snapshot is the saved state of a D16MachineState. Memory is stored as runs
+// of words, omitting runs of zeros.
+type Snapshot struct {
+	Version   uint16
+	Registers [8]Word
+	PC        Word
+	SP        Word
+	EX        Word
+	IA        Word
+
+	QueueInterrupts  bool
+	QueuedInterrupts []Word
+
+	// NumFrames is zero for the embedded D16MemoryState, otherwise the number
+	// of frames of a PagedMemory. PageTable is present only for PagedMemory.
+	NumFrames uint32
+	PageTable []Word `json:",omitempty"`
+	Memory    []MemoryRun
+
+	Devices []DeviceSnapshot
+}
+
+// MemoryRun is a run of consecutive words of memory. The address is within
+// the backing store for PagedMemory.
+type MemoryRun struct {
+	Address DWord
+	Words   []Word
+}
+
+// DeviceSnapshot identifies a device, and holds the state of a StatefulDevice.
+type DeviceSnapshot struct {
+	ID           DWord
+	Version      Word
+	Manufacturer DWord
+	State        []byte `json:",omitempty"`
+}
+
+// minZeroGap is the number of consecutive zeros that separates memory runs.
+const minZeroGap = 8
+
+// memoryRuns returns the runs of words for read, omitting runs of at least
+// minZeroGap zeros.
+func memoryRuns(size int, read func(address int) Word) []MemoryRun {
+	var runs []MemoryRun
+	for address := 0; address < size; {
+		if read(address) == 0 {
+			address++
+			continue
+		}
+		start, zeros := address, 0
+		for ; address < size && zeros < minZeroGap; address++ {
+			if read(address) == 0 {
+				zeros++
+			} else {
+				zeros = 0
+			}
+		}
+		end := address - zeros
+		words := make([]Word, end-start)
+		for i := range words {
+			words[i] = read(start + i)
+		}
+		runs = append(runs, MemoryRun{DWord(start), words})
+	}
+	return runs
+}
+
+// Snapshot returns the state of the machine. The configuration of the machine,
+// such as its instruction set, fault policy, watchpoints, protection and
+// mapped memory regions, is not included.
+func (state *D16MachineState) Snapshot() (*Snapshot, error) {
+	s := &Snapshot{
+		Version:          SnapshotVersion,
+		Registers:        state.registers,
+		PC:               state.pc,
+		SP:               state.sp,
+		EX:               state.ex,
+		IA:               state.ia,
+		QueueInterrupts:  state.QueueInterrupts(),
+		QueuedInterrupts: state.D16Interrupts.QueuedInterrupts(),
+	}
+
+	switch memory := state.Memory.(type) {
+	case nil:
+		s.Memory = memoryRuns(MemorySize, func(address int) Word {
+			return state.D16MemoryState.Data[address]
+		})
+	case *PagedMemory:
+		s.NumFrames = uint32(memory.NumFrames())
+		s.PageTable = append([]Word(nil), memory.pageTable[:]...)
+		s.Memory = memoryRuns(memory.NumFrames()*PageSize, func(address int) Word {
+			return memory.ReadBacking(DWord(address))
+		})
+	default:
+		return nil, UnsavableMemoryError
+	}
+
+	for _, device := range state.Devices {
+		d := DeviceSnapshot{ID: device.ID(), Version: device.Version(), Manufacturer: device.Manufacturer()}
+		if stateful, ok := device.(StatefulDevice); ok {
+			var err error
+			if d.State, err = stateful.SaveState(); err != nil {
+				return nil, err
+			}
+		}
+		s.Devices = append(s.Devices, d)
+	}
+	return s, nil
+}
+
+// Restore sets the state of the machine from the snapshot. The machine must
+// have the same kind of memory as when the snapshot was taken, and the same
+// devices attached. Any undo log is reset, discarding its records and
+// checkpoints. If the snapshot is invalid, or a device fails to load its state,
+// then the machine is unchanged, except for the state of devices before the one
+// that failed.
+func (state *D16MachineState) Restore(s *Snapshot) error {
+	if err := state.restore(s); err != nil {
+		return err
+	}
+	if state.Undo != nil {
+		state.Undo.reset()
+	}
+	return nil
+}
+
+// restore implements Restore, without changing any undo log.
+func (state *D16MachineState) restore(s *Snapshot) error {
+	if s.Version != SnapshotVersion {
+		return SnapshotVersionError(s.Version)
+	}
+	if len(s.QueuedInterrupts) > MaxInterruptQueue {
+		return InvalidSnapshotError
+	}
+	if len(s.Devices) != len(state.Devices) {
+		return SnapshotDeviceError(len(state.Devices))
+	}
+	for i, device := range state.Devices {
+		d := s.Devices[i]
+		if d.ID != device.ID() || d.Version != device.Version() || d.Manufacturer != device.Manufacturer() {
+			return SnapshotDeviceError(i)
+		}
+	}
+
+	// Check and prepare the memory, and then load the devices, before changing
+	// the machine, so that it is unchanged if either fails.
+	var restored *PagedMemory
+	switch state.Memory.(type) {
+	case nil:
+		if s.NumFrames != 0 || !validRuns(s.Memory, MemorySize) {
+			return InvalidSnapshotError
+		}
+	case *PagedMemory:
+		numFrames := int(s.NumFrames)
+		if numFrames < NumPages || numFrames > MaxFrames || len(s.PageTable) != NumPages || !validRuns(s.Memory, numFrames*PageSize) {
+			return InvalidSnapshotError
+		}
+		restored = &PagedMemory{
+			frames: make([]*pageFrame, numFrames),
+		}
+		for page, frame := range s.PageTable {
+			if err := restored.MapPage(Word(page), frame); err != nil {
+				return InvalidSnapshotError
+			}
+		}
+		for _, run := range s.Memory {
+			for i, w := range run.Words {
+				restored.WriteBacking(run.Address+DWord(i), w)
+			}
+		}
+	default:
+		return UnsavableMemoryError
+	}
+
+	for i, device := range state.Devices {
+		if stateful, ok := device.(StatefulDevice); ok {
+			if err := stateful.LoadState(s.Devices[i].State); err != nil {
+				return err
+			}
+		}
+	}
+
+	if restored != nil {
+		memory := state.Memory.(*PagedMemory)
+		for _, frame := range memory.frames {
+			frame.release()
+		}
+		*memory = *restored
+	} else {
+		state.D16MemoryState.Data = [MemorySize]Word{}
+		for _, run := range s.Memory {
+			copy(state.D16MemoryState.Data[run.Address:], run.Words)
+		}
+	}
+
+	state.registers = s.Registers
+	state.pc, state.sp, state.ex, state.ia = s.PC, s.SP, s.EX, s.IA
+	state.D16Interrupts = D16Interrupts{}
+	state.WriteQueueInterrupts(s.QueueInterrupts)
+	for _, message := range s.QueuedInterrupts {
+		state.TriggerInterrupt(message)
+	}
+	state.pendingErr = nil
+	return nil
+}
+
+// validRuns returns true if the runs are within memory of the given size.
+func validRuns(runs []MemoryRun, size int) bool {
+	for _, run := range runs {
+		if int(run.Address)+len(run.Words) > size {
+			return false
+		}
+	}
+	return true
+}
+
+// Save writes a snapshot of the machine in the binary snapshot format. All
+// values are little-endian:
+//
+//	magic            8 bytes "DCPU16SS"
+//	version          uint16
+//	registers        8 x uint16, A to J
+//	PC, SP, EX, IA   4 x uint16
+//	queueing         uint8, 1 if interrupts are queued
+//	queue length     uint16
+//	queue            uint16 per message, oldest first
+//	frames           uint32, 0 for the embedded D16MemoryState
+//	page table       16 x uint16, only if frames is not 0
+//	run count        uint32
+//	runs             per run: address uint32, length uint32, uint16 per word
+//	device count     uint16
+//	devices          per device: ID uint32, version uint16,
+//	                 manufacturer uint32, state length uint32, state bytes
+func (state *D16MachineState) Save(w io.Writer) error {
+	s, err := state.Snapshot()
+	if err != nil {
+		return err
+	}
+	return s.Write(w)
+}
+
+// Load reads a snapshot in the binary snapshot format, and restores it.
+func (state *D16MachineState) Load(r io.Reader) error {
+	s, err := ReadSnapshot(r)
+	if err != nil {
+		return err
+	}
+	return state.Restore(s)
+}
+
+// SaveJSON writes a snapshot of the machine as JSON.
+func (state *D16MachineState) SaveJSON(w io.Writer) error {
+	s, err := state.Snapshot()
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(s)
+}
+
+// LoadJSON reads a snapshot as JSON, and restores it.
+func (state *D16MachineState) LoadJSON(r io.Reader) error {
+	var s Snapshot
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return err
+	}
+	return state.Restore(&s)
+}
+
+// snapshotWriter writes little-endian values, retaining the first error.
+type snapshotWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (sw *snapshotWriter) write(v interface{}) {
+	if sw.err == nil {
+		sw.err = binary.Write(sw.w, binary.LittleEndian, v)
+	}
+}
+
+// Write writes the snapshot in the binary snapshot format (see Save).
+func (s *Snapshot) Write(w io.Writer) error {
+	sw := snapshotWriter{w: bufio.NewWriter(w)}
+	sw.write([]byte(snapshotMagic))
+	sw.write(s.Version)
+	sw.write(s.Registers)
+	sw.write([]Word{s.PC, s.SP, s.EX, s.IA})
+	sw.write(s.QueueInterrupts)
+	sw.write(uint16(len(s.QueuedInterrupts)))
+	sw.write(s.QueuedInterrupts)
+	sw.write(s.NumFrames)
+	if s.NumFrames != 0 {
+		sw.write(s.PageTable)
+	}
+	sw.write(uint32(len(s.Memory)))
+	for _, run := range s.Memory {
+		sw.write(run.Address)
+		sw.write(uint32(len(run.Words)))
+		sw.write(run.Words)
+	}
+	sw.write(uint16(len(s.Devices)))
+	for _, d := range s.Devices {
+		sw.write(d.ID)
+		sw.write(d.Version)
+		sw.write(d.Manufacturer)
+		sw.write(uint32(len(d.State)))
+		sw.write(d.State)
+	}
+	if sw.err != nil {
+		return sw.err
+	}
+	return sw.w.Flush()
+}
+
+// snapshotReader reads little-endian values, retaining the first error.
+type snapshotReader struct {
+	r   io.Reader
+	err error
+}
+
+func (sr *snapshotReader) read(v interface{}) {
+	if sr.err == nil {
+		sr.err = binary.Read(sr.r, binary.LittleEndian, v)
+	}
+}
+
+// readLength reads a uint32 length, which must be at most max.
+func (sr *snapshotReader) readLength(max int) int {
+	var length uint32
+	sr.read(&length)
+	if sr.err == nil && int64(length) > int64(max) {
+		sr.err = InvalidSnapshotError
+	}
+	return int(length)
+}
+
+// snapshotChunk is the number of elements allocated at a time when reading
+// runs and device states, so that a corrupt length can not allocate much more
+// memory than the data read.
+const snapshotChunk = 0x10000
+
+// readWords reads length words.
+func (sr *snapshotReader) readWords(length int) []Word {
+	var words []Word
+	for len(words) < length && sr.err == nil {
+		chunk := make([]Word, minInt(length-len(words), snapshotChunk))
+		sr.read(chunk)
+		words = append(words, chunk...)
+	}
+	return words
+}
+
+// readBytes reads length bytes.
+func (sr *snapshotReader) readBytes(length int) []byte {
+	var data []byte
+	for len(data) < length && sr.err == nil {
+		chunk := make([]byte, minInt(length-len(data), snapshotChunk))
+		sr.read(chunk)
+		data = append(data, chunk...)
+	}
+	return data
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// ReadSnapshot reads a snapshot in the binary snapshot format (see
+// D16MachineState.Save).
+func ReadSnapshot(r io.Reader) (*Snapshot, error) {
+	sr := snapshotReader{r: bufio.NewReader(r)}
+	var magic [len(snapshotMagic)]byte
+	sr.read(&magic)
+	if sr.err == nil && string(magic[:]) != snapshotMagic {
+		return nil, InvalidSnapshotError
+	}
+	s := &Snapshot{}
+	sr.read(&s.Version)
+	if sr.err == nil && s.Version != SnapshotVersion {
+		return nil, SnapshotVersionError(s.Version)
+	}
+	sr.read(&s.Registers)
+	regs := make([]Word, 4)
+	sr.read(regs)
+	s.PC, s.SP, s.EX, s.IA = regs[0], regs[1], regs[2], regs[3]
+	sr.read(&s.QueueInterrupts)
+	var queueLength uint16
+	sr.read(&queueLength)
+	if sr.err == nil && queueLength > MaxInterruptQueue {
+		return nil, InvalidSnapshotError
+	}
+	s.QueuedInterrupts = make([]Word, queueLength)
+	sr.read(s.QueuedInterrupts)
+	sr.read(&s.NumFrames)
+	if sr.err == nil && s.NumFrames > MaxFrames {
+		return nil, InvalidSnapshotError
+	}
+	memorySize := MemorySize
+	if s.NumFrames != 0 {
+		s.PageTable = make([]Word, NumPages)
+		sr.read(s.PageTable)
+		memorySize = int(s.NumFrames) * PageSize
+	}
+	numRuns := sr.readLength(memorySize)
+	remaining := memorySize // Words not yet in a run.
+	for i := 0; i < numRuns && sr.err == nil; i++ {
+		var run MemoryRun
+		sr.read(&run.Address)
+		length := sr.readLength(remaining)
+		remaining -= length
+		run.Words = sr.readWords(length)
+		s.Memory = append(s.Memory, run)
+	}
+	var numDevices uint16
+	sr.read(&numDevices)
+	for i := 0; i < int(numDevices) && sr.err == nil; i++ {
+		var d DeviceSnapshot
+		sr.read(&d.ID)
+		sr.read(&d.Version)
+		sr.read(&d.Manufacturer)
+		d.State = sr.readBytes(sr.readLength(1 << 24))
+		s.Devices = append(s.Devices, d)
+	}
+	if sr.err == io.EOF || sr.err == io.ErrUnexpectedEOF {
+		return nil, InvalidSnapshotError
+	}
+	return s, sr.err
+}
@@ -0,0 +1,238 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"runtime"
+	"testing"
+)
+
+var counterDeviceStatefulTest StatefulDevice = &counterDevice{}
+
+// counterDevice is a StatefulDevice that counts its interrupts.
+type counterDevice struct {
+	FakeDevice
+}
+
+func (d *counterDevice) SaveState() ([]byte, error) {
+	return []byte{byte(d.interrupts)}, nil
+}
+
+func (d *counterDevice) LoadState(data []byte) error {
+	if len(data) != 1 {
+		return InvalidSnapshotError
+	}
+	d.interrupts = int(data[0])
+	return nil
+}
+
+func snapshotTestMachine(paged bool) (*D16MachineState, *counterDevice) {
+	var state *D16MachineState
+	if paged {
+		state, _, _ = NewPagedMachineState(0x20)
+	} else {
+		state = &D16MachineState{}
+		state.Init()
+	}
+	device := &counterDevice{FakeDevice{id: 0x12345678, version: 2, manufacturer: 0x9abcdef0}}
+	state.AttachDevice(device)
+	return state, device
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	type Test struct {
+		Name  string
+		Paged bool
+		Save  func(state *D16MachineState, buf *bytes.Buffer) error
+		Load  func(state *D16MachineState, buf *bytes.Buffer) error
+	}
+
+	binarySave := func(state *D16MachineState, buf *bytes.Buffer) error { return state.Save(buf) }
+	binaryLoad := func(state *D16MachineState, buf *bytes.Buffer) error { return state.Load(buf) }
+	jsonSave := func(state *D16MachineState, buf *bytes.Buffer) error { return state.SaveJSON(buf) }
+	jsonLoad := func(state *D16MachineState, buf *bytes.Buffer) error { return state.LoadJSON(buf) }
+
+	tests := []Test{
+		{"binary", false, binarySave, binaryLoad},
+		{"binary paged", true, binarySave, binaryLoad},
+		{"JSON", false, jsonSave, jsonLoad},
+		{"JSON paged", true, jsonSave, jsonLoad},
+	}
+
+	for _, test := range tests {
+		saved, savedDevice := snapshotTestMachine(test.Paged)
+		for i := RegA; i <= RegJ; i++ {
+			saved.WriteRegister(i, Word(i)+1)
+		}
+		saved.WritePC(0x1234)
+		saved.WriteSP(0xfff0)
+		saved.WriteEX(0x5555)
+		saved.WriteIA(0x0100)
+		saved.WriteQueueInterrupts(true)
+		saved.TriggerInterrupt(0x0011)
+		saved.TriggerInterrupt(0x0022)
+		saved.WriteMemory(0x0000, 0xaaaa)
+		saved.WriteMemory(0x0010, 0xbbbb)
+		saved.WriteMemory(0xffff, 0xcccc)
+		if paged, ok := saved.Memory.(*PagedMemory); ok {
+			paged.WriteBacking(0x1f000, 0xdddd)
+			paged.MapPage(0x3, 0x1f)
+		}
+		savedDevice.interrupts = 7
+
+		var buf bytes.Buffer
+		if err := test.Save(saved, &buf); err != nil {
+			t.Errorf("%s: save failed: %v", test.Name, err)
+			continue
+		}
+		loaded, loadedDevice := snapshotTestMachine(test.Paged)
+		loaded.WriteMemory(0x0020, 0xeeee)
+		if err := test.Load(loaded, &buf); err != nil {
+			t.Errorf("%s: load failed: %v", test.Name, err)
+			continue
+		}
+
+		if !CPUEquals(&saved.D16CPU, &loaded.D16CPU) {
+			t.Errorf("%s: loaded CPU %#v, expected %#v", test.Name, loaded.D16CPU, saved.D16CPU)
+		}
+		if !loaded.QueueInterrupts() || !wordsEqual(loaded.QueuedInterrupts(), []Word{0x0011, 0x0022}) {
+			t.Errorf("%s: loaded interrupt queue %v", test.Name, loaded.QueuedInterrupts())
+		}
+		for address := 0; address < MemorySize; address++ {
+			if r, e := loaded.ReadMemory(Word(address)), saved.ReadMemory(Word(address)); r != e {
+				t.Errorf("%s: loaded 0x%04x at 0x%04x, expected 0x%04x", test.Name, r, address, e)
+			}
+		}
+		if r := loaded.ReadMemory(0x3000); test.Paged && r != 0xdddd {
+			t.Errorf("%s: loaded 0x%04x from remapped page, expected 0xdddd", test.Name, r)
+		}
+		if loadedDevice.interrupts != 7 {
+			t.Errorf("%s: loaded device state %d, expected 7", test.Name, loadedDevice.interrupts)
+		}
+	}
+}
+
+func TestSnapshotMemoryRuns(t *testing.T) {
+	var state D16MachineState
+	state.Init()
+	copy(state.D16MemoryState.Data[0x100:], []Word{1, 2, 0, 0, 3})
+	state.D16MemoryState.Data[0x200] = 4
+
+	s, err := state.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot returned error %v", err)
+	}
+	if len(s.Memory) != 2 {
+		t.Fatalf("got %d memory runs, expected 2: %#v", len(s.Memory), s.Memory)
+	}
+	if s.Memory[0].Address != 0x100 || !wordsEqual(s.Memory[0].Words, []Word{1, 2, 0, 0, 3}) {
+		t.Errorf("got first run %#v", s.Memory[0])
+	}
+	if s.Memory[1].Address != 0x200 || !wordsEqual(s.Memory[1].Words, []Word{4}) {
+		t.Errorf("got second run %#v", s.Memory[1])
+	}
+}
+
+func TestSnapshotLoadErrors(t *testing.T) {
+	state, _ := snapshotTestMachine(false)
+	var buf bytes.Buffer
+	if err := state.Save(&buf); err != nil {
+		t.Fatalf("Save returned error %v", err)
+	}
+	data := buf.Bytes()
+
+	type Test struct {
+		Name     string
+		Data     []byte
+		Machine  *D16MachineState
+		Expected error
+	}
+
+	badVersion := append([]byte(nil), data...)
+	badVersion[len(snapshotMagic)] = 99
+	memory, _ := NewPagedMemory(NumPages)
+	paged := &D16MachineState{Memory: memory}
+	paged.Init()
+	paged.AttachDevice(state.Devices[0])
+
+	tests := []Test{
+		{"bad magic", []byte("NOTASNAPSHOT"), state, InvalidSnapshotError},
+		{"bad version", badVersion, state, SnapshotVersionError(99)},
+		{"truncated", data[:len(data)-4], state, InvalidSnapshotError},
+		{"no devices", data, &D16MachineState{}, SnapshotDeviceError(0)},
+		{"different memory", data, paged, InvalidSnapshotError},
+	}
+
+	for _, test := range tests {
+		if err := test.Machine.Load(bytes.NewReader(test.Data)); err != test.Expected {
+			t.Errorf("%s: got error %v, expected %v", test.Name, err, test.Expected)
+		}
+	}
+}
+
+func TestRestoreDeviceError(t *testing.T) {
+	for _, paged := range []bool{false, true} {
+		state, _ := snapshotTestMachine(paged)
+		state.WriteMemory(0x0100, 1)
+		state.WriteRegister(RegA, 2)
+		s, err := state.Snapshot()
+		if err != nil {
+			t.Fatalf("Snapshot returned error %v", err)
+		}
+		s.Devices[len(s.Devices)-1].State = nil
+
+		state.WriteMemory(0x0100, 3)
+		state.WriteRegister(RegA, 4)
+		state.TriggerInterrupt(5)
+		if err := state.Restore(s); err != InvalidSnapshotError {
+			t.Errorf("paged=%v: got error %v, expected %v", paged, err, InvalidSnapshotError)
+		}
+		if state.PeekMemory(0x0100) != 3 || state.Register(RegA) != 4 || len(state.QueuedInterrupts()) != 1 {
+			t.Errorf("paged=%v: machine changed by failed restore", paged)
+		}
+	}
+}
+
+func TestReadSnapshotLengths(t *testing.T) {
+	// header writes a snapshot header with no queued interrupts, and the
+	// number of frames.
+	header := func(numFrames uint32) *bytes.Buffer {
+		var buf bytes.Buffer
+		buf.WriteString(snapshotMagic)
+		binary.Write(&buf, binary.LittleEndian, uint16(SnapshotVersion))
+		buf.Write(make([]byte, 12*2+1+2))
+		binary.Write(&buf, binary.LittleEndian, numFrames)
+		if numFrames != 0 {
+			buf.Write(make([]byte, NumPages*2))
+		}
+		return &buf
+	}
+	write := func(buf *bytes.Buffer, values ...interface{}) *bytes.Buffer {
+		for _, v := range values {
+			binary.Write(buf, binary.LittleEndian, v)
+		}
+		return buf
+	}
+
+	tests := []struct {
+		Name string
+		Data *bytes.Buffer
+	}{
+		{"long run", write(header(MaxFrames), uint32(1), uint32(0), uint32(MaxFrames*PageSize))},
+		{"runs longer than memory", write(header(0), uint32(2), uint32(0), uint32(MemorySize), make([]Word, MemorySize), uint32(0), uint32(1))},
+		{"long device state", write(header(0), uint32(0), uint16(1), uint32(0), uint16(0), uint32(0), uint32(1<<24))},
+	}
+
+	for _, test := range tests {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		_, err := ReadSnapshot(test.Data)
+		runtime.ReadMemStats(&after)
+		if err != InvalidSnapshotError {
+			t.Errorf("%s: got error %v, expected %v", test.Name, err, InvalidSnapshotError)
+		}
+		if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+			t.Errorf("%s: allocated %d bytes", test.Name, allocated)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package core
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// TraceFormat is the output format of a Tracer.
+type TraceFormat uint8
+
+const (
+	// TraceText writes a line of text per instruction:
+	//
+	//	cycle pc: words instruction changes
+	//
+	// with values in hex, except the cycle. Changes are registers, as A=0001,
+	// and memory, as [1000]=0001, and an interrupt delivered before the
+	// instruction, as int=0007.
+	TraceText TraceFormat = iota
+	// TraceJSON writes a TraceRecord per instruction as JSON Lines.
+	TraceJSON
+)
+
+// TraceRegister is the value of a register changed by an instruction.
+type TraceRegister struct {
+	Name  string `json:"name"`
+	Value Word   `json:"value"`
+}
+
+// TraceMemory is the value of a word of memory changed by an instruction.
+type TraceMemory struct {
+	Address Word `json:"address"`
+	Value   Word `json:"value"`
+}
+
+// TraceRecord describes an instruction executed by Step.
+type TraceRecord struct {
+	// Cycle is the number of cycles executed before the instruction.
+	Cycle uint64 `json:"cycle"`
+	PC    Word   `json:"pc"`
+	// Interrupt is the message of an interrupt delivered before the
+	// instruction, if any.
+	Interrupt   *Word  `json:"interrupt,omitempty"`
+	Words       []Word `json:"words"`
+	Instruction string `json:"instruction"`
+	// Registers are those changed by the instruction (and by delivering an
+	// interrupt), other than PC, in the order A to J, SP, EX, IA.
+	Registers []TraceRegister `json:"registers,omitempty"`
+	// Memory are the words written, with their final values, in the order
+	// first written.
+	Memory []TraceMemory `json:"memory,omitempty"`
+}
+
+// traceRegisterNames are the names of the registers in undo record order.
+var traceRegisterNames = [numUndoRegisters]string{"A", "B", "C", "X", "Y", "Z", "I", "J", "PC", "SP", "EX", "IA"}
+
+// Tracer writes a record of each instruction executed by the Step of a
+// D16MachineState that it is set as the Trace of. Records are buffered until
+// Flush is called.
+type Tracer struct {
+	w      *bufio.Writer
+	format TraceFormat
+	err    error
+
+	tracing bool
+	record  TraceRecord
+	message Word
+	cpu     D16CPU
+}
+
+// NewTracer creates a Tracer that writes to w in the format.
+func NewTracer(w io.Writer, format TraceFormat) *Tracer {
+	return &Tracer{w: bufio.NewWriter(w), format: format}
+}
+
+// Flush writes any buffered records, and returns the first error from
+// writing.
+func (t *Tracer) Flush() error {
+	if t.err == nil {
+		t.err = t.w.Flush()
+	}
+	return t.err
+}
+
+func (t *Tracer) begin(state *D16MachineState) {
+	pc := state.PC()
+	if t.tracing {
+		// Called again after delivering an interrupt.
+		t.message = state.Register(RegA)
+		t.record.Interrupt = &t.message
+	} else {
+		t.tracing = true
+		t.cpu = state.D16CPU
+		t.record = TraceRecord{Cycle: state.cycles, Memory: t.record.Memory[:0], Registers: t.record.Registers[:0]}
+	}
+	decoded, words := decodeAt(state, pc)
+	t.record.PC = pc
+	t.record.Words = words
+	t.record.Instruction = ""
+	if decoded.Info != nil {
+		t.record.Instruction = decoded.String()
+	}
+}
+
+func (t *Tracer) recordWrite(address, value Word) {
+	if !t.tracing {
+		return
+	}
+	for i := range t.record.Memory {
+		if t.record.Memory[i].Address == address {
+			t.record.Memory[i].Value = value
+			return
+		}
+	}
+	t.record.Memory = append(t.record.Memory, TraceMemory{address, value})
+}
+
+func (t *Tracer) end(state *D16MachineState) error {
+	if !t.tracing {
+		return nil
+	}
+	t.tracing = false
+	for i := Word(0); i < numUndoRegisters; i++ {
+		if i == undoPC {
+			continue
+		}
+		if value := *state.D16CPU.cpuRegister(i); value != *t.cpu.cpuRegister(i) {
+			t.record.Registers = append(t.record.Registers, TraceRegister{traceRegisterNames[i], value})
+		}
+	}
+	if t.err != nil {
+		return t.err
+	}
+	switch t.format {
+	case TraceJSON:
+		var data []byte
+		if data, t.err = json.Marshal(&t.record); t.err == nil {
+			data = append(data, '\n')
+			_, t.err = t.w.Write(data)
+		}
+	default:
+		_, t.err = io.WriteString(t.w, t.record.String()+"\n")
+	}
+	return t.err
+}
+
+// String formats the record as a line of TraceText.
+func (r *TraceRecord) String() string {
+	words := make([]string, len(r.Words))
+	for i, w := range r.Words {
+		words[i] = fmt.Sprintf("%04x", w)
+	}
+	line := fmt.Sprintf("%8d %04x: %-14s %-24s", r.Cycle, r.PC, strings.Join(words, " "), r.Instruction)
+	if r.Interrupt != nil {
+		line += fmt.Sprintf(" int=%04x", *r.Interrupt)
+	}
+	for _, reg := range r.Registers {
+		line += fmt.Sprintf(" %s=%04x", reg.Name, reg.Value)
+	}
+	for _, mem := range r.Memory {
+		line += fmt.Sprintf(" [%04x]=%04x", mem.Address, mem.Value)
+	}
+	return strings.TrimRight(line, " ")
+}
@@ -0,0 +1,76 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestTraceText(t *testing.T) {
+	var buf bytes.Buffer
+	state := &D16MachineState{Trace: NewTracer(&buf, TraceText)}
+	runUndoTest(t, state, 7)
+	if err := state.Trace.Flush(); err != nil {
+		t.Fatalf("Flush returned error %v", err)
+	}
+
+	expected := "" +
+		"       0 0000: c540           IAS 16                   IA=0010\n" +
+		"       1 0001: 9001           SET A, 3                 A=0003\n" +
+		"       2 0002: a100           INT 7\n" +
+		"       6 0010: 03c1 2000      SET [0x2000], A          int=0007 A=0007 SP=fffd [fffe]=0003 [fffd]=0003 [2000]=0007\n" +
+		"       8 0012: 8560           RFI 0                    A=0003 SP=ffff\n" +
+		"      11 0003: 0301           SET PUSH, A              SP=fffe [fffe]=0003\n" +
+		"      12 0004: 8803           SUB A, 1                 A=0002\n"
+	if buf.String() != expected {
+		t.Errorf("got trace:\n%s\nexpected:\n%s", buf.String(), expected)
+	}
+}
+
+func TestTraceJSON(t *testing.T) {
+	var buf bytes.Buffer
+	state := &D16MachineState{Trace: NewTracer(&buf, TraceJSON)}
+	runUndoTest(t, state, 4)
+	if err := state.Trace.Flush(); err != nil {
+		t.Fatalf("Flush returned error %v", err)
+	}
+
+	var records []TraceRecord
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var record TraceRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("invalid JSON line %q: %v", scanner.Text(), err)
+		}
+		records = append(records, record)
+	}
+	if len(records) != 4 {
+		t.Fatalf("got %d records, expected 4", len(records))
+	}
+
+	record := records[3]
+	if record.Cycle != 6 || record.PC != 0x0010 || record.Instruction != "SET [0x2000], A" ||
+		!wordsEqual(record.Words, []Word{0x03c1, 0x2000}) {
+		t.Errorf("got record %#v", record)
+	}
+	if record.Interrupt == nil || *record.Interrupt != 0x0007 {
+		t.Errorf("got interrupt %v, expected 0x0007", record.Interrupt)
+	}
+	expRegisters := []TraceRegister{{"A", 0x0007}, {"SP", 0xfffd}}
+	if len(record.Registers) != len(expRegisters) || record.Registers[0] != expRegisters[0] || record.Registers[1] != expRegisters[1] {
+		t.Errorf("got registers %v, expected %v", record.Registers, expRegisters)
+	}
+	expMemory := []TraceMemory{{0xfffe, 0x0003}, {0xfffd, 0x0003}, {0x2000, 0x0007}}
+	if len(record.Memory) != len(expMemory) {
+		t.Fatalf("got memory %v, expected %v", record.Memory, expMemory)
+	}
+	for i := range expMemory {
+		if record.Memory[i] != expMemory[i] {
+			t.Errorf("got memory %v, expected %v", record.Memory, expMemory)
+		}
+	}
+	if records[0].Interrupt != nil {
+		t.Errorf("got interrupt %v without delivery", *records[0].Interrupt)
+	}
+}
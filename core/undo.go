@@ -0,0 +1,376 @@
+package core
+
+import (
+	"errors"
+)
+
+// UndoExhaustedError is returned when stepping back further than the undo log
+// has recorded.
+var UndoExhaustedError = errors.New("no more steps to undo")
+
+// NoSuchCheckpointError is returned when restoring a checkpoint that the undo
+// log does not hold.
+var NoSuchCheckpointError = errors.New("no such checkpoint")
+
+// minUndoCapacity is the minimum number of records in an UndoLog, enough for
+// the steps of most instructions.
+const minUndoCapacity = 64
+
+type undoKind uint8
+
+const (
+	// undoStep starts the records of a step. index is the head of the
+	// interrupt queue, and value its length.
+	undoStep undoKind = iota
+	// undoRegister records the old value of register index (see cpuRegister).
+	undoRegister
+	// undoMemory records the old value of memory at address index.
+	undoMemory
+	// undoQueueing records the old value of interrupt queueing, 1 if on.
+	undoQueueing
+	// undoInterrupt records a message removed from the interrupt queue.
+	undoInterrupt
+	// undoCycles records the cycles taken by the step, with the high word in
+	// index.
+	undoCycles
+)
+
+type undoRecord struct {
+	kind  undoKind
+	index Word
+	value Word
+}
+
+// Indices of the special registers in undo records, after RegA to RegJ.
+const (
+	undoPC = iota + 8
+	undoSP
+	undoEX
+	undoIA
+	numUndoRegisters
+)
+
+// cpuRegister returns the register of the CPU at the undo record index.
+func (cpu *D16CPU) cpuRegister(index Word) *Word {
+	switch index {
+	case undoPC:
+		return &cpu.pc
+	case undoSP:
+		return &cpu.sp
+	case undoEX:
+		return &cpu.ex
+	case undoIA:
+		return &cpu.ia
+	}
+	return &cpu.registers[index]
+}
+
+type undoCheckpoint struct {
+	position uint64
+	cycles   uint64
+	snapshot *Snapshot
+}
+
+// UndoLog records the changes made by each Step of a D16MachineState, so that
+// the steps can be undone. It holds register changes, and memory writes with
+// their old values, in a ring buffer that discards the oldest steps when full.
+// Changes made between steps are not recorded, except that undoing a step also
+// removes interrupts triggered since the previous step.
+//
+// Memory is restored through the machine's memory, so writes to memory
+// mapped regions are undone by writing the old value to the handler. Device
+// state and page mappings are not restored by undoing steps.
+type UndoLog struct {
+	// CheckpointInterval is the number of steps between checkpoints, which are
+	// snapshots of the machine from which it can be restored after the steps
+	// have been discarded from the log. If 0, no checkpoints are taken.
+	CheckpointInterval int
+	// MaxCheckpoints is the number of checkpoints kept, discarding the oldest.
+	// At least one is kept.
+	MaxCheckpoints int
+
+	records  []undoRecord // Ring buffer.
+	start    int          // Index of the oldest record.
+	length   int          // Number of records.
+	steps    int          // Number of undoStep records.
+	position uint64       // Number of steps executed, less those undone.
+
+	recording bool // Between BeginInstruction and EndInstruction.
+	overflow  bool // The current step did not fit in the log.
+	cpu       D16CPU
+	queueing  bool
+
+	checkpoints []undoCheckpoint
+	events      []WatchEvent
+}
+
+// NewUndoLog creates an UndoLog that holds up to capacity records. Each step
+// takes one record, plus one per changed register or written word.
+func NewUndoLog(capacity int) *UndoLog {
+	if capacity < minUndoCapacity {
+		capacity = minUndoCapacity
+	}
+	return &UndoLog{records: make([]undoRecord, capacity)}
+}
+
+// Steps returns the number of steps that can be undone.
+func (log *UndoLog) Steps() int {
+	return log.steps
+}
+
+// Position returns the number of steps recorded, less those undone.
+func (log *UndoLog) Position() uint64 {
+	return log.position
+}
+
+// Checkpoints returns the positions of the checkpoints, oldest first.
+func (log *UndoLog) Checkpoints() []uint64 {
+	positions := make([]uint64, len(log.checkpoints))
+	for i, checkpoint := range log.checkpoints {
+		positions[i] = checkpoint.position
+	}
+	return positions
+}
+
+// clear discards all records.
+func (log *UndoLog) clear() {
+	log.start, log.length, log.steps = 0, 0, 0
+}
+
+// reset discards all records and checkpoints, and returns to position zero.
+func (log *UndoLog) reset() {
+	log.clear()
+	log.checkpoints = nil
+	log.position = 0
+}
+
+func (log *UndoLog) push(record undoRecord) {
+	if log.overflow {
+		return
+	}
+	if log.length == len(log.records) {
+		log.dropOldestStep()
+		if log.overflow {
+			return
+		}
+	}
+	log.records[(log.start+log.length)%len(log.records)] = record
+	log.length++
+	if record.kind == undoStep {
+		log.steps++
+	}
+}
+
+func (log *UndoLog) pop() undoRecord {
+	log.length--
+	record := log.records[(log.start+log.length)%len(log.records)]
+	if record.kind == undoStep {
+		log.steps--
+	}
+	return record
+}
+
+// dropOldestStep discards the records of the oldest step. If that is the
+// current step then it is too large to record, and the log is cleared.
+func (log *UndoLog) dropOldestStep() {
+	if log.steps == 1 && log.recording {
+		log.clear()
+		log.overflow = true
+		return
+	}
+	log.steps--
+	for {
+		log.start = (log.start + 1) % len(log.records)
+		log.length--
+		if log.length == 0 || log.records[log.start].kind == undoStep {
+			return
+		}
+	}
+}
+
+func (log *UndoLog) begin(state *D16MachineState) {
+	if log.recording {
+		// Called again after delivering an interrupt.
+		return
+	}
+	log.recording = true
+	log.cpu = state.D16CPU
+	log.queueing = state.D16Interrupts.queueing
+	log.push(undoRecord{undoStep, Word(state.D16Interrupts.head), Word(state.D16Interrupts.length)})
+}
+
+func (log *UndoLog) end(state *D16MachineState, ticks int) {
+	if !log.recording {
+		return
+	}
+	if ticks != 0 {
+		high, low := DWord(ticks).Split()
+		log.push(undoRecord{undoCycles, high, low})
+	}
+	for i := Word(0); i < numUndoRegisters; i++ {
+		if old := *log.cpu.cpuRegister(i); old != *state.D16CPU.cpuRegister(i) {
+			log.push(undoRecord{undoRegister, i, old})
+		}
+	}
+	if log.queueing != state.D16Interrupts.queueing {
+		var old Word
+		if log.queueing {
+			old = 1
+		}
+		log.push(undoRecord{undoQueueing, 0, old})
+	}
+	log.recording = false
+	log.overflow = false
+	log.position++
+	if log.CheckpointInterval > 0 && log.position%uint64(log.CheckpointInterval) == 0 {
+		log.checkpoint(state)
+	}
+}
+
+// checkpoint takes a checkpoint, unless the machine cannot be saved.
+func (log *UndoLog) checkpoint(state *D16MachineState) {
+	snapshot, err := state.Snapshot()
+	if err != nil {
+		return
+	}
+	if len(log.checkpoints) > 0 && len(log.checkpoints) >= log.MaxCheckpoints {
+		log.checkpoints = append(log.checkpoints[:0], log.checkpoints[1:]...)
+	}
+	log.checkpoints = append(log.checkpoints, undoCheckpoint{log.position, state.cycles, snapshot})
+}
+
+// recordWrite records the old value of memory before it is written.
+func (log *UndoLog) recordWrite(address, old Word) {
+	log.push(undoRecord{undoMemory, address, old})
+}
+
+// recordInterrupt records a message removed from the interrupt queue.
+func (log *UndoLog) recordInterrupt(message Word) {
+	if log.recording {
+		log.push(undoRecord{undoInterrupt, 0, message})
+	}
+}
+
+// NextInterrupt removes the oldest queued interrupt message, recording it in
+// the undo log.
+func (state *D16MachineState) NextInterrupt() (Word, bool) {
+	message, ok := state.D16Interrupts.NextInterrupt()
+	if ok && state.Undo != nil {
+		state.Undo.recordInterrupt(message)
+	}
+	return message, ok
+}
+
+// undoStep undoes the most recent step. If watch is true then it returns the
+// events that the step would trigger on watchpoints for writes and for
+// execution at the address of the step.
+func (state *D16MachineState) undoStep(watch bool) ([]WatchEvent, bool) {
+	log := state.Undo
+	if log == nil || log.steps == 0 || log.recording {
+		return nil, false
+	}
+	log.events = log.events[:0]
+	for {
+		record := log.pop()
+		switch record.kind {
+		case undoStep:
+			state.D16Interrupts.head = int(record.index)
+			state.D16Interrupts.length = int(record.value)
+			log.position--
+			for len(log.checkpoints) > 0 && log.checkpoints[len(log.checkpoints)-1].position > log.position {
+				log.checkpoints = log.checkpoints[:len(log.checkpoints)-1]
+			}
+			if watch && state.D16Watchpoints.watched(state.PC()) {
+				value := state.PeekMemory(state.PC())
+				log.events = append(log.events, WatchEvent{WatchExecute, state.PC(), state.PC(), value, value})
+			}
+			return log.events, true
+		case undoRegister:
+			*state.D16CPU.cpuRegister(record.index) = record.value
+		case undoMemory:
+			if watch && state.D16Watchpoints.watched(record.index) {
+				value := state.PeekMemory(record.index)
+				log.events = append(log.events, WatchEvent{WatchWrite, record.index, state.PC(), record.value, value})
+			}
+			state.pokeMemory(record.index, record.value)
+		case undoQueueing:
+			state.D16Interrupts.queueing = record.value != 0
+		case undoCycles:
+			state.cycles -= uint64(record.index)<<16 | uint64(record.value)
+		case undoInterrupt:
+			head := (state.D16Interrupts.head + MaxInterruptQueue - 1) % MaxInterruptQueue
+			state.D16Interrupts.queue[head] = record.value
+			state.D16Interrupts.head = head
+		}
+	}
+}
+
+// StepBack undoes up to n steps recorded by the undo log. Returns the number
+// of steps undone, and UndoExhaustedError if fewer than n.
+func (state *D16MachineState) StepBack(n int) (int, error) {
+	for i := 0; i < n; i++ {
+		if _, ok := state.undoStep(false); !ok {
+			return i, UndoExhaustedError
+		}
+	}
+	return n, nil
+}
+
+// RunBack undoes steps until the PC is at one of the breakpoints, or until
+// undoing a step whose writes or execution trigger a watchpoint that pauses
+// execution. Watchpoints are given the event as it occurred when executing
+// forwards. Returns the number of steps undone, and a *WatchpointError if
+// stopped by a watchpoint, or UndoExhaustedError if the log is exhausted.
+func (state *D16MachineState) RunBack(breakpoints ...Word) (int, error) {
+	for steps := 0; ; {
+		events, ok := state.undoStep(true)
+		if !ok {
+			return steps, UndoExhaustedError
+		}
+		steps++
+		var err error
+		for i := len(events) - 1; i >= 0; i-- {
+			if state.D16Watchpoints.trigger(state, events[i]) && err == nil {
+				err = &WatchpointError{events[i]}
+			}
+		}
+		if err != nil {
+			return steps, err
+		}
+		for _, address := range breakpoints {
+			if state.PC() == address {
+				return steps, nil
+			}
+		}
+	}
+}
+
+// RestoreCheckpoint returns the machine to the state at the checkpoint at the
+// position. Steps still in the log are undone, otherwise the machine is
+// restored from the checkpoint and the log is cleared.
+func (state *D16MachineState) RestoreCheckpoint(position uint64) error {
+	log := state.Undo
+	if log == nil {
+		return NoSuchCheckpointError
+	}
+	for _, checkpoint := range log.checkpoints {
+		if checkpoint.position != position {
+			continue
+		}
+		if log.position-position <= uint64(log.steps) {
+			_, err := state.StepBack(int(log.position - position))
+			return err
+		}
+		if err := state.restore(checkpoint.snapshot); err != nil {
+			return err
+		}
+		log.clear()
+		state.cycles = checkpoint.cycles
+		log.position = position
+		for len(log.checkpoints) > 0 && log.checkpoints[len(log.checkpoints)-1].position > position {
+			log.checkpoints = log.checkpoints[:len(log.checkpoints)-1]
+		}
+		return nil
+	}
+	return NoSuchCheckpointError
+}
@@ -0,0 +1,194 @@
+package core
+
+import (
+	"testing"
+)
+
+// undoTestProgram loops three times, triggering an interrupt each time whose
+// handler writes the message to 0x2000.
+var undoTestProgram = []Word{
+	0xc540,                 // 0x0000: IAS 0x0010
+	0x9001,                 // 0x0001: SET A, 3
+	0xa100,                 // 0x0002: INT 7
+	0x0301,                 // 0x0003: SET PUSH, A
+	0x8803,                 // 0x0004: SUB A, 1
+	0x8413,                 // 0x0005: IFN A, 0
+	0x8f81,                 // 0x0006: SET PC, 2
+	0xa381,                 // 0x0007: SET PC, 7
+	0, 0, 0, 0, 0, 0, 0, 0, // 0x0008
+	0x03c1, 0x2000, // 0x0010: SET [0x2000], A
+	0x8560, // 0x0012: RFI 0
+}
+
+// undoTestState is the state of a machine at a step.
+type undoTestState struct {
+	cycles     uint64
+	cpu        D16CPU
+	interrupts D16Interrupts
+	memory     [MemorySize]Word
+}
+
+func (s *undoTestState) check(t *testing.T, name string, state *D16MachineState) {
+	if s.cycles != state.Cycles() {
+		t.Errorf("%s: got %d cycles, expected %d", name, state.Cycles(), s.cycles)
+	}
+	if !CPUEquals(&s.cpu, &state.D16CPU) {
+		t.Errorf("%s: got CPU %#v, expected %#v", name, state.D16CPU, s.cpu)
+	}
+	if !InterruptsEquals(&s.interrupts, &state.D16Interrupts) {
+		t.Errorf("%s: got interrupts %v, expected %v", name, state.QueuedInterrupts(), s.interrupts.QueuedInterrupts())
+	}
+	if s.memory != state.D16MemoryState.Data {
+		t.Errorf("%s: memory differs", name)
+	}
+}
+
+// runUndoTest runs the program for numSteps steps, returning the states before
+// each step and after the last.
+func runUndoTest(t *testing.T, state *D16MachineState, numSteps int) []*undoTestState {
+	state.Init()
+	copy(state.D16MemoryState.Data[:], undoTestProgram)
+	var states []*undoTestState
+	for i := 0; ; i++ {
+		states = append(states, &undoTestState{state.Cycles(), state.D16CPU, state.D16Interrupts, state.D16MemoryState.Data})
+		if i == numSteps {
+			return states
+		}
+		if _, err := Step(state); err != nil {
+			t.Fatalf("step %d: unexpected error: %v", i, err)
+		}
+	}
+}
+
+func TestStepBack(t *testing.T) {
+	const numSteps = 30
+	state := &D16MachineState{Undo: NewUndoLog(1000)}
+	states := runUndoTest(t, state, numSteps)
+
+	if state.Undo.Steps() != numSteps {
+		t.Fatalf("log holds %d steps, expected %d", state.Undo.Steps(), numSteps)
+	}
+	for i := numSteps - 1; i >= 0; i-- {
+		if n, err := state.StepBack(1); n != 1 || err != nil {
+			t.Fatalf("StepBack to %d returned %d, %v", i, n, err)
+		}
+		states[i].check(t, "StepBack", state)
+	}
+	if n, err := state.StepBack(1); n != 0 || err != UndoExhaustedError {
+		t.Errorf("StepBack beyond log returned %d, %v", n, err)
+	}
+
+	// Steps are recorded again after stepping back.
+	for i := 0; i < 5; i++ {
+		Step(state)
+	}
+	if n, err := state.StepBack(5); n != 5 || err != nil {
+		t.Errorf("StepBack after stepping again returned %d, %v", n, err)
+	}
+	states[0].check(t, "StepBack after stepping again", state)
+}
+
+func TestUndoLogCapacity(t *testing.T) {
+	const numSteps = 100
+	state := &D16MachineState{Undo: NewUndoLog(minUndoCapacity)}
+	states := runUndoTest(t, state, numSteps)
+
+	steps := state.Undo.Steps()
+	if steps == 0 || steps >= numSteps {
+		t.Fatalf("log holds %d steps", steps)
+	}
+	if n, err := state.StepBack(numSteps); n != steps || err != UndoExhaustedError {
+		t.Errorf("StepBack returned %d, %v, expected %d, UndoExhaustedError", n, err, steps)
+	}
+	states[numSteps-steps].check(t, "StepBack", state)
+	if position := state.Undo.Position(); position != uint64(numSteps-steps) {
+		t.Errorf("got position %d, expected %d", position, numSteps-steps)
+	}
+}
+
+func TestRunBack(t *testing.T) {
+	const numSteps = 30
+	state := &D16MachineState{Undo: NewUndoLog(1000)}
+	states := runUndoTest(t, state, numSteps)
+
+	// The last RFI.
+	n, err := state.RunBack(0x0012)
+	if err != nil {
+		t.Fatalf("RunBack to breakpoint returned error %v", err)
+	}
+	states[numSteps-n].check(t, "RunBack to breakpoint", state)
+	if state.PC() != 0x0012 {
+		t.Errorf("stopped at PC 0x%04x", state.PC())
+	}
+
+	var events []WatchEvent
+	state.AddWatchpoint(0x2000, 1, WatchWrite, func(_ MachineState, event WatchEvent) bool {
+		events = append(events, event)
+		return true
+	})
+	position := state.Undo.Position()
+	_, err = state.RunBack()
+	watchErr, ok := err.(*WatchpointError)
+	if !ok {
+		t.Fatalf("expected *WatchpointError, got %v", err)
+	}
+	expEvent := WatchEvent{Access: WatchWrite, Address: 0x2000, PC: state.PC(), Old: 0x0007, New: 0x0007}
+	if watchErr.Event != expEvent || len(events) != 1 {
+		t.Errorf("got event %#v, expected %#v", watchErr.Event, expEvent)
+	}
+	states[state.Undo.Position()].check(t, "RunBack to watchpoint", state)
+	// The handler is delivered in the step before RFI.
+	if position-state.Undo.Position() != 1 {
+		t.Errorf("RunBack to watchpoint undid %d steps", position-state.Undo.Position())
+	}
+}
+
+func TestRestoreCheckpoint(t *testing.T) {
+	const numSteps = 100
+	state := &D16MachineState{Undo: NewUndoLog(minUndoCapacity)}
+	state.Undo.CheckpointInterval = 10
+	state.Undo.MaxCheckpoints = 5
+	states := runUndoTest(t, state, numSteps)
+
+	checkpoints := state.Undo.Checkpoints()
+	if len(checkpoints) != 5 || checkpoints[0] != 60 || checkpoints[4] != 100 {
+		t.Fatalf("got checkpoints %v", checkpoints)
+	}
+	if err := state.RestoreCheckpoint(90); err != nil {
+		t.Fatalf("RestoreCheckpoint(90) returned error %v", err)
+	}
+	states[90].check(t, "RestoreCheckpoint(90)", state)
+	if err := state.RestoreCheckpoint(60); err != nil {
+		t.Fatalf("RestoreCheckpoint(60) returned error %v", err)
+	}
+	states[60].check(t, "RestoreCheckpoint(60)", state)
+	if checkpoints := state.Undo.Checkpoints(); len(checkpoints) != 1 {
+		t.Errorf("got checkpoints %v after restoring, expected [60]", checkpoints)
+	}
+	if err := state.RestoreCheckpoint(90); err != NoSuchCheckpointError {
+		t.Errorf("RestoreCheckpoint of discarded checkpoint returned %v", err)
+	}
+}
+
+func TestRestoreResetsUndo(t *testing.T) {
+	state := &D16MachineState{Undo: NewUndoLog(minUndoCapacity)}
+	state.Undo.CheckpointInterval = 10
+	runUndoTest(t, state, 50)
+	s, err := state.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot returned error %v", err)
+	}
+
+	if err := state.Restore(s); err != nil {
+		t.Fatalf("Restore returned error %v", err)
+	}
+	if steps, position := state.Undo.Steps(), state.Undo.Position(); steps != 0 || position != 0 {
+		t.Errorf("got %d steps at position %d after Restore, expected none at 0", steps, position)
+	}
+	if checkpoints := state.Undo.Checkpoints(); len(checkpoints) != 0 {
+		t.Errorf("got checkpoints %v after Restore, expected none", checkpoints)
+	}
+	if err := state.RestoreCheckpoint(40); err != NoSuchCheckpointError {
+		t.Errorf("RestoreCheckpoint of checkpoint before Restore returned %v", err)
+	}
+}
@@ -11,11 +11,71 @@ func (err ValueCodeError) Error() string {
 	return fmt.Sprintf("invalid value code 0x%02x", Word(err))
 }
 
+func (err ValueCodeError) faultClass() FaultClass {
+	return IllegalOperandFault
+}
+
 var ValueContextError = errors.New("value cannot be used in that context")
 
+// LocationKind identifies where the word of a resolved value is stored.
+type LocationKind uint8
+
+const (
+	LiteralLocation  LocationKind = iota // constant, writes are ignored
+	RegisterLocation                     // general register
+	MemoryLocation                       // memory address
+	SPLocation                           // SP register
+	PCLocation                           // PC register
+	EXLocation                           // EX register
+)
+
+// Location is the effective location of a value, as resolved for a single
+// execution of an instruction. Reading and writing a Location has no side
+// effects beyond the access itself.
+type Location struct {
+	Kind LocationKind
+	Reg  RegisterId // Register of a RegisterLocation.
+	Word Word       // Address of a MemoryLocation, or the literal value.
+}
+
+func (l Location) Read(state MachineState) Word {
+	switch l.Kind {
+	case RegisterLocation:
+		return state.Register(l.Reg)
+	case MemoryLocation:
+		return state.ReadMemory(l.Word)
+	case SPLocation:
+		return state.SP()
+	case PCLocation:
+		return state.PC()
+	case EXLocation:
+		return state.EX()
+	}
+	return l.Word
+}
+
+func (l Location) Write(state MachineState, word Word) {
+	switch l.Kind {
+	case RegisterLocation:
+		state.WriteRegister(l.Reg, word)
+	case MemoryLocation:
+		state.WriteMemory(l.Word, word)
+	case SPLocation:
+		state.WriteSP(word)
+	case PCLocation:
+		state.WritePC(word)
+	case EXLocation:
+		state.WriteEX(word)
+	}
+	// Writes to literals are no-ops.
+}
+
 type Value interface {
-	Write(MachineState, Word)
-	Read(MachineState) Word
+	// Resolve returns the effective location of the value, performing any
+	// side effects of doing so (such as changing SP for PUSH and POP). It
+	// should be called once per execution of an instruction, with a resolved
+	// before b.
+	Resolve(MachineState) Location
 	LoadExtraWords(WordLoader) error
 	NumExtraWords() Word
 	// Create a copy of the value.
@@ -30,4 +90,8 @@ type ValueLiteral interface {
 
 type ValueSet interface {
 	Value(w Word, asValueB bool) (Value, error)
+	// Encode returns the value code for the given value, and its next word if
+	// hasNext is true. Returns ValueContextError if the value cannot be used in
+	// the given position.
+	Encode(v Value, asValueB bool) (code, next Word, hasNext bool, err error)
 }
@@ -0,0 +1,131 @@
+package core
+
+import (
+	"fmt"
+)
+
+// WatchAccess is a set of kinds of memory access.
+type WatchAccess uint8
+
+const (
+	// WatchRead is a read of data by an instruction.
+	WatchRead WatchAccess = 1 << iota
+	// WatchWrite is a write of data by an instruction.
+	WatchWrite
+	// WatchExecute is a fetch of instruction words, through WordLoad.
+	WatchExecute
+)
+
+func (a WatchAccess) String() string {
+	var s string
+	for _, access := range []struct {
+		access WatchAccess
+		name   byte
+	}{{WatchRead, 'r'}, {WatchWrite, 'w'}, {WatchExecute, 'x'}} {
+		if a&access.access != 0 {
+			s += string(access.name)
+		} else {
+			s += "-"
+		}
+	}
+	return s
+}
+
+// WatchEvent describes a memory access that triggered a watchpoint.
+type WatchEvent struct {
+	Access  WatchAccess
+	Address Word
+	// PC is the address of the instruction that made the access.
+	PC Word
+	// Old and New are the values before and after the access. They are equal
+	// except for writes.
+	Old, New Word
+}
+
+// WatchFunc is called when a watchpoint is triggered. If it returns true then
+// execution pauses after the current instruction.
+type WatchFunc func(state MachineState, event WatchEvent) (pause bool)
+
+// WatchpointError is returned by Step after executing an instruction that
+// triggered a watchpoint that paused execution.
+type WatchpointError struct {
+	Event WatchEvent
+}
+
+func (err *WatchpointError) Error() string {
+	return fmt.Sprintf("watchpoint %v at 0x%04x by instruction at PC 0x%04x",
+		err.Event.Access, err.Event.Address, err.Event.PC)
+}
+
+// WatchpointId identifies a watchpoint added to a D16MachineState.
+type WatchpointId int
+
+type watchpoint struct {
+	id     WatchpointId
+	start  Word
+	size   int
+	access WatchAccess
+	fn     WatchFunc
+}
+
+// D16Watchpoints holds the watchpoints of a D16MachineState.
+type D16Watchpoints struct {
+	watchpoints []watchpoint
+	pages       addressPages
+	nextId      WatchpointId
+}
+
+// AddWatchpoint calls fn for each of the given kinds of access to size words
+// from start.
+func (w *D16Watchpoints) AddWatchpoint(start Word, size int, access WatchAccess, fn WatchFunc) (WatchpointId, error) {
+	if !validRegion(start, size) {
+		return 0, InvalidMemoryRegionError
+	}
+	w.nextId++
+	w.watchpoints = append(w.watchpoints, watchpoint{w.nextId, start, size, access, fn})
+	w.pages.add(start, size)
+	return w.nextId, nil
+}
+
+// RemoveWatchpoint removes the watchpoint, and returns false if there is no
+// such watchpoint.
+func (w *D16Watchpoints) RemoveWatchpoint(id WatchpointId) bool {
+	for i := range w.watchpoints {
+		if w.watchpoints[i].id == id {
+			w.watchpoints = append(w.watchpoints[:i:i], w.watchpoints[i+1:]...)
+			w.pages = addressPages{}
+			for _, wp := range w.watchpoints {
+				w.pages.add(wp.start, wp.size)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// watched returns true if an access to the address might trigger a
+// watchpoint.
+func (w *D16Watchpoints) watched(address Word) bool {
+	return w.pages.contains(address)
+}
+
+// trigger calls the watchpoints for the event, and returns true if any of
+// them paused execution.
+func (w *D16Watchpoints) trigger(state MachineState, event WatchEvent) bool {
+	pause := false
+	for _, wp := range w.watchpoints {
+		if wp.access&event.Access != 0 && event.Address >= wp.start && int(event.Address-wp.start) < wp.size {
+			if wp.fn(state, event) {
+				pause = true
+			}
+		}
+	}
+	return pause
+}
+
+// fork returns a copy of the watchpoints.
+func (w *D16Watchpoints) fork() D16Watchpoints {
+	c := *w
+	c.watchpoints = w.watchpoints[:len(w.watchpoints):len(w.watchpoints)]
+	return c
+}
@@ -0,0 +1,100 @@
+package core
+
+import (
+	"testing"
+)
+
+func TestWatchpoints(t *testing.T) {
+	var state D16MachineState
+	state.Init()
+	copy(state.D16MemoryState.Data[0:], []Word{
+		0x7fc1, 0x1234, 0x1000, // SET [0x1000], 0x1234
+		0x7801, 0x1000, // SET A, [0x1000]
+	})
+
+	var dataEvents, fetchEvents []WatchEvent
+	if _, err := state.AddWatchpoint(0x1000, 1, WatchRead|WatchWrite, func(_ MachineState, event WatchEvent) bool {
+		dataEvents = append(dataEvents, event)
+		return false
+	}); err != nil {
+		t.Fatalf("AddWatchpoint returned error %v", err)
+	}
+	fetchId, err := state.AddWatchpoint(0x0003, 2, WatchExecute, func(_ MachineState, event WatchEvent) bool {
+		fetchEvents = append(fetchEvents, event)
+		return true
+	})
+	if err != nil {
+		t.Fatalf("AddWatchpoint returned error %v", err)
+	}
+
+	if _, err := Step(&state); err != nil {
+		t.Fatalf("unexpected emulation error: %v", err)
+	}
+	_, err = Step(&state)
+	watchErr, ok := err.(*WatchpointError)
+	if !ok {
+		t.Fatalf("expected *WatchpointError, got %#v", err)
+	}
+	expPause := WatchEvent{Access: WatchExecute, Address: 0x0003, PC: 0x0003, Old: 0x7801, New: 0x7801}
+	if watchErr.Event != expPause {
+		t.Errorf("paused for %#v, expected %#v", watchErr.Event, expPause)
+	}
+	if state.Register(RegA) != 0x1234 || state.PC() != 0x0005 {
+		t.Errorf("paused before completing instruction")
+	}
+
+	expData := []WatchEvent{
+		{Access: WatchWrite, Address: 0x1000, PC: 0x0000, Old: 0x0000, New: 0x1234},
+		{Access: WatchRead, Address: 0x1000, PC: 0x0003, Old: 0x1234, New: 0x1234},
+	}
+	if len(dataEvents) != len(expData) {
+		t.Fatalf("got data events %#v, expected %#v", dataEvents, expData)
+	}
+	for i := range expData {
+		if dataEvents[i] != expData[i] {
+			t.Errorf("got data event %#v, expected %#v", dataEvents[i], expData[i])
+		}
+	}
+	if len(fetchEvents) != 2 || fetchEvents[1].Address != 0x0004 {
+		t.Errorf("got fetch events %#v", fetchEvents)
+	}
+
+	if !state.RemoveWatchpoint(fetchId) {
+		t.Errorf("RemoveWatchpoint failed")
+	}
+	state.WritePC(0x0003)
+	if _, err := Step(&state); err != nil {
+		t.Errorf("unexpected error after removing watchpoint: %v", err)
+	}
+	if len(fetchEvents) != 2 {
+		t.Errorf("removed watchpoint was triggered")
+	}
+}
+
+func TestWatchpointSkipped(t *testing.T) {
+	var state D16MachineState
+	state.Init()
+	copy(state.D16MemoryState.Data[0:], []Word{
+		0x8812,                 // IFE A, 1
+		0x7fc1, 0x1234, 0x1000, // SET [0x1000], 0x1234
+	})
+	state.AddWatchpoint(0x0001, 3, WatchRead|WatchExecute, func(_ MachineState, event WatchEvent) bool {
+		t.Errorf("skipped instruction triggered watchpoint %#v", event)
+		return true
+	})
+	// Skipped instructions are not fetched for execution.
+	state.Protect(0x0001, 3, NoAccess)
+
+	if _, err := Step(&state); err != nil {
+		t.Fatalf("unexpected emulation error: %v", err)
+	}
+	if state.PC() != 0x0004 {
+		t.Errorf("expected PC 0x0004, got 0x%04x", state.PC())
+	}
+}
+
+func TestWatchAccessString(t *testing.T) {
+	if s := (WatchRead | WatchExecute).String(); s != "r-x" {
+		t.Errorf("expected \"r-x\", got %q", s)
+	}
+}